@@ -0,0 +1,272 @@
+// Package admin is the admin http api of a node, to inspect and kick the
+// channels connected, change the log level and profile the node. Every
+// request is authorized by the token of the server.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"dim"
+	"dim/logger"
+	"dim/metrics"
+	"dim/wire/pkt"
+)
+
+// CommandTest is the default command of the test messages pushed
+const CommandTest = "admin.test"
+
+// DefaultListLimit is the default number of the channels listed
+const DefaultListLimit = 100
+
+// ChannelsResp ChannelsResp
+type ChannelsResp struct {
+	Count    int                `json:"count"`
+	Channels []dim.ChannelStats `json:"channels,omitempty"`
+}
+
+// KickReq KickReq
+type KickReq struct {
+	Reason string `json:"reason"`
+}
+
+// PushReq pushes a packet of Command with Body to a channel
+type PushReq struct {
+	Command string          `json:"command"`
+	Body    json.RawMessage `json:"body"`
+}
+
+// LevelReq LevelReq
+type LevelReq struct {
+	Level string `json:"level"`
+}
+
+// Server is the admin http server, it is started by Start, or embedded in
+// another http server by Handler.
+//
+//	GET  /channels?offset=0&limit=100  list the channels
+//	GET  /channels/count               count the channels
+//	GET  /channels/{id}                get a channel
+//	POST /channels/{id}/kick           kick a channel with KickReq
+//	POST /channels/{id}/push           push a test message with PushReq
+//	PUT  /log/level                    change the log level with LevelReq
+//	GET  /metrics                      the metrics
+//	GET  /debug/pprof/                 pprof
+type Server struct {
+	listen   string
+	token    string
+	channels dim.ChannelMap
+	srv      *http.Server
+}
+
+// NewServer returns a server of the channels, a request is authorized by
+// the token in the Authorization header as a bearer token, or in the token
+// query parameter. Every request is rejected if token is empty.
+func NewServer(listen, token string, channels dim.ChannelMap) *Server {
+	s := &Server{
+		listen:   listen,
+		token:    token,
+		channels: channels,
+	}
+	s.srv = &http.Server{
+		Addr:              listen,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	return s
+}
+
+// Start Start
+func (s *Server) Start() error {
+	logger.WithFields(logger.Fields{
+		"module": "admin",
+		"listen": s.listen,
+	}).Info("started")
+	err := s.srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown Shutdown
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+// Handler returns the handler of the api
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/channels", s.listChannels)
+	mux.HandleFunc("/channels/", s.channel)
+	mux.HandleFunc("/log/level", s.setLevel)
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return s.authorize(mux)
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if s.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) listChannels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	channels := s.channels.All()
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	// ordered by the connected time, so a page is stable
+	list := make([]dim.ChannelStats, 0, len(channels))
+	for _, ch := range channels {
+		list = append(list, stats(ch))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].ConnectedAt.Equal(list[j].ConnectedAt) {
+			return list[i].ID < list[j].ID
+		}
+		return list[i].ConnectedAt.Before(list[j].ConnectedAt)
+	})
+	resp := ChannelsResp{Count: len(list)}
+	if offset >= 0 && offset < len(list) {
+		list = list[offset:]
+		if limit < len(list) {
+			list = list[:limit]
+		}
+		resp.Channels = list
+	}
+	respJSON(w, http.StatusOK, &resp)
+}
+
+// channel serves /channels/count, /channels/{id} and the actions of a channel
+func (s *Server) channel(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/channels/")
+	if path == "count" {
+		respJSON(w, http.StatusOK, &ChannelsResp{Count: len(s.channels.All())})
+		return
+	}
+	id, action := path, ""
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		id, action = path[:i], path[i+1:]
+	}
+	ch, ok := s.channels.Get(id)
+	if !ok {
+		http.Error(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		respJSON(w, http.StatusOK, stats(ch))
+	case action == "kick" && r.Method == http.MethodPost:
+		s.kick(w, r, ch)
+	case action == "push" && r.Method == http.MethodPost:
+		s.push(w, r, ch)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// reasonCloser is a channel sending the reason in its close frame
+type reasonCloser interface {
+	CloseWithReason(reason []byte) error
+}
+
+// stats returns the state of a channel, only the id and the remote address
+// are known if the channel is not a dim.StatsChannel
+func stats(ch dim.Channel) dim.ChannelStats {
+	if sc, ok := ch.(dim.StatsChannel); ok {
+		return sc.Stats()
+	}
+	stats := dim.ChannelStats{ID: ch.ID()}
+	if addr := ch.RemoteAddr(); addr != nil {
+		stats.RemoteAddr = addr.String()
+	}
+	return stats
+}
+
+// kick closes the channel, the reason is sent in the close frame
+func (s *Server) kick(w http.ResponseWriter, r *http.Request, ch dim.Channel) {
+	var req KickReq
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	logger.WithFields(logger.Fields{
+		"module":  "admin",
+		"channel": ch.ID(),
+		"remote":  r.RemoteAddr,
+	}).Warnf("kick: %s", req.Reason)
+	if rc, ok := ch.(reasonCloser); ok {
+		_ = rc.CloseWithReason([]byte(req.Reason))
+	} else {
+		_ = ch.Close()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) push(w http.ResponseWriter, r *http.Request, ch dim.Channel) {
+	var req PushReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Command == "" {
+		req.Command = CommandTest
+	}
+	p := pkt.New(req.Command, pkt.WithFlag(pkt.FlagPush), pkt.WithChannel(ch.ID()))
+	p.WriteBody([]byte(req.Body))
+	if err := ch.Push(pkt.Marshal(p)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) setLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req LevelReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := logger.SetLevel(req.Level); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.WithFields(logger.Fields{
+		"module": "admin",
+		"remote": r.RemoteAddr,
+	}).Infof("log level changed to %s", req.Level)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func respJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,102 @@
+package admin
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"dim"
+	"dim/logger"
+	"dim/tcp"
+	"dim/wire/pkt"
+)
+
+func TestServer(t *testing.T) {
+	channels := dim.NewChannels(10)
+	a, b := net.Pipe()
+	defer b.Close()
+	channels.Add(dim.NewChannel("u1#ios", tcp.NewConn(a)))
+	peer := tcp.NewConn(b)
+
+	ts := httptest.NewServer(NewServer("", "secret", channels).Handler())
+	defer ts.Close()
+	do := func(method, path, token, body string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	if resp := do("GET", "/channels", "", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	if resp := do("GET", "/channels", "wrong", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+
+	var list ChannelsResp
+	_ = json.NewDecoder(do("GET", "/channels", "secret", "").Body).Decode(&list)
+	if list.Count != 1 || len(list.Channels) != 1 || list.Channels[0].ID != "u1#ios" || list.Channels[0].ConnectedAt.IsZero() {
+		t.Fatalf("unexpected channels %+v", list)
+	}
+	list = ChannelsResp{}
+	_ = json.NewDecoder(do("GET", "/channels?offset=1", "secret", "").Body).Decode(&list)
+	if list.Count != 1 || len(list.Channels) != 0 {
+		t.Fatalf("unexpected channels %+v", list)
+	}
+
+	id := "/channels/" + url.PathEscape("u1#ios")
+	if resp := do("GET", "/channels/u2", "secret", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	if resp := do("POST", id+"/push", "secret", `{"body":{"text":"hi"}}`); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	frame, err := peer.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := pkt.Unmarshal(frame.GetPayload())
+	if err != nil || p.Command != CommandTest || string(p.Body) != `{"text":"hi"}` {
+		t.Fatalf("unexpected packet %v %v", p, err)
+	}
+	var stats dim.ChannelStats
+	_ = json.NewDecoder(do("GET", id, "secret", "").Body).Decode(&stats)
+	if stats.BytesOut != int64(len(frame.GetPayload())) || stats.QueueDepth != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	if resp := do("PUT", "/log/level", "secret", `{"level":"warn"}`); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	defer func() { _ = logger.SetLevel("debug") }()
+	if resp := do("PUT", "/log/level", "secret", `{"level":"loud"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	if resp := do("GET", "/debug/pprof/?token=secret", "", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+
+	frames := make(chan dim.Frame, 1)
+	go func() {
+		frame, _ := peer.ReadFrame()
+		frames <- frame
+	}()
+	if resp := do("POST", id+"/kick", "secret", `{"reason":"spam"}`); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	frame = <-frames
+	if frame == nil || frame.GetOpCode() != dim.OpClose || string(frame.GetPayload()) != "spam" {
+		t.Fatalf("unexpected frame %v", frame)
+	}
+}
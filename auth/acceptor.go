@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"dim"
+	"dim/websocket"
+)
+
+// TokenKey is the name of the query param and cookie carrying the token
+const TokenKey = "token"
+
+// Acceptor is an Acceptor authenticating clients by a token, the token is read
+// from the upgrade request of a websocket connection if present, otherwise from
+// the first frame sent by the client. The subject of the token is used as the
+// channel id.
+type Acceptor struct {
+	Verifier
+}
+
+// NewAcceptor NewAcceptor
+func NewAcceptor(verifier Verifier) *Acceptor {
+	return &Acceptor{Verifier: verifier}
+}
+
+// Accept Accept
+func (a *Acceptor) Accept(conn dim.Conn, timeout time.Duration) (string, error) {
+	if r, ok := websocket.Request(conn); ok {
+		if token := TokenFromRequest(r); token != "" {
+			return a.Verify(token)
+		}
+	}
+
+	// read login frame
+	if timeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	frame, err := conn.ReadFrame()
+	if err != nil {
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() {
+			return "", NewError(CodeLoginTimeout, "login timeout")
+		}
+		return "", err
+	}
+	if code := frame.GetOpCode(); code != dim.OpBinary && code != dim.OpText {
+		return "", NewError(CodeMissingToken, "login frame is expected")
+	}
+	return a.Verify(string(frame.GetPayload()))
+}
+
+// AcceptRequest reject an upgrade request carrying an invalid token before
+// upgrading, requests without a token are upgraded to read a login frame.
+func (a *Acceptor) AcceptRequest(r *http.Request) error {
+	token := TokenFromRequest(r)
+	if token == "" {
+		return nil
+	}
+	if _, err := a.Verify(token); err != nil {
+		return &websocket.HTTPError{Code: http.StatusUnauthorized, Reason: err.Error(), Err: err}
+	}
+	return nil
+}
+
+// TokenFromRequest read a token from the Authorization header, the token query
+// param or the token cookie of r.
+func TokenFromRequest(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if len(h) > 7 && strings.EqualFold(h[:7], "Bearer ") {
+			return strings.TrimSpace(h[7:])
+		}
+	}
+	if token := r.URL.Query().Get(TokenKey); token != "" {
+		return token
+	}
+	if c, err := r.Cookie(TokenKey); err == nil {
+		return c.Value
+	}
+	return ""
+}
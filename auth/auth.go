@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+
+	"dim"
+)
+
+// error codes of Error
+const (
+	CodeMissingToken     = "missing_token"
+	CodeInvalidToken     = "invalid_token"
+	CodeInvalidSignature = "invalid_signature"
+	CodeTokenExpired     = "token_expired"
+	CodeTokenNotValidYet = "token_not_valid_yet"
+	CodeInvalidAudience  = "invalid_audience"
+	CodeLoginTimeout     = "login_timeout"
+)
+
+// Error is a structured authentication error, it is encoded as json so that
+// it can be sent to the client in the reason of a OpClose frame.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewError NewError
+func NewError(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	buf, _ := json.Marshal(e)
+	return string(buf)
+}
+
+// Is reports the error as dim.ErrUnauthorized, except a login timeout that is
+// a failed handshake rather than a rejected credential.
+func (e *Error) Is(target error) bool {
+	return target == dim.ErrUnauthorized && e.Code != CodeLoginTimeout
+}
+
+// ParseError decode the reason of a OpClose frame sent by the Acceptor
+func ParseError(reason []byte) (*Error, error) {
+	var e Error
+	if err := json.Unmarshal(reason, &e); err != nil {
+		return nil, err
+	}
+	if e.Code == "" {
+		return nil, errors.New("not an auth error")
+	}
+	return &e, nil
+}
+
+// Verifier verify a token and returns the subject of it
+type Verifier interface {
+	Verify(token string) (string, error)
+}
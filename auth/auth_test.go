@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dim"
+	"dim/tcp"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.RegisteredClaims) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func assertCode(t *testing.T, err error, code string) {
+	t.Helper()
+	aerr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expect *Error, got %v", err)
+	}
+	if aerr.Code != code {
+		t.Fatalf("expect code %s, got %s", code, aerr.Code)
+	}
+}
+
+func TestHS256Verifier(t *testing.T) {
+	secret := []byte("secret")
+	v := NewHS256Verifier(secret, "dim")
+	now := time.Now()
+
+	token := sign(t, jwt.SigningMethodHS256, secret, jwt.RegisteredClaims{
+		Subject:   "u1",
+		Audience:  jwt.ClaimStrings{"dim"},
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+	})
+	sub, err := v.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub != "u1" {
+		t.Fatalf("expect u1, got %s", sub)
+	}
+
+	token = sign(t, jwt.SigningMethodHS256, secret, jwt.RegisteredClaims{
+		Subject:   "u1",
+		Audience:  jwt.ClaimStrings{"dim"},
+		ExpiresAt: jwt.NewNumericDate(now.Add(-time.Minute)),
+	})
+	_, err = v.Verify(token)
+	assertCode(t, err, CodeTokenExpired)
+
+	token = sign(t, jwt.SigningMethodHS256, secret, jwt.RegisteredClaims{
+		Subject:   "u1",
+		Audience:  jwt.ClaimStrings{"dim"},
+		NotBefore: jwt.NewNumericDate(now.Add(time.Minute)),
+	})
+	_, err = v.Verify(token)
+	assertCode(t, err, CodeTokenNotValidYet)
+
+	token = sign(t, jwt.SigningMethodHS256, secret, jwt.RegisteredClaims{
+		Subject:  "u1",
+		Audience: jwt.ClaimStrings{"other"},
+	})
+	_, err = v.Verify(token)
+	assertCode(t, err, CodeInvalidAudience)
+
+	token = sign(t, jwt.SigningMethodHS256, []byte("other"), jwt.RegisteredClaims{
+		Subject:  "u1",
+		Audience: jwt.ClaimStrings{"dim"},
+	})
+	_, err = v.Verify(token)
+	assertCode(t, err, CodeInvalidSignature)
+}
+
+func TestRS256Verifier(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewRS256Verifier(&key.PublicKey, "")
+
+	token := sign(t, jwt.SigningMethodRS256, key, jwt.RegisteredClaims{Subject: "u2"})
+	sub, err := v.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub != "u2" {
+		t.Fatalf("expect u2, got %s", sub)
+	}
+
+	// the algorithm of a token must match the verifier
+	_, err = NewHS256Verifier([]byte("secret"), "").Verify(token)
+	assertCode(t, err, CodeInvalidSignature)
+}
+
+func TestTicket(t *testing.T) {
+	s := NewTicketSigner([]byte("secret"))
+
+	sub, err := s.Verify(s.Sign("u:3", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub != "u:3" {
+		t.Fatalf("expect u:3, got %s", sub)
+	}
+
+	_, err = s.Verify(s.Sign("u3", -time.Minute))
+	assertCode(t, err, CodeTokenExpired)
+
+	_, err = NewTicketSigner([]byte("other")).Verify(s.Sign("u3", time.Minute))
+	assertCode(t, err, CodeInvalidSignature)
+
+	_, err = s.Verify("abc")
+	assertCode(t, err, CodeInvalidToken)
+}
+
+func TestAcceptor(t *testing.T) {
+	s := NewTicketSigner([]byte("secret"))
+	a := NewAcceptor(s)
+
+	cli, srv := net.Pipe()
+	defer cli.Close()
+	go func() {
+		_ = tcp.WriteFrame(cli, dim.OpBinary, []byte(s.Sign("u4", time.Minute)))
+	}()
+	id, err := a.Accept(tcp.NewConn(srv), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "u4" {
+		t.Fatalf("expect u4, got %s", id)
+	}
+
+	_, err = a.Verify("abc")
+	if !errors.Is(err, dim.ErrUnauthorized) {
+		t.Fatalf("expect ErrUnauthorized, got %v", err)
+	}
+	r := httptest.NewRequest("GET", "/?token=abc", nil)
+	if err = a.AcceptRequest(r); !errors.Is(err, dim.ErrUnauthorized) {
+		t.Fatalf("expect ErrUnauthorized, got %v", err)
+	}
+
+	_, srv = net.Pipe()
+	_, err = a.Accept(tcp.NewConn(srv), time.Millisecond*50)
+	assertCode(t, err, CodeLoginTimeout)
+	if errors.Is(err, dim.ErrUnauthorized) {
+		t.Fatal("login timeout is not unauthorized")
+	}
+
+	perr, err := ParseError([]byte(err.Error()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perr.Code != CodeLoginTimeout {
+		t.Fatalf("expect %s, got %s", CodeLoginTimeout, perr.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JWTVerifier verify a HS256 or RS256 signed jwt, the exp and nbf claims are
+// checked if present, the aud claim is required if Audience is set.
+type JWTVerifier struct {
+	method   jwt.SigningMethod
+	key      interface{}
+	Audience string
+	Leeway   time.Duration
+}
+
+// NewHS256Verifier NewHS256Verifier
+func NewHS256Verifier(secret []byte, audience string) *JWTVerifier {
+	return &JWTVerifier{
+		method:   jwt.SigningMethodHS256,
+		key:      secret,
+		Audience: audience,
+	}
+}
+
+// NewRS256Verifier NewRS256Verifier
+func NewRS256Verifier(key *rsa.PublicKey, audience string) *JWTVerifier {
+	return &JWTVerifier{
+		method:   jwt.SigningMethodRS256,
+		key:      key,
+		Audience: audience,
+	}
+}
+
+// Verify returns the sub claim of the token
+func (v *JWTVerifier) Verify(token string) (string, error) {
+	if token == "" {
+		return "", NewError(CodeMissingToken, "token is required")
+	}
+	claims := new(jwt.RegisteredClaims)
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{v.method.Alg()}),
+		jwt.WithoutClaimsValidation(),
+	)
+	_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return v.key, nil
+	})
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			return "", NewError(CodeInvalidSignature, "token signature is invalid")
+		}
+		return "", NewError(CodeInvalidToken, err.Error())
+	}
+
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now.Add(-v.Leeway), false) {
+		return "", NewError(CodeTokenExpired, fmt.Sprintf("token is expired at %v", claims.ExpiresAt.Time))
+	}
+	if !claims.VerifyNotBefore(now.Add(v.Leeway), false) {
+		return "", NewError(CodeTokenNotValidYet, fmt.Sprintf("token is not valid before %v", claims.NotBefore.Time))
+	}
+	if v.Audience != "" && !claims.VerifyAudience(v.Audience, true) {
+		return "", NewError(CodeInvalidAudience, "token is not issued for "+v.Audience)
+	}
+	if claims.Subject == "" {
+		return "", NewError(CodeInvalidToken, "sub claim is required")
+	}
+	return claims.Subject, nil
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var encoding = base64.RawURLEncoding
+
+// TicketSigner sign and verify HMAC-SHA256 tickets, a ticket is
+// base64(subject:expireAt).base64(signature)
+type TicketSigner struct {
+	secret []byte
+}
+
+// NewTicketSigner NewTicketSigner
+func NewTicketSigner(secret []byte) *TicketSigner {
+	return &TicketSigner{secret: secret}
+}
+
+// Sign returns a ticket of subject that expires after ttl
+func (s *TicketSigner) Sign(subject string, ttl time.Duration) string {
+	payload := fmt.Sprintf("%s:%d", subject, time.Now().Add(ttl).Unix())
+	return encoding.EncodeToString([]byte(payload)) + "." + encoding.EncodeToString(s.sign([]byte(payload)))
+}
+
+// Verify returns the subject of the ticket
+func (s *TicketSigner) Verify(ticket string) (string, error) {
+	if ticket == "" {
+		return "", NewError(CodeMissingToken, "ticket is required")
+	}
+	parts := strings.Split(ticket, ".")
+	if len(parts) != 2 {
+		return "", NewError(CodeInvalidToken, "ticket is malformed")
+	}
+	payload, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return "", NewError(CodeInvalidToken, "ticket is malformed")
+	}
+	sig, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return "", NewError(CodeInvalidToken, "ticket is malformed")
+	}
+	if !hmac.Equal(sig, s.sign(payload)) {
+		return "", NewError(CodeInvalidSignature, "ticket signature is invalid")
+	}
+
+	idx := strings.LastIndexByte(string(payload), ':')
+	if idx <= 0 {
+		return "", NewError(CodeInvalidToken, "ticket is malformed")
+	}
+	expireAt, err := strconv.ParseInt(string(payload[idx+1:]), 10, 64)
+	if err != nil {
+		return "", NewError(CodeInvalidToken, "ticket is malformed")
+	}
+	if time.Now().Unix() > expireAt {
+		return "", NewError(CodeTokenExpired, fmt.Sprintf("ticket is expired at %v", time.Unix(expireAt, 0)))
+	}
+	return string(payload[:idx]), nil
+}
+
+func (s *TicketSigner) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
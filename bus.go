@@ -0,0 +1,21 @@
+package dim
+
+// BusHandler handles a message published to topic
+type BusHandler func(topic string, msg []byte)
+
+// Subscription is a subscription of a Bus
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// Bus is a message bus used by servers to broadcast across nodes. A topic is
+// made of tokens separated by dots, a subscription can match one token by
+// "*" and the remaining tokens by a trailing ">", as in NATS.
+type Bus interface {
+	Publish(topic string, msg []byte) error
+	Subscribe(topic string, handler BusHandler) (Subscription, error)
+	// QueueSubscribe subscribes topic in the queue group, a message is
+	// delivered to only one subscriber of a group.
+	QueueSubscribe(topic, queue string, handler BusHandler) (Subscription, error)
+	Close() error
+}
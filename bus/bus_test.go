@@ -0,0 +1,133 @@
+package bus
+
+import (
+	"testing"
+	"time"
+
+	"dim"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		subject, topic string
+		match          bool
+	}{
+		{"gateway.g1", "gateway.g1", true},
+		{"gateway.g1", "gateway.g2", false},
+		{"gateway.*", "gateway.g1", true},
+		{"gateway.*", "gateway.g1.x", false},
+		{"gateway.>", "gateway.g1.x", true},
+		{"gateway.>", "gateway", false},
+		{"gateway", "gateway.g1", false},
+	}
+	for _, c := range cases {
+		if Match(c.subject, c.topic) != c.match {
+			t.Errorf("Match(%s, %s) should be %v", c.subject, c.topic, c.match)
+		}
+	}
+}
+
+func receive(t *testing.T, ch <-chan string) string {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second * 2):
+		t.Fatal("receive timeout")
+	}
+	return ""
+}
+
+func nothing(t *testing.T, ch <-chan string) {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		t.Fatalf("unexpected %s", msg)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+// testBus publishes by node1 and receives by node2
+func testBus(t *testing.T, node1, node2 dim.Bus, flush func()) {
+	got := make(chan string, 10)
+	sub, err := node2.Subscribe("group.*", func(topic string, msg []byte) {
+		got <- topic + ":" + string(msg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := make(chan string, 10)
+	for i := 0; i < 2; i++ {
+		bus := node1
+		if i == 1 {
+			bus = node2
+		}
+		if _, err = bus.QueueSubscribe("offline", "store", func(topic string, msg []byte) {
+			queued <- string(msg)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush()
+
+	if err = node1.Publish("group.g1", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, got); msg != "group.g1:hello" {
+		t.Fatalf("unexpected %s", msg)
+	}
+
+	for i := 0; i < 4; i++ {
+		_ = node1.Publish("offline", []byte("m"))
+	}
+	for i := 0; i < 4; i++ {
+		receive(t, queued)
+	}
+	nothing(t, queued)
+
+	_ = sub.Unsubscribe()
+	flush()
+	_ = node1.Publish("group.g1", []byte("hello"))
+	nothing(t, got)
+}
+
+func TestMemoryBus(t *testing.T) {
+	bus := NewMemoryBus()
+	testBus(t, bus, bus, func() {})
+	_ = bus.Close()
+	if err := bus.Publish("group.g1", nil); err != ErrClosed {
+		t.Fatalf("expect ErrClosed, got %v", err)
+	}
+}
+
+func TestNatsBus(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	defer ns.Shutdown()
+	if !ns.ReadyForConnections(time.Second * 5) {
+		t.Fatal("nats server is not ready")
+	}
+
+	conn1, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn2, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	node1, node2 := NewNatsBus(conn1), NewNatsBus(conn2)
+	defer node1.Close()
+	defer node2.Close()
+
+	testBus(t, node1, node2, func() {
+		_ = conn1.Flush()
+		_ = conn2.Flush()
+	})
+}
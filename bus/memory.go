@@ -0,0 +1,126 @@
+package bus
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"dim"
+)
+
+// ErrClosed is returned once the bus is closed
+var ErrClosed = errors.New("bus is closed")
+
+type subscription struct {
+	bus     *MemoryBus
+	topic   string
+	queue   string
+	handler dim.BusHandler
+}
+
+// Unsubscribe Unsubscribe
+func (s *subscription) Unsubscribe() error {
+	s.bus.remove(s)
+	return nil
+}
+
+// MemoryBus is a Bus in process, the handlers are called by Publish in order
+// of subscribing.
+type MemoryBus struct {
+	sync.RWMutex
+	subs   []*subscription
+	next   map[string]int
+	closed bool
+}
+
+// NewMemoryBus NewMemoryBus
+func NewMemoryBus() dim.Bus {
+	return &MemoryBus{
+		next: make(map[string]int),
+	}
+}
+
+// Publish Publish
+func (b *MemoryBus) Publish(topic string, msg []byte) error {
+	b.Lock()
+	if b.closed {
+		b.Unlock()
+		return ErrClosed
+	}
+	var matched []*subscription
+	groups := make(map[string][]*subscription)
+	for _, s := range b.subs {
+		if !Match(s.topic, topic) {
+			continue
+		}
+		if s.queue == "" {
+			matched = append(matched, s)
+		} else {
+			groups[s.queue] = append(groups[s.queue], s)
+		}
+	}
+	// round robin in a queue group
+	for queue, members := range groups {
+		i := b.next[queue] % len(members)
+		b.next[queue] = i + 1
+		matched = append(matched, members[i])
+	}
+	b.Unlock()
+
+	for _, s := range matched {
+		s.handler(topic, msg)
+	}
+	return nil
+}
+
+// Subscribe Subscribe
+func (b *MemoryBus) Subscribe(topic string, handler dim.BusHandler) (dim.Subscription, error) {
+	return b.QueueSubscribe(topic, "", handler)
+}
+
+// QueueSubscribe QueueSubscribe
+func (b *MemoryBus) QueueSubscribe(topic, queue string, handler dim.BusHandler) (dim.Subscription, error) {
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	s := &subscription{bus: b, topic: topic, queue: queue, handler: handler}
+	b.subs = append(b.subs, s)
+	return s, nil
+}
+
+// Close Close
+func (b *MemoryBus) Close() error {
+	b.Lock()
+	defer b.Unlock()
+	b.closed = true
+	b.subs = nil
+	return nil
+}
+
+func (b *MemoryBus) remove(sub *subscription) {
+	b.Lock()
+	defer b.Unlock()
+	for i, s := range b.subs {
+		if s == sub {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Match returns true if the subscribed topic matches the published topic
+func Match(subject, topic string) bool {
+	st := strings.Split(subject, ".")
+	tt := strings.Split(topic, ".")
+	for i, token := range st {
+		if token == ">" {
+			return i == len(st)-1 && len(tt) > i
+		}
+		if i >= len(tt) || (token != "*" && token != tt[i]) {
+			return false
+		}
+	}
+	return len(st) == len(tt)
+}
@@ -0,0 +1,42 @@
+package bus
+
+import (
+	"dim"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NatsBus is a Bus of NATS, a message published on one node is received by
+// the subscribers on every node connected to the NATS cluster.
+type NatsBus struct {
+	conn *nats.Conn
+}
+
+// NewNatsBus NewNatsBus
+func NewNatsBus(conn *nats.Conn) dim.Bus {
+	return &NatsBus{conn: conn}
+}
+
+// Publish Publish
+func (b *NatsBus) Publish(topic string, msg []byte) error {
+	return b.conn.Publish(topic, msg)
+}
+
+// Subscribe Subscribe
+func (b *NatsBus) Subscribe(topic string, handler dim.BusHandler) (dim.Subscription, error) {
+	return b.conn.Subscribe(topic, func(m *nats.Msg) {
+		handler(m.Subject, m.Data)
+	})
+}
+
+// QueueSubscribe QueueSubscribe
+func (b *NatsBus) QueueSubscribe(topic, queue string, handler dim.BusHandler) (dim.Subscription, error) {
+	return b.conn.QueueSubscribe(topic, queue, func(m *nats.Msg) {
+		handler(m.Subject, m.Data)
+	})
+}
+
+// Close drains the subscriptions and closes the connection
+func (b *NatsBus) Close() error {
+	return b.conn.Drain()
+}
@@ -3,10 +3,13 @@ package dim
 import (
 	"errors"
 	"fmt"
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"dim/logger"
+	"dim/metrics"
 )
 
 // websocket implement of channle
@@ -15,10 +18,15 @@ type ChannelImpl struct {
 	id string
 	Conn
 	writechan chan []byte
+	closing   chan []byte
 	once      sync.Once
 	writewait time.Duration
 	readwait  time.Duration
 	closed    *Event
+	// the stats
+	connectedAt time.Time
+	bytesIn     int64
+	bytesOut    int64
 }
 
 // NewChannel NewChannel
@@ -28,12 +36,14 @@ func NewChannel(id string, conn Conn) Channel {
 		"id":     id,
 	})
 	ch := &ChannelImpl{
-		id:        id,
-		Conn:      conn,
-		writechan: make(chan []byte, 5),
-		closed:    NewEvent(),
-		writewait: DefaultWriteWait,
-		readwait:  DefaultReadWait,
+		id:          id,
+		Conn:        conn,
+		writechan:   make(chan []byte, 5),
+		closing:     make(chan []byte, 1),
+		closed:      NewEvent(),
+		writewait:   DefaultWriteWait,
+		readwait:    DefaultReadWait,
+		connectedAt: time.Now(),
 	}
 	go func() {
 		err := ch.writeloop()
@@ -48,6 +58,7 @@ func (ch *ChannelImpl) writeloop() error {
 	for {
 		select {
 		case payload := <-ch.writechan:
+			metrics.WriteQueue.Dec()
 			err := ch.WriteFrame(OpBinary, payload)
 			if err != nil {
 				return err
@@ -55,6 +66,7 @@ func (ch *ChannelImpl) writeloop() error {
 			chanlen := len(ch.writechan)
 			for i := 0; i < chanlen; i++ {
 				payload = <-ch.writechan
+				metrics.WriteQueue.Dec()
 				err := ch.WriteFrame(OpBinary, payload)
 				if err != nil {
 					return err
@@ -64,6 +76,11 @@ func (ch *ChannelImpl) writeloop() error {
 			if err != nil {
 				return err
 			}
+		case reason := <-ch.closing:
+			// the close frame is written here, so it never interleaves with a payload
+			_ = ch.WriteFrame(OpClose, reason)
+			_ = ch.Conn.Flush()
+			return ch.Close()
 		case <-ch.closed.Done():
 			return nil
 		}
@@ -76,26 +93,60 @@ func (ch *ChannelImpl) ID() string { return ch.id }
 // send async
 func (ch *ChannelImpl) Push(payload []byte) error {
 	if ch.closed.HasFired() {
+		metrics.WriteDrops.Inc()
 		return fmt.Errorf("channel %s has closed", ch.id)
 	}
 	// write async
-	ch.writechan <- payload
-	return nil
+	select {
+	case ch.writechan <- payload:
+		metrics.WriteQueue.Inc()
+		return nil
+	case <-ch.closed.Done():
+		metrics.WriteDrops.Inc()
+		return fmt.Errorf("channel %s has closed", ch.id)
+	}
 }
 
 // overwrite Conn
 func (ch *ChannelImpl) WriteFrame(code OpCode, payload []byte) error {
 	_ = ch.Conn.SetWriteDeadline(time.Now().Add(ch.writewait))
+	metrics.Frame(metrics.Out, code.String(), len(payload))
+	atomic.AddInt64(&ch.bytesOut, int64(len(payload)))
 	return ch.Conn.WriteFrame(code, payload)
 }
 
-// close Conn
+// close Conn, the payloads not written are dropped
 func (ch *ChannelImpl) Close() error {
+	var err error
 	ch.once.Do(func() {
-		close(ch.writechan)
 		ch.closed.Fire()
+		err = ch.Conn.Close()
+		for {
+			select {
+			case <-ch.writechan:
+				metrics.WriteQueue.Dec()
+				metrics.WriteDrops.Inc()
+			default:
+				return
+			}
+		}
 	})
-	return nil
+	return err
+}
+
+// CloseWithReason sends a close frame with the reason by the writeloop, then
+// closes the channel. It waits the writeloop at most the write wait.
+func (ch *ChannelImpl) CloseWithReason(reason []byte) error {
+	select {
+	case ch.closing <- reason:
+	case <-ch.closed.Done():
+		return nil
+	}
+	select {
+	case <-ch.closed.Done():
+	case <-time.After(ch.writewait):
+	}
+	return ch.Close()
 }
 
 // setwritewait
@@ -113,6 +164,21 @@ func (ch *ChannelImpl) SetReadWait(readwait time.Duration) {
 	ch.readwait = readwait
 }
 
+// Stats Stats
+func (ch *ChannelImpl) Stats() ChannelStats {
+	stats := ChannelStats{
+		ID:          ch.id,
+		ConnectedAt: ch.connectedAt,
+		BytesIn:     atomic.LoadInt64(&ch.bytesIn),
+		BytesOut:    atomic.LoadInt64(&ch.bytesOut),
+		QueueDepth:  len(ch.writechan),
+	}
+	if addr := ch.RemoteAddr(); addr != nil {
+		stats.RemoteAddr = addr.String()
+	}
+	return stats
+}
+
 func (ch *ChannelImpl) Readloop(lst MessageListener) error {
 	ch.Lock()
 	defer ch.Unlock()
@@ -128,8 +194,14 @@ func (ch *ChannelImpl) Readloop(lst MessageListener) error {
 
 		frame, err := ch.ReadFrame()
 		if err != nil {
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() {
+				metrics.HeartbeatTimeouts.WithLabelValues("server").Inc()
+			}
 			return err
 		}
+		metrics.Frame(metrics.In, frame.GetOpCode().String(), len(frame.GetPayload()))
+		atomic.AddInt64(&ch.bytesIn, int64(len(frame.GetPayload())))
 		if frame.GetOpCode() == OpClose {
 			return errors.New("remote side close the channel")
 		}
@@ -143,6 +215,10 @@ func (ch *ChannelImpl) Readloop(lst MessageListener) error {
 			continue
 		}
 		// TODO: Optimization point
-		go lst.Receive(ch, payload)
+		go func() {
+			start := time.Now()
+			lst.Receive(ch, payload)
+			metrics.HandlerDuration.Observe(time.Since(start).Seconds())
+		}()
 	}
 }
@@ -42,6 +42,7 @@ func (s *ServerDemo) Start(id, protocol, addr string) {
 
 func (h *ServerHandler) Accept(conn dim.Conn, timeout time.Duration) (string, error) {
 	// 1. read:
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
 	frame, err := conn.ReadFrame()
 	if err != nil {
 		return "", err
@@ -49,7 +50,7 @@ func (h *ServerHandler) Accept(conn dim.Conn, timeout time.Duration) (string, er
 	logger.Info("recv", frame.GetOpCode())
 
 	// 2. parse
-	userID := string(frame.GetOpCode())
+	userID := string(frame.GetPayload())
 
 	// 3. check
 	if userID == "" {
@@ -0,0 +1,137 @@
+package handshake
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"dim"
+	"dim/auth"
+	"dim/logger"
+
+	"github.com/segmentio/ksuid"
+)
+
+// ErrUnsupportedVersion ErrUnsupportedVersion
+var ErrUnsupportedVersion = errors.New("handshake version is not supported")
+
+// Options Options
+type Options struct {
+	Heartbeat    time.Duration
+	MaxFrameSize uint32
+	// Compressions and Encryptions supported by the server in order of preference
+	Compressions []string
+	Encryptions  []string
+	// Resumer resumes the last session of a client, resuming is disabled if nil
+	Resumer Resumer
+	// MultiDevice accepts an account once on each device, the channel id is
+	// dim.ChannelID of the account and the Device of the ClientHello.
+	MultiDevice bool
+}
+
+// Resumer keeps the session of a disconnected client for a while
+type Resumer interface {
+	// Issue a resume token of the channel
+	Issue(channelID string) string
+	// Resume the session of the token, lastSeq is the sequence of the last
+	// push received by the client
+	Resume(channelID, token string, lastSeq uint64) bool
+}
+
+// Acceptor is an Acceptor reading a ClientHello, it authenticates the token of
+// the client and answers with a ServerHello.
+type Acceptor struct {
+	auth.Verifier
+	options Options
+}
+
+// NewAcceptor NewAcceptor
+func NewAcceptor(verifier auth.Verifier, opts Options) *Acceptor {
+	if opts.Heartbeat == 0 {
+		opts.Heartbeat = dim.DefaultHeartbeat
+	}
+	return &Acceptor{
+		Verifier: verifier,
+		options:  opts,
+	}
+}
+
+// Accept Accept
+func (a *Acceptor) Accept(conn dim.Conn, timeout time.Duration) (string, error) {
+	log := logger.WithFields(logger.Fields{
+		"module": "handshake",
+		"remote": conn.RemoteAddr(),
+	})
+	if timeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	frame, err := conn.ReadFrame()
+	if err != nil {
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() {
+			return "", auth.NewError(auth.CodeLoginTimeout, "login timeout")
+		}
+		return "", err
+	}
+	if frame.GetOpCode() != dim.OpBinary {
+		return "", fmt.Errorf("handshake: unexpected opcode %d", frame.GetOpCode())
+	}
+
+	var hello ClientHello
+	if err = hello.Decode(bytes.NewReader(frame.GetPayload())); err != nil {
+		return "", err
+	}
+	if hello.Version < MinVersion {
+		return "", ErrUnsupportedVersion
+	}
+	id, err := a.Verify(hello.Token)
+	if err != nil {
+		return "", err
+	}
+	if a.options.MultiDevice {
+		id = dim.ChannelID(id, hello.Device)
+	}
+
+	resp := &ServerHello{
+		Version:      min(hello.Version, Version),
+		SessionID:    ksuid.New().String(),
+		Heartbeat:    a.options.Heartbeat,
+		MaxFrameSize: a.options.MaxFrameSize,
+		Compression:  choose(a.options.Compressions, hello.Compressions),
+		Encryption:   choose(a.options.Encryptions, hello.Encryptions),
+	}
+	if a.options.Resumer != nil {
+		if hello.ResumeToken != "" {
+			resp.Resumed = a.options.Resumer.Resume(id, hello.ResumeToken, hello.LastSeq)
+		}
+		resp.ResumeToken = a.options.Resumer.Issue(id)
+	}
+	buf := new(bytes.Buffer)
+	_ = resp.Encode(buf)
+	if err = conn.WriteFrame(dim.OpBinary, buf.Bytes()); err != nil {
+		return "", err
+	}
+	log.Infof("%s handshaked, app:%s/%s device:%s version:%d resumed:%v", id, hello.App, hello.AppVersion, hello.Device, resp.Version, resp.Resumed)
+	return id, nil
+}
+
+// choose returns the first of preferred supported by the peer
+func choose(preferred, supported []string) string {
+	for _, p := range preferred {
+		for _, s := range supported {
+			if p == s {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+func min(a, b uint16) uint16 {
+	if a < b {
+		return a
+	}
+	return b
+}
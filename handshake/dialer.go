@@ -0,0 +1,131 @@
+package handshake
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"dim"
+	"dim/auth"
+	"dim/tcp"
+	"dim/websocket"
+
+	"github.com/gobwas/ws"
+)
+
+// Dialer is a Dialer sending a ClientHello once connected, the resume token
+// of the last ServerHello is sent on dialing again.
+type Dialer struct {
+	sync.Mutex
+	// LastSeq returns the sequence of the last push received, it is sent
+	// with the resume token, see reliable.Receiver.
+	LastSeq func() uint64
+	hello   ClientHello
+	dial    func(ctx dim.DialerContext) (dim.Conn, error)
+	result  *ServerHello
+}
+
+// NewTCPDialer NewTCPDialer
+func NewTCPDialer(hello ClientHello) *Dialer {
+	return &Dialer{
+		hello: hello,
+		dial: func(ctx dim.DialerContext) (dim.Conn, error) {
+			conn, err := net.DialTimeout("tcp", ctx.Address, ctx.Timeout)
+			if err != nil {
+				return nil, err
+			}
+			return tcp.NewConn(conn), nil
+		},
+	}
+}
+
+// NewWebsocketDialer NewWebsocketDialer
+func NewWebsocketDialer(hello ClientHello) *Dialer {
+	return &Dialer{
+		hello: hello,
+		dial: func(ctx dim.DialerContext) (dim.Conn, error) {
+			c, cancel := context.WithTimeout(context.Background(), ctx.Timeout)
+			defer cancel()
+			conn, _, _, err := ws.Dial(c, ctx.Address)
+			if err != nil {
+				return nil, err
+			}
+			return websocket.NewClientConn(conn), nil
+		},
+	}
+}
+
+// DialAndHandshake DialAndHandshake
+func (d *Dialer) DialAndHandshake(ctx dim.DialerContext) (net.Conn, error) {
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.Lock()
+	hello := d.hello
+	d.Unlock()
+	if hello.ResumeToken != "" && d.LastSeq != nil {
+		hello.LastSeq = d.LastSeq()
+	}
+
+	result, err := Handshake(conn, &hello, ctx.Timeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	d.Lock()
+	d.result = result
+	d.hello.ResumeToken = result.ResumeToken
+	d.Unlock()
+	return conn, nil
+}
+
+// ServerHello returns the ServerHello of the last handshake
+func (d *Dialer) ServerHello() *ServerHello {
+	d.Lock()
+	defer d.Unlock()
+	return d.result
+}
+
+// Handshake sends hello through conn and waits for the ServerHello, an
+// auth.Error is returned if the server rejects the token.
+func Handshake(conn dim.Conn, hello *ClientHello, timeout time.Duration) (*ServerHello, error) {
+	if hello.Version == 0 {
+		hello.Version = Version
+	}
+	if timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	buf := new(bytes.Buffer)
+	_ = hello.Encode(buf)
+	if err := conn.WriteFrame(dim.OpBinary, buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	frame, err := conn.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	switch frame.GetOpCode() {
+	case dim.OpBinary:
+	case dim.OpClose:
+		reason := frame.GetPayload()
+		if aerr, err := auth.ParseError(reason); err == nil {
+			return nil, aerr
+		}
+		return nil, fmt.Errorf("handshake rejected: %s", reason)
+	default:
+		return nil, errors.New("handshake: unexpected frame")
+	}
+
+	var result ServerHello
+	if err = result.Decode(bytes.NewReader(frame.GetPayload())); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
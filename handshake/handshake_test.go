@@ -0,0 +1,257 @@
+package handshake
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"dim"
+	"dim/auth"
+	"dim/tcp"
+	"dim/websocket"
+	"dim/wire/endian"
+
+	"github.com/gobwas/ws"
+)
+
+func TestHelloCompatible(t *testing.T) {
+	hello := &ClientHello{
+		Version:      Version,
+		App:          "dim",
+		AppVersion:   "1.0.0",
+		Device:       "ios",
+		Token:        "token",
+		Compressions: []string{"gzip"},
+	}
+	buf := new(bytes.Buffer)
+	_ = hello.Encode(buf)
+
+	var got ClientHello
+	if err := got.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got.Device != "ios" || got.Token != "token" || len(got.Compressions) != 1 || len(got.Encryptions) != 0 {
+		t.Fatalf("unexpected hello %+v", got)
+	}
+
+	// a newer client appends a field unknown to us
+	body := new(bytes.Buffer)
+	_ = endian.WriteShortBytes(body, []byte("dim"))
+	_ = endian.WriteShortBytes(body, []byte("2.0.0"))
+	_ = endian.WriteShortBytes(body, []byte("android"))
+	_ = endian.WriteShortBytes(body, []byte("token"))
+	_ = writeStrings(body, nil)
+	_ = writeStrings(body, nil)
+	_ = endian.WriteShortBytes(body, []byte("unknown"))
+	buf.Reset()
+	_ = encode(buf, Version+1, body.Bytes())
+	if err := got.Decode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != Version+1 || got.Device != "android" {
+		t.Fatalf("unexpected hello %+v", got)
+	}
+
+	// an older server sends less fields
+	body.Reset()
+	_ = endian.WriteShortBytes(body, []byte("session"))
+	buf.Reset()
+	_ = encode(buf, Version, body.Bytes())
+	var sh ServerHello
+	if err := sh.Decode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if sh.SessionID != "session" || sh.Heartbeat != 0 {
+		t.Fatalf("unexpected hello %+v", sh)
+	}
+}
+
+func TestServerHello(t *testing.T) {
+	hello := &ServerHello{
+		Version:     Version,
+		SessionID:   "session",
+		Heartbeat:   time.Second * 30,
+		ResumeToken: "token",
+		Resumed:     true,
+	}
+	buf := new(bytes.Buffer)
+	_ = hello.Encode(buf)
+
+	var got ServerHello
+	if err := got.Decode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if got != *hello {
+		t.Fatalf("unexpected hello %+v", got)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	signer := auth.NewTicketSigner([]byte("secret"))
+	acceptor := NewAcceptor(signer, Options{
+		Heartbeat:    time.Second * 30,
+		MaxFrameSize: 4096,
+		Compressions: []string{"zstd", "gzip"},
+	})
+
+	cli, srv := net.Pipe()
+	defer cli.Close()
+	defer srv.Close()
+
+	type result struct {
+		id  string
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		id, err := acceptor.Accept(tcp.NewConn(srv), time.Second)
+		ch <- result{id, err}
+	}()
+
+	sh, err := Handshake(tcp.NewConn(cli), &ClientHello{
+		Token:        signer.Sign("u1", time.Minute),
+		Compressions: []string{"gzip", "zstd"},
+		Encryptions:  []string{"aes"},
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-ch
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.id != "u1" {
+		t.Fatalf("expect u1, got %s", res.id)
+	}
+	if sh.SessionID == "" || sh.Heartbeat != time.Second*30 || sh.MaxFrameSize != 4096 {
+		t.Fatalf("unexpected server hello %+v", sh)
+	}
+	if sh.Compression != "zstd" || sh.Encryption != "" {
+		t.Fatalf("unexpected features %s %s", sh.Compression, sh.Encryption)
+	}
+}
+
+// resumer resumes the session of the token issued last
+type resumer struct {
+	token string
+}
+
+func (r *resumer) Issue(channelID string) string {
+	r.token = channelID + "/" + time.Now().String()
+	return r.token
+}
+
+func (r *resumer) Resume(channelID, token string, lastSeq uint64) bool {
+	return token == r.token
+}
+
+func TestReconnect(t *testing.T) {
+	signer := auth.NewTicketSigner([]byte("secret"))
+	acceptor := NewAcceptor(signer, Options{Resumer: &resumer{}})
+
+	handshake := func(resumeToken string) *ServerHello {
+		cli, srv := net.Pipe()
+		defer cli.Close()
+		defer srv.Close()
+		go func() {
+			_, _ = acceptor.Accept(tcp.NewConn(srv), time.Second)
+		}()
+		sh, err := Handshake(tcp.NewConn(cli), &ClientHello{
+			Token:       signer.Sign("u1", time.Minute),
+			ResumeToken: resumeToken,
+			LastSeq:     1,
+		}, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sh
+	}
+
+	sh := handshake("")
+	if sh.Resumed || sh.ResumeToken == "" {
+		t.Fatalf("unexpected server hello %+v", sh)
+	}
+	if sh = handshake(sh.ResumeToken); !sh.Resumed {
+		t.Fatalf("session is not resumed %+v", sh)
+	}
+	if sh = handshake("invalid"); sh.Resumed {
+		t.Fatalf("session is resumed with an invalid token %+v", sh)
+	}
+}
+
+// serve accepts the connections of lst with acceptor, and echoes a frame of
+// each connection accepted
+func serve(t *testing.T, lst net.Listener, acceptor *Acceptor, upgrade func(net.Conn) (dim.Conn, error)) {
+	for {
+		c, err := lst.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			conn, err := upgrade(c)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err = acceptor.Accept(conn, time.Second); err != nil {
+				return
+			}
+			frame, err := conn.ReadFrame()
+			if err != nil {
+				return
+			}
+			_ = conn.WriteFrame(dim.OpBinary, frame.GetPayload())
+		}()
+	}
+}
+
+func TestDialers(t *testing.T) {
+	signer := auth.NewTicketSigner([]byte("secret"))
+	hello := ClientHello{Token: signer.Sign("u1", time.Minute)}
+	for _, c := range []struct {
+		name    string
+		scheme  string
+		dialer  *Dialer
+		upgrade func(net.Conn) (dim.Conn, error)
+	}{
+		{"tcp", "", NewTCPDialer(hello), func(c net.Conn) (dim.Conn, error) {
+			return tcp.NewConn(c), nil
+		}},
+		{"websocket", "ws://", NewWebsocketDialer(hello), func(c net.Conn) (dim.Conn, error) {
+			if _, err := ws.Upgrade(c); err != nil {
+				return nil, err
+			}
+			return websocket.NewConn(c), nil
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			lst, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer lst.Close()
+			go serve(t, lst, NewAcceptor(signer, Options{Resumer: &resumer{}}), c.upgrade)
+
+			ctx := dim.DialerContext{Address: c.scheme + lst.Addr().String(), Timeout: time.Second}
+			for i := 0; i < 2; i++ {
+				conn, err := c.dialer.DialAndHandshake(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// resumed with the token of the last handshake
+				if sh := c.dialer.ServerHello(); sh.Resumed != (i == 1) {
+					t.Fatalf("unexpected server hello %+v", sh)
+				}
+				// the frames of the client are masked on websocket
+				_ = conn.(dim.Conn).WriteFrame(dim.OpBinary, []byte("ping"))
+				frame, err := conn.(dim.Conn).ReadFrame()
+				if err != nil || string(frame.GetPayload()) != "ping" {
+					t.Fatalf("unexpected echo %v", err)
+				}
+				conn.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package handshake
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+
+	"dim/wire/endian"
+)
+
+// versions of the handshake protocol
+const (
+	// Version is the newest version supported
+	Version uint16 = 3
+	// MinVersion is the oldest version supported
+	MinVersion uint16 = 1
+)
+
+// ClientHello is sent by the client once connected.
+//
+// The fields are encoded in order after the version, a decoder ignores the
+// bytes of unknown fields appended by a newer peer, and leaves the fields not
+// sent by an older peer empty. So new fields must be appended at the end.
+type ClientHello struct {
+	Version      uint16
+	App          string
+	AppVersion   string
+	Device       string
+	Token        string
+	Compressions []string
+	Encryptions  []string
+	// ResumeToken of the last session, since version 2
+	ResumeToken string
+	// LastSeq is the sequence of the last push received, since version 3
+	LastSeq uint64
+}
+
+// ServerHello is the answer of the server to a ClientHello
+type ServerHello struct {
+	Version      uint16
+	SessionID    string
+	Heartbeat    time.Duration
+	MaxFrameSize uint32
+	Compression  string
+	Encryption   string
+	// ResumeToken is sent by the client on reconnecting, since version 2
+	ResumeToken string
+	// Resumed is true if the last session is resumed, since version 3
+	Resumed bool
+}
+
+// Encode Encode
+func (h *ClientHello) Encode(w io.Writer) error {
+	buf := new(bytes.Buffer)
+	_ = endian.WriteShortBytes(buf, []byte(h.App))
+	_ = endian.WriteShortBytes(buf, []byte(h.AppVersion))
+	_ = endian.WriteShortBytes(buf, []byte(h.Device))
+	_ = endian.WriteShortBytes(buf, []byte(h.Token))
+	_ = writeStrings(buf, h.Compressions)
+	_ = writeStrings(buf, h.Encryptions)
+	_ = endian.WriteShortBytes(buf, []byte(h.ResumeToken))
+	_ = endian.WriteUint64(buf, h.LastSeq)
+	return encode(w, h.Version, buf.Bytes())
+}
+
+// Decode Decode
+func (h *ClientHello) Decode(r io.Reader) error {
+	version, body, err := decode(r)
+	if err != nil {
+		return err
+	}
+	h.Version = version
+	fr := &fieldReader{r: bytes.NewReader(body)}
+	h.App = fr.string()
+	h.AppVersion = fr.string()
+	h.Device = fr.string()
+	h.Token = fr.string()
+	h.Compressions = fr.strings()
+	h.Encryptions = fr.strings()
+	h.ResumeToken = fr.string()
+	h.LastSeq = fr.uint64()
+	return fr.err
+}
+
+// Encode Encode
+func (h *ServerHello) Encode(w io.Writer) error {
+	buf := new(bytes.Buffer)
+	_ = endian.WriteShortBytes(buf, []byte(h.SessionID))
+	_ = endian.WriteUint32(buf, uint32(h.Heartbeat/time.Millisecond))
+	_ = endian.WriteUint32(buf, h.MaxFrameSize)
+	_ = endian.WriteShortBytes(buf, []byte(h.Compression))
+	_ = endian.WriteShortBytes(buf, []byte(h.Encryption))
+	_ = endian.WriteShortBytes(buf, []byte(h.ResumeToken))
+	var resumed uint8
+	if h.Resumed {
+		resumed = 1
+	}
+	_ = endian.WriteUint8(buf, resumed)
+	return encode(w, h.Version, buf.Bytes())
+}
+
+// Decode Decode
+func (h *ServerHello) Decode(r io.Reader) error {
+	version, body, err := decode(r)
+	if err != nil {
+		return err
+	}
+	h.Version = version
+	fr := &fieldReader{r: bytes.NewReader(body)}
+	h.SessionID = fr.string()
+	h.Heartbeat = time.Duration(fr.uint32()) * time.Millisecond
+	h.MaxFrameSize = fr.uint32()
+	h.Compression = fr.string()
+	h.Encryption = fr.string()
+	h.ResumeToken = fr.string()
+	h.Resumed = fr.uint8() == 1
+	return fr.err
+}
+
+func encode(w io.Writer, version uint16, body []byte) error {
+	if err := endian.WriteUint16(w, version); err != nil {
+		return err
+	}
+	return endian.WriteBytes(w, body)
+}
+
+func decode(r io.Reader) (uint16, []byte, error) {
+	version, err := endian.ReadUint16(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	body, err := endian.ReadBytes(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, body, nil
+}
+
+func writeStrings(w io.Writer, arr []string) error {
+	if err := endian.WriteUint16(w, uint16(len(arr))); err != nil {
+		return err
+	}
+	for _, s := range arr {
+		if err := endian.WriteShortBytes(w, []byte(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errMalformed = errors.New("handshake packet is malformed")
+
+// fieldReader reads the fields of a body, a field missing at the end of the
+// body is left empty.
+type fieldReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (f *fieldReader) done() bool {
+	return f.err != nil || f.r.Len() == 0
+}
+
+func (f *fieldReader) check(err error) {
+	if err != nil {
+		f.err = errMalformed
+	}
+}
+
+func (f *fieldReader) string() string {
+	if f.done() {
+		return ""
+	}
+	s, err := endian.ReadShortString(f.r)
+	f.check(err)
+	return s
+}
+
+func (f *fieldReader) uint32() uint32 {
+	if f.done() {
+		return 0
+	}
+	v, err := endian.ReadUint32(f.r)
+	f.check(err)
+	return v
+}
+
+func (f *fieldReader) uint8() uint8 {
+	if f.done() {
+		return 0
+	}
+	v, err := endian.ReadUint8(f.r)
+	f.check(err)
+	return v
+}
+
+func (f *fieldReader) uint64() uint64 {
+	if f.done() {
+		return 0
+	}
+	v, err := endian.ReadUint64(f.r)
+	f.check(err)
+	return v
+}
+
+func (f *fieldReader) strings() []string {
+	if f.done() {
+		return nil
+	}
+	n, err := endian.ReadUint16(f.r)
+	f.check(err)
+	arr := make([]string, 0, n)
+	for i := 0; i < int(n) && f.err == nil; i++ {
+		s, err := endian.ReadShortString(f.r)
+		f.check(err)
+		arr = append(arr, s)
+	}
+	return arr
+}
@@ -0,0 +1,219 @@
+package idgen
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dim/naming"
+)
+
+func TestSnowflakeMonotonic(t *testing.T) {
+	s, err := NewSnowflake(3, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const workers, count = 8, 10000
+	ids := make([][]int64, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				id, err := s.Next()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ids[w] = append(ids[w], id)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	seen := make(map[int64]bool, workers*count)
+	for _, list := range ids {
+		for i, id := range list {
+			if i > 0 && id <= list[i-1] {
+				t.Fatalf("%d is not greater than %d", id, list[i-1])
+			}
+			if seen[id] {
+				t.Fatalf("%d is duplicated", id)
+			}
+			seen[id] = true
+		}
+	}
+
+	batch, err := s.NextBatch(5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(batch); i++ {
+		if batch[i] <= batch[i-1] {
+			t.Fatalf("batch is not ascending at %d", i)
+		}
+	}
+	ts, node, _ := s.Parse(batch[0])
+	if node != 3 || time.Since(ts) > time.Second {
+		t.Fatalf("unexpected node %d time %v", node, ts)
+	}
+}
+
+func TestSnowflakeOptions(t *testing.T) {
+	if _, err := NewSnowflake(1024, Options{}); !errors.Is(err, ErrInvalidNode) {
+		t.Fatalf("expect ErrInvalidNode, got %v", err)
+	}
+	if _, err := NewSnowflake(1, Options{NodeBits: 12, SeqBits: 12}); err != ErrInvalidBits {
+		t.Fatalf("expect ErrInvalidBits, got %v", err)
+	}
+	s, err := NewSnowflake(5, Options{NodeBits: 4, SeqBits: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4 ids in a millisecond at most
+	ids, _ := s.NextBatch(9)
+	t0, _, _ := s.Parse(ids[0])
+	t8, node, _ := s.Parse(ids[8])
+	if node != 5 || !t8.After(t0) {
+		t.Fatalf("unexpected node %d, %v is not after %v", node, t8, t0)
+	}
+}
+
+func TestSnowflakeClockBackwards(t *testing.T) {
+	s, _ := NewSnowflake(1, Options{MaxBackwards: time.Millisecond * 5})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	first, err := s.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a small rollback is waited for
+	calls := 0
+	s.now = func() time.Time {
+		calls++
+		if calls == 1 {
+			return now.Add(-time.Millisecond * 2)
+		}
+		return now.Add(time.Millisecond)
+	}
+	id, err := s.Next()
+	if err != nil || id <= first {
+		t.Fatalf("unexpected %d %v", id, err)
+	}
+
+	s.now = func() time.Time { return now.Add(-time.Second) }
+	if _, err = s.Next(); !errors.Is(err, ErrClockBackwards) {
+		t.Fatalf("expect ErrClockBackwards, got %v", err)
+	}
+}
+
+func TestAssignNode(t *testing.T) {
+	nm := naming.NewMemoryNaming()
+	s1 := &naming.DefaultService{Id: "chat01", Name: "chat"}
+	s2 := &naming.DefaultService{Id: "chat02", Name: "chat", Meta: map[string]string{MetaNodeID: "0"}}
+	s3 := &naming.DefaultService{Id: "chat03", Name: "chat", Meta: map[string]string{MetaNodeID: "7"}}
+
+	if node, err := AssignNode(nm, s1, 2); err != nil || node != 0 {
+		t.Fatalf("unexpected %d %v", node, err)
+	}
+	// 0 is used by chat01
+	if node, err := AssignNode(nm, s2, 2); err != nil || node != 1 {
+		t.Fatalf("unexpected %d %v", node, err)
+	}
+	// 7 is out of 2 bits
+	if node, err := AssignNode(nm, s3, 2); err != nil || node != 2 {
+		t.Fatalf("unexpected %d %v", node, err)
+	}
+	if node, err := NodeID(s3); err != nil || node != 2 {
+		t.Fatalf("unexpected %d %v", node, err)
+	}
+	_, _ = AssignNode(nm, &naming.DefaultService{Id: "chat04", Name: "chat"}, 2)
+	if _, err := AssignNode(nm, &naming.DefaultService{Id: "chat05", Name: "chat"}, 2); err != ErrNoNode {
+		t.Fatalf("expect ErrNoNode, got %v", err)
+	}
+}
+
+type failingStore struct {
+	SegmentStore
+	fail atomic.Bool
+}
+
+func (f *failingStore) NextSegment(key string, step int64) (int64, int64, error) {
+	if f.fail.Load() {
+		return 0, 0, errors.New("db is down")
+	}
+	return f.SegmentStore.NextSegment(key, step)
+}
+
+func TestSegment(t *testing.T) {
+	store := &failingStore{SegmentStore: NewMemorySegmentStore()}
+	s := NewSegment(store, "message", 10)
+	ids, err := s.NextBatch(25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		if id != int64(i+1) {
+			t.Fatalf("expect %d, got %d", i+1, id)
+		}
+	}
+	store.fail.Store(true)
+	for i := 0; i < 5; i++ {
+		// the current segment is left
+		if _, err = s.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if _, err = s.Next(); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		t.Fatal("expect error of the store")
+	}
+	store.fail.Store(false)
+	if _, err = s.Next(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkSnowflake(b *testing.B) {
+	s, _ := NewSnowflake(1, Options{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = s.Next()
+	}
+}
+
+func BenchmarkSnowflakeParallel(b *testing.B) {
+	s, _ := NewSnowflake(1, Options{})
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = s.Next()
+		}
+	})
+}
+
+func BenchmarkSnowflakeBatch(b *testing.B) {
+	s, _ := NewSnowflake(1, Options{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = s.NextBatch(100)
+	}
+}
+
+func BenchmarkSegment(b *testing.B) {
+	s := NewSegment(NewMemorySegmentStore(), "message", 10000)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = s.Next()
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package idgen
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"dim/naming"
+)
+
+// MetaNodeID is the meta key of the node id in a service registration
+const MetaNodeID = "node_id"
+
+// ErrNoNode is returned if all node ids are used by the instances of a service
+var ErrNoNode = errors.New("no node id available")
+
+// NodeID returns the node id configured in the meta of service
+func NodeID(service naming.ServiceRegistration) (int64, error) {
+	v, ok := service.GetMeta()[MetaNodeID]
+	if !ok {
+		return 0, fmt.Errorf("%s is not set in the meta of %s", MetaNodeID, service.ServiceID())
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+// AssignNode registers service with the smallest node id not used by the
+// other instances of the service, the node id configured in the meta is
+// kept unless it is used. Two instances registered at the same time may get
+// the same node id, so the id is checked again after registering.
+func AssignNode(nm naming.Naming, service *naming.DefaultService, nodeBits uint8) (int64, error) {
+	if nodeBits == 0 {
+		nodeBits = 10
+	}
+	max := int64(1)<<nodeBits - 1
+	for retry := 0; retry < 3; retry++ {
+		used, err := usedNodes(nm, service)
+		if err != nil {
+			return 0, err
+		}
+		node, err := NodeID(service)
+		if err != nil || used[node] || node > max {
+			node = -1
+			for i := int64(0); i <= max; i++ {
+				if !used[i] {
+					node = i
+					break
+				}
+			}
+			if node < 0 {
+				return 0, ErrNoNode
+			}
+		}
+		if service.Meta == nil {
+			service.Meta = make(map[string]string)
+		}
+		service.Meta[MetaNodeID] = strconv.FormatInt(node, 10)
+		if err = nm.Register(service); err != nil {
+			return 0, err
+		}
+		if used, err = usedNodes(nm, service); err != nil {
+			return 0, err
+		}
+		if !used[node] {
+			return node, nil
+		}
+	}
+	return 0, fmt.Errorf("node id of %s is conflicted", service.ServiceID())
+}
+
+// usedNodes returns the node ids of the other instances of service
+func usedNodes(nm naming.Naming, service naming.ServiceRegistration) (map[int64]bool, error) {
+	services, err := nm.Find(service.ServiceName())
+	if err != nil && err != naming.ErrNotFound {
+		return nil, err
+	}
+	used := make(map[int64]bool, len(services))
+	for _, s := range services {
+		if s.ServiceID() == service.ServiceID() {
+			continue
+		}
+		if node, err := NodeID(s); err == nil {
+			used[node] = true
+		}
+	}
+	return used, nil
+}
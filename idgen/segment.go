@@ -0,0 +1,125 @@
+package idgen
+
+import (
+	"sync"
+)
+
+// SegmentStore allocates the segments of ids of a key, e.g. by a row of a
+// database updated with `max_id = max_id + step`.
+type SegmentStore interface {
+	// NextSegment returns the ids in [start, end)
+	NextSegment(key string, step int64) (start, end int64, err error)
+}
+
+type segment struct {
+	cur, end int64
+}
+
+// Segment is a Generator allocating the ids of a key from the segments of a
+// store, the next segment is fetched in background once 10% of the current
+// one is left, so Next rarely waits for the store.
+type Segment struct {
+	sync.Mutex
+	store    SegmentStore
+	key      string
+	step     int64
+	current  *segment
+	next     *segment
+	fetching bool
+	err      error
+	fetched  *sync.Cond
+}
+
+// NewSegment NewSegment
+func NewSegment(store SegmentStore, key string, step int64) *Segment {
+	if step <= 0 {
+		step = 1000
+	}
+	s := &Segment{
+		store: store,
+		key:   key,
+		step:  step,
+	}
+	s.fetched = sync.NewCond(&s.Mutex)
+	return s
+}
+
+// Next Next
+func (s *Segment) Next() (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.nextLocked()
+}
+
+// NextBatch NextBatch
+func (s *Segment) NextBatch(n int) ([]int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	ids := make([]int64, 0, n)
+	for i := 0; i < n; i++ {
+		id, err := s.nextLocked()
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *Segment) nextLocked() (int64, error) {
+	for s.current == nil || s.current.cur >= s.current.end {
+		if s.next != nil {
+			s.current, s.next = s.next, nil
+			continue
+		}
+		if !s.fetching {
+			s.fetching = true
+			s.err = nil
+			go s.fetch()
+		}
+		s.fetched.Wait()
+		if s.next == nil && s.err != nil {
+			return 0, s.err
+		}
+	}
+	id := s.current.cur
+	s.current.cur++
+	if left := s.current.end - s.current.cur; left*10 < s.step && s.next == nil && !s.fetching {
+		s.fetching = true
+		go s.fetch()
+	}
+	return id, nil
+}
+
+func (s *Segment) fetch() {
+	start, end, err := s.store.NextSegment(s.key, s.step)
+	s.Lock()
+	defer s.Unlock()
+	s.fetching = false
+	if err != nil {
+		s.err = err
+	} else {
+		s.next = &segment{cur: start, end: end}
+	}
+	s.fetched.Broadcast()
+}
+
+// MemorySegmentStore is a SegmentStore in memory, the ids of a key start from 1
+type MemorySegmentStore struct {
+	sync.Mutex
+	max map[string]int64
+}
+
+// NewMemorySegmentStore NewMemorySegmentStore
+func NewMemorySegmentStore() *MemorySegmentStore {
+	return &MemorySegmentStore{max: make(map[string]int64)}
+}
+
+// NextSegment NextSegment
+func (m *MemorySegmentStore) NextSegment(key string, step int64) (int64, int64, error) {
+	m.Lock()
+	defer m.Unlock()
+	start := m.max[key] + 1
+	m.max[key] += step
+	return start, start + step, nil
+}
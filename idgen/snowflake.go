@@ -0,0 +1,147 @@
+package idgen
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errors
+var (
+	ErrClockBackwards = errors.New("clock moved backwards")
+	ErrInvalidNode    = errors.New("node id is out of range")
+	ErrInvalidBits    = errors.New("node bits and sequence bits exceed 22")
+)
+
+// DefaultEpoch is the default epoch of the timestamp of an id
+var DefaultEpoch = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Generator generates unique ids
+type Generator interface {
+	Next() (int64, error)
+	// NextBatch returns n ids in ascending order
+	NextBatch(n int) ([]int64, error)
+}
+
+// Options Options
+type Options struct {
+	// Epoch is the start of the timestamp, default DefaultEpoch
+	Epoch time.Time
+	// NodeBits is the bits of the node id, default 10
+	NodeBits uint8
+	// SeqBits is the bits of the sequence in a millisecond, default 12
+	SeqBits uint8
+	// MaxBackwards is the clock rollback waited for, a larger rollback fails
+	// with ErrClockBackwards, default 10ms
+	MaxBackwards time.Duration
+}
+
+// Snowflake generates ids made of 41 bits of milliseconds since the epoch,
+// the node id and the sequence in the millisecond, so the ids of a node are
+// increasing and the ids of different nodes never collide.
+type Snowflake struct {
+	sync.Mutex
+	options  Options
+	node     int64
+	maxSeq   int64
+	nodeMask int64
+	last     int64
+	seq      int64
+	now      func() time.Time
+}
+
+// NewSnowflake of the node
+func NewSnowflake(node int64, opts Options) (*Snowflake, error) {
+	if opts.Epoch.IsZero() {
+		opts.Epoch = DefaultEpoch
+	}
+	if opts.NodeBits == 0 {
+		opts.NodeBits = 10
+	}
+	if opts.SeqBits == 0 {
+		opts.SeqBits = 12
+	}
+	if opts.MaxBackwards == 0 {
+		opts.MaxBackwards = time.Millisecond * 10
+	}
+	if opts.NodeBits+opts.SeqBits > 22 {
+		return nil, ErrInvalidBits
+	}
+	nodeMask := int64(1)<<opts.NodeBits - 1
+	if node < 0 || node > nodeMask {
+		return nil, fmt.Errorf("%w: %d of %d bits", ErrInvalidNode, node, opts.NodeBits)
+	}
+	return &Snowflake{
+		options:  opts,
+		node:     node,
+		maxSeq:   int64(1)<<opts.SeqBits - 1,
+		nodeMask: nodeMask,
+		last:     -1,
+		now:      time.Now,
+	}, nil
+}
+
+// Next Next
+func (s *Snowflake) Next() (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.next()
+}
+
+// NextBatch NextBatch
+func (s *Snowflake) NextBatch(n int) ([]int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	ids := make([]int64, 0, n)
+	for i := 0; i < n; i++ {
+		id, err := s.next()
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Parse returns the time, node id and sequence of id
+func (s *Snowflake) Parse(id int64) (t time.Time, node, seq int64) {
+	shift := s.options.NodeBits + s.options.SeqBits
+	ms := id >> shift
+	node = (id >> s.options.SeqBits) & s.nodeMask
+	seq = id & s.maxSeq
+	return s.options.Epoch.Add(time.Duration(ms) * time.Millisecond), node, seq
+}
+
+func (s *Snowflake) next() (int64, error) {
+	ms := s.millis()
+	if ms < s.last {
+		backwards := time.Duration(s.last-ms) * time.Millisecond
+		if backwards > s.options.MaxBackwards {
+			return 0, fmt.Errorf("%w by %v", ErrClockBackwards, backwards)
+		}
+		time.Sleep(backwards)
+		if ms = s.millis(); ms < s.last {
+			return 0, fmt.Errorf("%w by %v", ErrClockBackwards, time.Duration(s.last-ms)*time.Millisecond)
+		}
+	}
+	if ms == s.last {
+		s.seq = (s.seq + 1) & s.maxSeq
+		if s.seq == 0 {
+			// the sequence is used up, wait for the next millisecond
+			for ms <= s.last {
+				time.Sleep(time.Microsecond * 100)
+				ms = s.millis()
+			}
+		}
+	} else {
+		s.seq = 0
+	}
+	s.last = ms
+	shift := s.options.NodeBits + s.options.SeqBits
+	return ms<<shift | s.node<<s.options.SeqBits | s.seq, nil
+}
+
+func (s *Snowflake) millis() int64 {
+	return s.now().Sub(s.options.Epoch).Milliseconds()
+}
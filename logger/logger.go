@@ -11,11 +11,18 @@ import (
 )
 
 var (
-	std *logrus.Logger = logrus.New()
+	std *logrus.Logger = newLogger()
 )
 
-// Fields Fields
-type Fields map[string]interface{}
+func newLogger() *logrus.Logger {
+	l := logrus.New()
+	l.AddHook(traceHook{})
+	return l
+}
+
+// Fields is the same as logrus.Fields, so it is also passed to the
+// WithFields of an entry
+type Fields = logrus.Fields
 
 // Option Option
 type Option func(opts *Options)
@@ -119,6 +126,7 @@ func WithError(err error) *logrus.Entry {
 }
 
 // WithContext creates an entry from the standard logger and adds a context to it.
+// The trace id and the span id of the span of ctx are logged as fields if any.
 func WithContext(ctx context.Context) *logrus.Entry {
 	return std.WithContext(ctx)
 }
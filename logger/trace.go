@@ -0,0 +1,32 @@
+package logger
+
+import (
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fields of the span of the context of an entry
+const (
+	FieldTraceID = "trace_id"
+	FieldSpanID  = "span_id"
+)
+
+// traceHook adds the trace id and the span id of the span of the context of
+// an entry, so an entry created by WithContext, or an entry with a context
+// set by WithContext of logrus.Entry, is logged with the trace.
+type traceHook struct{}
+
+func (traceHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (traceHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	if sc := trace.SpanContextFromContext(entry.Context); sc.IsValid() {
+		entry.Data[FieldTraceID] = sc.TraceID().String()
+		entry.Data[FieldSpanID] = sc.SpanID().String()
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestWithContext(t *testing.T) {
+	var buf bytes.Buffer
+	out := std.Out
+	std.SetOutput(&buf)
+	defer std.SetOutput(out)
+
+	ctx, span := trace.NewTracerProvider().Tracer("test").Start(context.Background(), "test")
+	defer span.End()
+	WithContext(ctx).Info("traced")
+	WithFields(Fields{"module": "test"}).WithContext(ctx).Info("traced")
+	WithContext(context.Background()).Info("untraced")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+	id := "trace_id=" + span.SpanContext().TraceID().String()
+	if !strings.Contains(lines[0], id) || !strings.Contains(lines[1], id) || strings.Contains(lines[2], "trace_id") {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
@@ -0,0 +1,139 @@
+// Package metrics exports the metrics of the servers, channels and clients
+// of a node in the Prometheus text format.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace is the prefix of the metric names
+const Namespace = "dim"
+
+// directions of the frames
+const (
+	In  = "in"
+	Out = "out"
+)
+
+// reasons of the connections rejected
+const (
+	ReasonOrigin    = "origin"
+	ReasonRequest   = "request"
+	ReasonUpgrade   = "upgrade"
+	ReasonHandshake = "handshake"
+	ReasonAuth      = "auth"
+	ReasonDuplicate = "duplicate"
+)
+
+// Registry is the registry of the metrics, the metrics of the other
+// packages can be registered to it to be exported by Handler
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Connections is the number of the channels connected to a server
+	Connections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "connections",
+		Help:      "Number of the channels connected.",
+	}, []string{"server"})
+
+	// Accepts is the number of the connections accepted by a server
+	Accepts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "accepts_total",
+		Help:      "Number of the connections accepted.",
+	}, []string{"server"})
+
+	// Rejects is the number of the connections rejected by a server
+	Rejects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "rejects_total",
+		Help:      "Number of the connections rejected by reason.",
+	}, []string{"server", "reason"})
+
+	// Frames is the number of the frames read and written
+	Frames = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "frames_total",
+		Help:      "Number of the frames by direction and opcode.",
+	}, []string{"direction", "opcode"})
+
+	// Bytes is the payload bytes of the frames read and written
+	Bytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "bytes_total",
+		Help:      "Payload bytes of the frames by direction and opcode.",
+	}, []string{"direction", "opcode"})
+
+	// WriteQueue is the number of the payloads waiting to be written by the
+	// channels
+	WriteQueue = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "write_queue_depth",
+		Help:      "Number of the payloads queued in the channels.",
+	})
+
+	// WriteDrops is the number of the payloads pushed but not written, as the
+	// channel is closed
+	WriteDrops = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "write_drops_total",
+		Help:      "Number of the payloads dropped by the channels closed.",
+	})
+
+	// HandlerDuration is the latency of the message listener of the channels
+	HandlerDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "handler_duration_seconds",
+		Help:      "Latency of handling the messages received.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// HeartbeatTimeouts is the number of the connections closed without
+	// heartbeat, side is server or client
+	HeartbeatTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "heartbeat_timeouts_total",
+		Help:      "Number of the connections closed without heartbeat.",
+	}, []string{"side"})
+
+	// DialFailures is the number of the failures of the clients to dial and
+	// handshake
+	DialFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "dial_failures_total",
+		Help:      "Number of the failures of the clients to connect.",
+	}, []string{"client"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Connections,
+		Accepts,
+		Rejects,
+		Frames,
+		Bytes,
+		WriteQueue,
+		WriteDrops,
+		HandlerDuration,
+		HeartbeatTimeouts,
+		DialFailures,
+	)
+}
+
+// Handler returns the http handler of /metrics
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Frame counts a frame of opcode with n bytes payload
+func Frame(direction, opcode string, n int) {
+	Frames.WithLabelValues(direction, opcode).Inc()
+	Bytes.WithLabelValues(direction, opcode).Add(float64(n))
+}
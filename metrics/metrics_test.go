@@ -0,0 +1,53 @@
+package metrics_test
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dim"
+	"dim/metrics"
+	"dim/tcp"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestChannelMetrics(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	ch := dim.NewChannel("c1", tcp.NewConn(a))
+
+	out := testutil.ToFloat64(metrics.Frames.WithLabelValues(metrics.Out, "binary"))
+	bytes := testutil.ToFloat64(metrics.Bytes.WithLabelValues(metrics.Out, "binary"))
+	if err := ch.Push([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tcp.NewConn(b).ReadFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if v := testutil.ToFloat64(metrics.Frames.WithLabelValues(metrics.Out, "binary")); v != out+1 {
+		t.Fatalf("unexpected frames %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.Bytes.WithLabelValues(metrics.Out, "binary")); v != bytes+5 {
+		t.Fatalf("unexpected bytes %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.WriteQueue); v != 0 {
+		t.Fatalf("unexpected write queue %v", v)
+	}
+
+	drops := testutil.ToFloat64(metrics.WriteDrops)
+	_ = ch.Close()
+	if err := ch.Push([]byte("hello")); err == nil {
+		t.Fatal("expect an error")
+	}
+	if v := testutil.ToFloat64(metrics.WriteDrops); v != drops+1 {
+		t.Fatalf("unexpected drops %v", v)
+	}
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `dim_frames_total{direction="out",opcode="binary"}`) {
+		t.Fatalf("unexpected metrics %s", w.Body.String())
+	}
+}
@@ -0,0 +1,153 @@
+package moderation
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"dim/logger"
+)
+
+// PrefixRegexp is the prefix of a line of a dictionary with a regexp
+const PrefixRegexp = "re:"
+
+// KeywordFilter masks or rejects the messages with the keywords or the
+// regexps of a dictionary, the dictionary is replaced by Load while the
+// filter is used, so it is reloaded without restarting.
+type KeywordFilter struct {
+	action Action
+	dict   atomic.Pointer[[]*regexp.Regexp]
+}
+
+// NewKeywordFilter returns a filter with an empty dictionary, action is
+// Mask or Reject
+func NewKeywordFilter(action Action) *KeywordFilter {
+	f := &KeywordFilter{action: action}
+	f.dict.Store(&[]*regexp.Regexp{})
+	return f
+}
+
+// Name Name
+func (f *KeywordFilter) Name() string {
+	return "keyword"
+}
+
+// Check Check
+func (f *KeywordFilter) Check(c *Content) Verdict {
+	body := c.Body
+	matched := ""
+	for _, re := range *f.dict.Load() {
+		loc := re.FindStringIndex(body)
+		if loc == nil {
+			continue
+		}
+		if f.action == Reject {
+			return Verdict{Action: Reject, Reason: "keyword " + body[loc[0]:loc[1]]}
+		}
+		if matched == "" {
+			matched = body[loc[0]:loc[1]]
+		}
+		body = re.ReplaceAllStringFunc(body, func(s string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(s))
+		})
+	}
+	if matched == "" {
+		return Verdict{Action: Accept}
+	}
+	return Verdict{Action: Mask, Reason: "keyword " + matched, Body: body}
+}
+
+// Load replaces the dictionary by the lines of r, a line is a keyword
+// matched ignoring case, or a regexp if it is prefixed with PrefixRegexp.
+// The empty lines and the lines starting with # are skipped. The dictionary
+// is not changed if a regexp is invalid.
+func (f *KeywordFilter) Load(r io.Reader) error {
+	var (
+		words []string
+		dict  []*regexp.Regexp
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, PrefixRegexp) {
+			re, err := regexp.Compile(strings.TrimPrefix(line, PrefixRegexp))
+			if err != nil {
+				return err
+			}
+			dict = append(dict, re)
+			continue
+		}
+		words = append(words, regexp.QuoteMeta(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(words) > 0 {
+		re, err := regexp.Compile("(?i)" + strings.Join(words, "|"))
+		if err != nil {
+			return err
+		}
+		dict = append(dict, re)
+	}
+	f.dict.Store(&dict)
+	return nil
+}
+
+// LoadFile LoadFile
+func (f *KeywordFilter) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return f.Load(file)
+}
+
+// Watch loads the file of path, and reloads it once it is modified, the
+// file is checked every interval until stop is called.
+func (f *KeywordFilter) Watch(path string, interval time.Duration) (stop func(), err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.LoadFile(path); err != nil {
+		return nil, err
+	}
+	modTime := info.ModTime()
+	quit := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			log := logger.WithFields(logger.Fields{
+				"module": "moderation",
+				"plugin": f.Name(),
+				"path":   path,
+			})
+			if err = f.LoadFile(path); err != nil {
+				log.Warnf("reload dictionary failed: %v", err)
+				continue
+			}
+			log.Info("dictionary reloaded")
+		}
+	}()
+	return func() { close(quit) }, nil
+}
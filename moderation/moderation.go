@@ -0,0 +1,145 @@
+// Package moderation checks the messages sent before they are stored and
+// pushed, a Pipeline of plugins is added to a logic router as an
+// interceptor, each plugin accepts, rejects or masks the content.
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+
+	"dim/logger"
+	"dim/router"
+	"dim/wire/pkt"
+)
+
+// Action is the decision of a plugin
+type Action int
+
+// actions
+const (
+	Accept Action = iota
+	// Mask rewrites the body of the message, and the remaining plugins check
+	// the masked one
+	Mask
+	// Reject responds the sender with the status, the message is dropped
+	Reject
+)
+
+func (a Action) String() string {
+	switch a {
+	case Accept:
+		return "accept"
+	case Mask:
+		return "mask"
+	case Reject:
+		return "reject"
+	}
+	return "unknown"
+}
+
+// ErrRejected is responded if a plugin rejects without a reason
+var ErrRejected = errors.New("message rejected")
+
+// Content is the content of a message checked by the plugins, it is read
+// from the type, body and extra fields of the request body, which are the
+// fields of the talk requests of the chat service.
+type Content struct {
+	Sender  string
+	Command string
+	Type    int32
+	Body    string
+	Extra   string
+}
+
+// Verdict is the result of a plugin
+type Verdict struct {
+	Action Action
+	// Status responded if rejected, default pkt.Rejected
+	Status pkt.Status
+	Reason string
+	// Body is the masked body if masked
+	Body string
+}
+
+// Plugin checks the content of a message
+type Plugin interface {
+	Name() string
+	Check(c *Content) Verdict
+}
+
+// Pipeline runs the plugins in order on the requests of the commands
+// moderated, the first reject stops the request.
+type Pipeline struct {
+	commands map[string]bool
+	plugins  []Plugin
+}
+
+// NewPipeline NewPipeline
+func NewPipeline(commands []string, plugins ...Plugin) *Pipeline {
+	p := &Pipeline{
+		commands: make(map[string]bool, len(commands)),
+		plugins:  plugins,
+	}
+	for _, command := range commands {
+		p.commands[command] = true
+	}
+	return p
+}
+
+// Intercept is the router.HandlerFunc of the pipeline, it is added to a
+// router by Use
+func (p *Pipeline) Intercept(ctx router.Context) {
+	command := ctx.Header().Command
+	if !p.commands[command] {
+		return
+	}
+	// the fields are kept, so a masked body is written back with them
+	var fields map[string]json.RawMessage
+	if err := ctx.ReadBody(&fields); err != nil {
+		// responded by the handler
+		return
+	}
+	content := &Content{
+		Sender:  ctx.Session().Account,
+		Command: command,
+	}
+	_ = json.Unmarshal(fields["type"], &content.Type)
+	_ = json.Unmarshal(fields["body"], &content.Body)
+	_ = json.Unmarshal(fields["extra"], &content.Extra)
+
+	masked := false
+	for _, plugin := range p.plugins {
+		verdict := plugin.Check(content)
+		log := logger.WithFields(logger.Fields{
+			"module":  "moderation",
+			"plugin":  plugin.Name(),
+			"account": content.Sender,
+			"command": command,
+			"action":  verdict.Action.String(),
+		})
+		switch verdict.Action {
+		case Reject:
+			log.Infof("rejected: %s", verdict.Reason)
+			status := verdict.Status
+			if status == pkt.Success {
+				status = pkt.Rejected
+			}
+			err := ErrRejected
+			if verdict.Reason != "" {
+				err = errors.New(verdict.Reason)
+			}
+			_ = ctx.RespWithError(status, err)
+			ctx.Abort()
+			return
+		case Mask:
+			log.Infof("masked: %s", verdict.Reason)
+			content.Body, masked = verdict.Body, true
+		default:
+			log.Debug("accepted")
+		}
+	}
+	if masked {
+		fields["body"], _ = json.Marshal(content.Body)
+		ctx.SetBody(fields)
+	}
+}
@@ -0,0 +1,100 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dim"
+	"dim/router"
+	"dim/router/routertest"
+	"dim/wire/pkt"
+)
+
+type talkReq struct {
+	Dest  string `json:"dest"`
+	Type  int32  `json:"type"`
+	Body  string `json:"body"`
+	Extra string `json:"extra,omitempty"`
+}
+
+func TestPipeline(t *testing.T) {
+	keywords := NewKeywordFilter(Mask)
+	if err := keywords.Load(strings.NewReader("# words\nbad\nre:\\d{11}\n")); err != nil {
+		t.Fatal(err)
+	}
+	mutes := NewMuteList()
+	mutes.Mute("u9", 0)
+	pipeline := NewPipeline([]string{"chat.user.talk"}, mutes, keywords, &AttachmentRule{MaxSize: map[int32]int64{2: 1024}})
+
+	var received *talkReq
+	r := router.NewRouter()
+	r.Use(pipeline.Intercept)
+	r.Handle("chat.user.talk", func(ctx router.Context) {
+		received = &talkReq{}
+		_ = ctx.ReadBody(received)
+		_ = ctx.Resp(pkt.Success, nil)
+	})
+	send := func(account string, req *talkReq) *pkt.LogicPkt {
+		received = nil
+		gw := routertest.NewGateway("gateway01")
+		p := pkt.New("chat.user.talk").WriteBody(req)
+		_ = r.Serve(gw, p, &dim.Session{ChannelID: account, Account: account}, nil, nil)
+		return gw.Last()
+	}
+
+	if resp := send("u1", &talkReq{Dest: "u2", Body: "hello"}); resp.Status != pkt.Success || received.Body != "hello" {
+		t.Fatalf("unexpected response %s %+v", resp, received)
+	}
+	resp := send("u1", &talkReq{Dest: "u2", Body: "BAD call 13800138000"})
+	if resp.Status != pkt.Success || received.Body != "*** call ***********" || received.Dest != "u2" {
+		t.Fatalf("unexpected response %s %+v", resp, received)
+	}
+	if resp = send("u9", &talkReq{Dest: "u2", Body: "hi"}); resp.Status != pkt.Forbidden || received != nil {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if resp = send("u1", &talkReq{Dest: "u2", Type: 2, Extra: `{"size":2048}`}); resp.Status != pkt.Rejected || received != nil {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if resp = send("u1", &talkReq{Dest: "u2", Type: 2, Extra: `{"size":512}`}); resp.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", resp)
+	}
+}
+
+func TestKeywordFilterWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keywords.txt")
+	if err := os.WriteFile(path, []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filter := NewKeywordFilter(Reject)
+	stop, err := filter.Watch(path, time.Millisecond*10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	if v := filter.Check(&Content{Body: "a foo"}); v.Action != Reject {
+		t.Fatalf("unexpected verdict %+v", v)
+	}
+
+	if err = os.WriteFile(path, []byte("bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the modification time may be of a coarse resolution
+	_ = os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	time.Sleep(time.Millisecond * 100)
+	if v := filter.Check(&Content{Body: "a foo"}); v.Action != Accept {
+		t.Fatalf("unexpected verdict %+v", v)
+	}
+	if v := filter.Check(&Content{Body: "a bar"}); v.Action != Reject {
+		t.Fatalf("unexpected verdict %+v", v)
+	}
+	// an invalid dictionary is not loaded
+	if err = filter.Load(strings.NewReader("re:(")); err == nil {
+		t.Fatal("expect an error")
+	}
+	if v := filter.Check(&Content{Body: "a bar"}); v.Action != Reject {
+		t.Fatalf("unexpected verdict %+v", v)
+	}
+}
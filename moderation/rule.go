@@ -0,0 +1,92 @@
+package moderation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"dim/wire/pkt"
+)
+
+// MuteList rejects the messages of the accounts muted
+type MuteList struct {
+	sync.RWMutex
+	// account -> muted until, zero for ever
+	muted map[string]time.Time
+}
+
+// NewMuteList NewMuteList
+func NewMuteList() *MuteList {
+	return &MuteList{muted: make(map[string]time.Time)}
+}
+
+// Name Name
+func (m *MuteList) Name() string {
+	return "mute"
+}
+
+// Mute the account for d, or for ever if d is 0
+func (m *MuteList) Mute(account string, d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	var until time.Time
+	if d > 0 {
+		until = time.Now().Add(d)
+	}
+	m.muted[account] = until
+}
+
+// Unmute Unmute
+func (m *MuteList) Unmute(account string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.muted, account)
+}
+
+// Check Check
+func (m *MuteList) Check(c *Content) Verdict {
+	m.RLock()
+	until, ok := m.muted[c.Sender]
+	m.RUnlock()
+	if !ok {
+		return Verdict{Action: Accept}
+	}
+	if !until.IsZero() && time.Now().After(until) {
+		m.Unmute(c.Sender)
+		return Verdict{Action: Accept}
+	}
+	return Verdict{Action: Reject, Status: pkt.Forbidden, Reason: "sender is muted"}
+}
+
+// AttachmentRule rejects the attachments larger than the max size of their
+// message type, the size of an attachment is the size field of the extra of
+// the message, e.g. {"url":"...","size":1024}.
+type AttachmentRule struct {
+	// MaxSize is the max bytes of an attachment by message type, the types
+	// not in it are not checked
+	MaxSize map[int32]int64
+}
+
+// Name Name
+func (r *AttachmentRule) Name() string {
+	return "attachment"
+}
+
+// Check Check
+func (r *AttachmentRule) Check(c *Content) Verdict {
+	max, ok := r.MaxSize[c.Type]
+	if !ok || c.Extra == "" {
+		return Verdict{Action: Accept}
+	}
+	var attachment struct {
+		Size int64 `json:"size"`
+	}
+	if err := json.Unmarshal([]byte(c.Extra), &attachment); err != nil {
+		return Verdict{Action: Reject, Status: pkt.InvalidPacketBody, Reason: "invalid attachment"}
+	}
+	if attachment.Size > max {
+		return Verdict{Action: Reject, Reason: fmt.Sprintf("attachment of %d bytes exceeds %d", attachment.Size, max)}
+	}
+	return Verdict{Action: Accept}
+}
@@ -0,0 +1,153 @@
+package naming
+
+import (
+	"sync"
+)
+
+// MemoryNaming is an in-process Naming, it is used by services running in
+// the same process and by tests.
+type MemoryNaming struct {
+	sync.Mutex
+	services    map[string]map[string]ServiceRegistration
+	subscribers map[string]map[*memorySubscription]struct{}
+}
+
+// NewMemoryNaming NewMemoryNaming
+func NewMemoryNaming() *MemoryNaming {
+	return &MemoryNaming{
+		services:    make(map[string]map[string]ServiceRegistration),
+		subscribers: make(map[string]map[*memorySubscription]struct{}),
+	}
+}
+
+// Find Find
+func (n *MemoryNaming) Find(serviceName string) ([]ServiceRegistration, error) {
+	n.Lock()
+	defer n.Unlock()
+	services := n.findLocked(serviceName)
+	if len(services) == 0 {
+		return nil, ErrNotFound
+	}
+	return services, nil
+}
+
+// Remove Remove
+func (n *MemoryNaming) Remove(serviceName, serviceID string) error {
+	n.Lock()
+	defer n.Unlock()
+	if _, ok := n.services[serviceName][serviceID]; !ok {
+		return ErrNotFound
+	}
+	delete(n.services[serviceName], serviceID)
+	n.notifyLocked(serviceName)
+	return nil
+}
+
+// Register Register
+func (n *MemoryNaming) Register(service ServiceRegistration) error {
+	n.Lock()
+	defer n.Unlock()
+	name := service.ServiceName()
+	if n.services[name] == nil {
+		n.services[name] = make(map[string]ServiceRegistration)
+	}
+	n.services[name][service.ServiceID()] = service
+	n.notifyLocked(name)
+	return nil
+}
+
+// Deregister Deregister
+func (n *MemoryNaming) Deregister(serviceID string) error {
+	n.Lock()
+	defer n.Unlock()
+	for name, services := range n.services {
+		if _, ok := services[serviceID]; ok {
+			delete(services, serviceID)
+			n.notifyLocked(name)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// Subscribe Subscribe, the callback is called in the goroutine of the
+// subscription, so the changes are never notified inside Register.
+func (n *MemoryNaming) Subscribe(serviceName string, callback func([]ServiceRegistration)) (Subscription, error) {
+	sub := &memorySubscription{
+		naming:      n,
+		serviceName: serviceName,
+		callback:    callback,
+		notify:      make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+	n.Lock()
+	if n.subscribers[serviceName] == nil {
+		n.subscribers[serviceName] = make(map[*memorySubscription]struct{})
+	}
+	n.subscribers[serviceName][sub] = struct{}{}
+	n.Unlock()
+	go sub.loop()
+	return sub, nil
+}
+
+func (n *MemoryNaming) findLocked(serviceName string) []ServiceRegistration {
+	services := make([]ServiceRegistration, 0, len(n.services[serviceName]))
+	for _, s := range n.services[serviceName] {
+		services = append(services, s)
+	}
+	return services
+}
+
+// notifyLocked wakes up the subscriptions of serviceName
+func (n *MemoryNaming) notifyLocked(serviceName string) {
+	for sub := range n.subscribers[serviceName] {
+		select {
+		case sub.notify <- struct{}{}:
+		default:
+			// a notification is pending, it reads the latest instances
+		}
+	}
+}
+
+type memorySubscription struct {
+	once        sync.Once
+	naming      *MemoryNaming
+	serviceName string
+	callback    func([]ServiceRegistration)
+	notify      chan struct{}
+	done        chan struct{}
+}
+
+// Unsubscribe Unsubscribe
+func (s *memorySubscription) Unsubscribe() error {
+	s.once.Do(func() {
+		s.naming.Lock()
+		delete(s.naming.subscribers[s.serviceName], s)
+		if len(s.naming.subscribers[s.serviceName]) == 0 {
+			delete(s.naming.subscribers, s.serviceName)
+		}
+		s.naming.Unlock()
+		close(s.done)
+	})
+	return nil
+}
+
+// loop calls the callback with the latest instances once notified
+func (s *memorySubscription) loop() {
+	for {
+		select {
+		case <-s.notify:
+			s.naming.Lock()
+			services := s.naming.findLocked(s.serviceName)
+			s.naming.Unlock()
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			s.callback(services)
+		case <-s.done:
+			return
+		}
+	}
+}
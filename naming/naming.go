@@ -13,4 +13,13 @@ type Naming interface {
 	Remove(serviceName, serviceID string) error
 	Register(ServiceRegistration) error
 	Deregister(serviceID string) error
+	// Subscribe calls callback with all instances of serviceName once they are
+	// changed, the returned Subscription cancels it.
+	Subscribe(serviceName string, callback func(services []ServiceRegistration)) (Subscription, error)
+}
+
+// Subscription is a subscription of Naming
+type Subscription interface {
+	// Unsubscribe stops the callback of the subscription only
+	Unsubscribe() error
 }
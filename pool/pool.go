@@ -0,0 +1,284 @@
+package pool
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"dim"
+	"dim/logger"
+	"dim/naming"
+	"dim/rpc"
+)
+
+// errors
+var (
+	ErrNoClient = errors.New("no client available")
+	ErrClosed   = errors.New("pool is closed")
+)
+
+// DialFunc connects to an instance of the service
+type DialFunc func(service naming.ServiceRegistration) (*rpc.Client, error)
+
+// Options Options
+type Options struct {
+	// Size is the number of connections to each instance, default 1
+	Size int
+	// HealthCheck is the interval of redialing the broken connections, default 5s
+	HealthCheck time.Duration
+}
+
+type instance struct {
+	service naming.ServiceRegistration
+	clients []*rpc.Client
+}
+
+// Pool keeps Size connections to every instance of a service found by the
+// naming, it follows the instances added or removed by naming watch events.
+// A connection is evicted once its read loop is stopped, and redialed by the
+// health check.
+type Pool struct {
+	sync.Mutex
+	serviceName string
+	dial        DialFunc
+	options     Options
+	instances   map[string]*instance
+	sub         naming.Subscription
+	notified    bool
+	closed      *dim.Event
+}
+
+// New a Pool of serviceName, the pool is subscribed to the changes of the
+// instances before they are found, so that an instance registered meanwhile
+// is not missed, and the instances found are dialed.
+func New(serviceName string, nm naming.Naming, dial DialFunc, opts Options) (*Pool, error) {
+	if opts.Size <= 0 {
+		opts.Size = 1
+	}
+	if opts.HealthCheck == 0 {
+		opts.HealthCheck = time.Second * 5
+	}
+	p := &Pool{
+		serviceName: serviceName,
+		dial:        dial,
+		options:     opts,
+		instances:   make(map[string]*instance),
+		closed:      dim.NewEvent(),
+	}
+	var err error
+	if p.sub, err = nm.Subscribe(serviceName, func(services []naming.ServiceRegistration) {
+		p.update(services, false)
+	}); err != nil {
+		return nil, err
+	}
+	services, err := nm.Find(serviceName)
+	if err != nil && err != naming.ErrNotFound {
+		_ = p.sub.Unsubscribe()
+		return nil, err
+	}
+	p.update(services, true)
+	go p.healthloop()
+	return p, nil
+}
+
+// Get returns the client of the instance with the least pending calls
+func (p *Pool) Get(serviceID string) (*rpc.Client, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.closed.HasFired() {
+		return nil, ErrClosed
+	}
+	ins, ok := p.instances[serviceID]
+	if !ok {
+		return nil, naming.ErrNotFound
+	}
+	return leastPending(ins.clients)
+}
+
+// Pick returns the client with the least pending calls of all instances
+func (p *Pool) Pick() (*rpc.Client, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.closed.HasFired() {
+		return nil, ErrClosed
+	}
+	var all []*rpc.Client
+	for _, ins := range p.instances {
+		all = append(all, ins.clients...)
+	}
+	return leastPending(all)
+}
+
+// Services returns the instances in the pool
+func (p *Pool) Services() []naming.ServiceRegistration {
+	p.Lock()
+	defer p.Unlock()
+	services := make([]naming.ServiceRegistration, 0, len(p.instances))
+	for _, ins := range p.instances {
+		services = append(services, ins.service)
+	}
+	return services
+}
+
+// Close all connections
+func (p *Pool) Close() {
+	if !p.closed.Fire() {
+		return
+	}
+	_ = p.sub.Unsubscribe()
+	p.Lock()
+	defer p.Unlock()
+	for id, ins := range p.instances {
+		for _, cli := range ins.clients {
+			cli.Close()
+		}
+		delete(p.instances, id)
+	}
+}
+
+func leastPending(clients []*rpc.Client) (*rpc.Client, error) {
+	var least *rpc.Client
+	min := 0
+	for _, cli := range clients {
+		if n := cli.Pending(); least == nil || n < min {
+			least, min = cli, n
+		}
+	}
+	if least == nil {
+		return nil, ErrNoClient
+	}
+	return least, nil
+}
+
+// update the instances to services, a snapshot found is stale once the
+// subscription has notified, and it is ignored.
+func (p *Pool) update(services []naming.ServiceRegistration, snapshot bool) {
+	log := logger.WithFields(logger.Fields{
+		"module":  "pool",
+		"service": p.serviceName,
+	})
+	p.Lock()
+	if p.closed.HasFired() || (snapshot && p.notified) {
+		p.Unlock()
+		return
+	}
+	if !snapshot {
+		p.notified = true
+	}
+	latest := make(map[string]naming.ServiceRegistration, len(services))
+	for _, s := range services {
+		latest[s.ServiceID()] = s
+	}
+	var removed []*instance
+	for id, ins := range p.instances {
+		if _, ok := latest[id]; !ok {
+			removed = append(removed, ins)
+			delete(p.instances, id)
+		}
+	}
+	var added []string
+	for id, s := range latest {
+		if _, ok := p.instances[id]; !ok {
+			p.instances[id] = &instance{service: s}
+			added = append(added, id)
+		}
+	}
+	p.Unlock()
+
+	for _, ins := range removed {
+		log.Infof("instance %s is removed", ins.service.ServiceID())
+		for _, cli := range ins.clients {
+			cli.Close()
+		}
+	}
+	for _, id := range added {
+		log.Infof("instance %s is added", id)
+		p.fill(id)
+	}
+}
+
+// fill dials the instance until it has Size connections
+func (p *Pool) fill(serviceID string) {
+	p.Lock()
+	ins, ok := p.instances[serviceID]
+	if !ok {
+		p.Unlock()
+		return
+	}
+	service, missing := ins.service, p.options.Size-len(ins.clients)
+	p.Unlock()
+
+	for i := 0; i < missing; i++ {
+		cli, err := p.dial(service)
+		if err != nil {
+			logger.WithFields(logger.Fields{
+				"module":  "pool",
+				"service": p.serviceName,
+			}).Warnf("dial %s failed: %v", service.DialURL(), err)
+			return
+		}
+		p.Lock()
+		ins, ok := p.instances[serviceID]
+		if !ok || p.closed.HasFired() || len(ins.clients) >= p.options.Size {
+			p.Unlock()
+			cli.Close()
+			return
+		}
+		ins.clients = append(ins.clients, cli)
+		p.Unlock()
+		go p.evict(serviceID, cli)
+	}
+}
+
+// evict cli once its read loop is stopped
+func (p *Pool) evict(serviceID string, cli *rpc.Client) {
+	<-cli.Done()
+	p.Lock()
+	defer p.Unlock()
+	ins, ok := p.instances[serviceID]
+	if !ok {
+		return
+	}
+	for i, c := range ins.clients {
+		if c == cli {
+			ins.clients = append(ins.clients[:i], ins.clients[i+1:]...)
+			break
+		}
+	}
+}
+
+func (p *Pool) healthloop() {
+	tick := time.NewTicker(p.options.HealthCheck)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			p.Lock()
+			var ids []string
+			for id, ins := range p.instances {
+				if len(ins.clients) < p.options.Size {
+					ids = append(ids, id)
+				}
+			}
+			p.Unlock()
+			for _, id := range ids {
+				p.fill(id)
+			}
+		case <-p.closed.Done():
+			return
+		}
+	}
+}
+
+// NewDialFunc returns a DialFunc connecting to DialURL of the instance by a
+// client built by newClient with dialer, handler receives the pushes.
+func NewDialFunc(newClient func() dim.Client, dialer dim.Dialer, handler rpc.PushHandler) DialFunc {
+	return func(service naming.ServiceRegistration) (*rpc.Client, error) {
+		cli := newClient()
+		cli.SetDialer(dialer)
+		if err := cli.Connect(service.DialURL()); err != nil {
+			return nil, err
+		}
+		return rpc.NewClient(cli, handler), nil
+	}
+}
@@ -0,0 +1,153 @@
+package pool
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"dim"
+	"dim/naming"
+	"dim/rpc"
+)
+
+// fakeClient is a connected client reading nothing until closed
+type fakeClient struct {
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *fakeClient) ID() string           { return "fake" }
+func (c *fakeClient) Name() string         { return "fake" }
+func (c *fakeClient) Connect(string) error { return nil }
+func (c *fakeClient) SetDialer(dim.Dialer) {}
+func (c *fakeClient) Send([]byte) error    { return nil }
+func (c *fakeClient) Close()               { c.once.Do(func() { close(c.closed) }) }
+func (c *fakeClient) Read() (dim.Frame, error) {
+	<-c.closed
+	return nil, errors.New("closed")
+}
+
+func dial(service naming.ServiceRegistration) (*rpc.Client, error) {
+	return rpc.NewClient(&fakeClient{closed: make(chan struct{})}, nil), nil
+}
+
+func count(p *Pool, id string) int {
+	p.Lock()
+	defer p.Unlock()
+	if ins, ok := p.instances[id]; ok {
+		return len(ins.clients)
+	}
+	return -1
+}
+
+func eventually(t *testing.T, fn func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if fn() {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatal("condition is not met")
+}
+
+func TestPool(t *testing.T) {
+	nm := naming.NewMemoryNaming()
+	_ = nm.Register(naming.NewEntry("s1", "chat", "tcp", "127.0.0.1", 8001))
+
+	p, err := New("chat", nm, dial, Options{Size: 2, HealthCheck: time.Millisecond * 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if count(p, "s1") != 2 {
+		t.Fatalf("expect 2 clients, got %d", count(p, "s1"))
+	}
+
+	// watch events
+	_ = nm.Register(naming.NewEntry("s2", "chat", "tcp", "127.0.0.1", 8002))
+	eventually(t, func() bool { return count(p, "s2") == 2 })
+	_ = nm.Deregister("s1")
+	eventually(t, func() bool {
+		_, err := p.Get("s1")
+		return err == naming.ErrNotFound
+	})
+	if len(p.Services()) != 1 {
+		t.Fatalf("expect 1 service, got %d", len(p.Services()))
+	}
+
+	// health eviction
+	cli, err := p.Get("s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli.Close()
+	eventually(t, func() bool {
+		p.Lock()
+		defer p.Unlock()
+		for _, c := range p.instances["s2"].clients {
+			if c == cli {
+				return false
+			}
+		}
+		return len(p.instances["s2"].clients) == 2
+	})
+
+	if _, err = p.Pick(); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	if _, err = p.Pick(); err != ErrClosed {
+		t.Fatalf("expect ErrClosed, got %v", err)
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	nm := naming.NewMemoryNaming()
+	p1, err := New("chat", nm, dial, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := New("chat", nm, dial, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p2.Close()
+
+	// closing p1 cancels its own subscription only
+	p1.Close()
+	_ = nm.Register(naming.NewEntry("s1", "chat", "tcp", "127.0.0.1", 8001))
+	eventually(t, func() bool { return count(p2, "s1") == 1 })
+	if count(p1, "s1") != -1 {
+		t.Fatal("closed pool follows the naming")
+	}
+}
+
+// registering registers an instance once the instances are found, as if it
+// is registered during the construction of a pool
+type registering struct {
+	*naming.MemoryNaming
+	service naming.ServiceRegistration
+}
+
+func (n *registering) Find(serviceName string) ([]naming.ServiceRegistration, error) {
+	services, err := n.MemoryNaming.Find(serviceName)
+	_ = n.Register(n.service)
+	return services, err
+}
+
+func TestPoolRegisteredMeanwhile(t *testing.T) {
+	nm := &registering{
+		MemoryNaming: naming.NewMemoryNaming(),
+		service:      naming.NewEntry("s2", "chat", "tcp", "127.0.0.1", 8002),
+	}
+	_ = nm.Register(naming.NewEntry("s1", "chat", "tcp", "127.0.0.1", 8001))
+
+	p, err := New("chat", nm, dial, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	eventually(t, func() bool { return count(p, "s1") == 1 && count(p, "s2") == 1 })
+}
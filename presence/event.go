@@ -0,0 +1,60 @@
+package presence
+
+import (
+	"encoding/json"
+	"time"
+
+	"dim"
+	"dim/logger"
+)
+
+// TopicEvents is the topic of the login and logout events of the gateways
+const TopicEvents = "presence.events"
+
+// Event is the login or logout of a channel on a gateway
+type Event struct {
+	Account   string `json:"account"`
+	Device    string `json:"device"`
+	ChannelID string `json:"channel_id"`
+	GateID    string `json:"gate_id"`
+	Online    bool   `json:"online"`
+	At        int64  `json:"at"`
+}
+
+// Publisher is a router.SessionListener of a gateway, it publishes the
+// events to the presence services on a bus.
+type Publisher struct {
+	bus dim.Bus
+}
+
+// NewPublisher NewPublisher
+func NewPublisher(bus dim.Bus) *Publisher {
+	return &Publisher{bus: bus}
+}
+
+// Login Login
+func (p *Publisher) Login(session *dim.Session) {
+	p.publish(session, true)
+}
+
+// Logout Logout
+func (p *Publisher) Logout(session *dim.Session) {
+	p.publish(session, false)
+}
+
+func (p *Publisher) publish(session *dim.Session, online bool) {
+	buf, _ := json.Marshal(&Event{
+		Account:   session.Account,
+		Device:    session.Device,
+		ChannelID: session.ChannelID,
+		GateID:    session.GateID,
+		Online:    online,
+		At:        time.Now().UnixMilli(),
+	})
+	if err := p.bus.Publish(TopicEvents, buf); err != nil {
+		logger.WithFields(logger.Fields{
+			"module":  "presence",
+			"channel": session.ChannelID,
+		}).Warnf("publish event failed: %v", err)
+	}
+}
@@ -0,0 +1,386 @@
+package presence
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"dim"
+	"dim/logger"
+	"dim/router"
+	"dim/wire/pkt"
+)
+
+// commands
+const (
+	CommandSubscribe   = "presence.subscribe"
+	CommandUnsubscribe = "presence.unsubscribe"
+	CommandQuery       = "presence.query"
+	CommandSet         = "presence.set"
+	// CommandChange is pushed to the subscribers once the status of an
+	// account is changed
+	CommandChange = "presence.change"
+)
+
+// Status is the presence status of an account or a device
+type Status string
+
+// Status Status
+const (
+	Offline Status = "offline"
+	Online  Status = "online"
+	Away    Status = "away"
+)
+
+// ErrInvalidStatus is responded if presence.set is not online or away
+var ErrInvalidStatus = errors.New("status should be online or away")
+
+// Presence is the presence of an account
+type Presence struct {
+	Account string `json:"account"`
+	Status  Status `json:"status"`
+	// Devices is the status of every connected device
+	Devices map[string]Status `json:"devices,omitempty"`
+	// LastSeen is the time in milliseconds the last device is disconnected
+	LastSeen int64 `json:"last_seen,omitempty"`
+}
+
+// AccountsReq is the request of subscribe, unsubscribe and query
+type AccountsReq struct {
+	Accounts []string `json:"accounts"`
+}
+
+// PresencesResp is the response of subscribe and query
+type PresencesResp struct {
+	Presences []*Presence `json:"presences"`
+}
+
+// SetReq sets the status of the device of the sender
+type SetReq struct {
+	Status Status `json:"status"`
+}
+
+// Options Options
+type Options struct {
+	// Debounce is the time a disconnected device is kept online, so a quick
+	// reconnect doesn't notify the subscribers, default 5s
+	Debounce time.Duration
+}
+
+type device struct {
+	status Status
+	gateID string
+	// at is the time of the last event applied
+	at int64
+	// offline is the pending logout in debouncing
+	offline *time.Timer
+}
+
+// Service is the presence service, it tracks the devices of accounts by the
+// events of the gateways and pushes the changes to the subscribers. The
+// state is kept in memory, so a presence service runs in one instance, only
+// the last seen of the accounts is kept by a LastSeenStore.
+type Service struct {
+	sync.Mutex
+	dispatcher router.Dispatcher
+	sessions   dim.SessionStore
+	options    Options
+	devices    map[string]map[string]*device
+	lastSeen   LastSeenStore
+	// account -> subscribers
+	subscribers map[string]map[string]bool
+	// subscriber -> accounts
+	subscriptions map[string]map[string]bool
+}
+
+// NewService NewService
+func NewService(dispatcher router.Dispatcher, sessions dim.SessionStore, opts Options) *Service {
+	if opts.Debounce == 0 {
+		opts.Debounce = time.Second * 5
+	}
+	return &Service{
+		dispatcher:    dispatcher,
+		sessions:      sessions,
+		options:       opts,
+		devices:       make(map[string]map[string]*device),
+		lastSeen:      NewMemoryLastSeenStore(),
+		subscribers:   make(map[string]map[string]bool),
+		subscriptions: make(map[string]map[string]bool),
+	}
+}
+
+// SetLastSeen sets the store of the last seen, it is in memory by default
+func (s *Service) SetLastSeen(store LastSeenStore) {
+	s.lastSeen = store
+}
+
+// Register the handlers of the commands to r
+func (s *Service) Register(r *router.Router) {
+	r.Handle(CommandSubscribe, s.DoSubscribe)
+	r.Handle(CommandUnsubscribe, s.DoUnsubscribe)
+	r.Handle(CommandQuery, s.DoQuery)
+	r.Handle(CommandSet, s.DoSet)
+}
+
+// Subscribe the events published by the Publisher of the gateways
+func (s *Service) Subscribe(bus dim.Bus) (dim.Subscription, error) {
+	return bus.Subscribe(TopicEvents, func(topic string, msg []byte) {
+		var e Event
+		if err := json.Unmarshal(msg, &e); err != nil {
+			logger.WithFields(logger.Fields{
+				"module": "presence",
+			}).Warn(err)
+			return
+		}
+		s.Handle(&e)
+	})
+}
+
+// Handle an event of a gateway. A login cancels the pending logout of the
+// device, and a logout is applied once the debounce is passed. The logout of
+// a device is ignored if the device has logged in on another gateway, and an
+// event older than the last one applied to the device is ignored, e.g. the
+// logout of a connection delivered after the login of a new one.
+func (s *Service) Handle(e *Event) {
+	s.Lock()
+	d, ok := s.devices[e.Account][e.Device]
+	if ok && e.At < d.at {
+		s.Unlock()
+		return
+	}
+	if e.Online {
+		before := s.statusLocked(e.Account)
+		if !ok {
+			if s.devices[e.Account] == nil {
+				s.devices[e.Account] = make(map[string]*device)
+			}
+			d = &device{}
+			s.devices[e.Account][e.Device] = d
+		}
+		d.status = Online
+		if d.offline != nil {
+			d.offline.Stop()
+			d.offline = nil
+		}
+		d.gateID = e.GateID
+		d.at = e.At
+		s.notifyUnlock(e.Account, before)
+		return
+	}
+	if !ok || d.gateID != e.GateID || d.offline != nil {
+		s.Unlock()
+		return
+	}
+	d.at = e.At
+	d.offline = time.AfterFunc(s.options.Debounce, func() {
+		s.logout(e.Account, e.Device, d, e.At)
+	})
+	s.Unlock()
+}
+
+// Get returns the presences of the accounts
+func (s *Service) Get(accounts ...string) []*Presence {
+	s.Lock()
+	presences := make([]*Presence, 0, len(accounts))
+	for _, account := range accounts {
+		presences = append(presences, s.presenceLocked(account))
+	}
+	s.Unlock()
+	s.withLastSeen(presences...)
+	return presences
+}
+
+// DoSubscribe subscribes the accounts, and responds the presences of them
+func (s *Service) DoSubscribe(ctx router.Context) {
+	var req AccountsReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	subscriber := ctx.Session().Account
+	s.Lock()
+	if s.subscriptions[subscriber] == nil {
+		s.subscriptions[subscriber] = make(map[string]bool)
+	}
+	for _, account := range req.Accounts {
+		if s.subscribers[account] == nil {
+			s.subscribers[account] = make(map[string]bool)
+		}
+		s.subscribers[account][subscriber] = true
+		s.subscriptions[subscriber][account] = true
+	}
+	s.Unlock()
+	_ = ctx.Resp(pkt.Success, &PresencesResp{Presences: s.Get(req.Accounts...)})
+}
+
+// DoUnsubscribe DoUnsubscribe
+func (s *Service) DoUnsubscribe(ctx router.Context) {
+	var req AccountsReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	s.Lock()
+	s.unsubscribeLocked(ctx.Session().Account, req.Accounts...)
+	s.Unlock()
+	_ = ctx.Resp(pkt.Success, nil)
+}
+
+// DoQuery DoQuery
+func (s *Service) DoQuery(ctx router.Context) {
+	var req AccountsReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Success, &PresencesResp{Presences: s.Get(req.Accounts...)})
+}
+
+// DoSet sets the device of the sender online or away
+func (s *Service) DoSet(ctx router.Context) {
+	var req SetReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	if req.Status != Online && req.Status != Away {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, ErrInvalidStatus)
+		return
+	}
+	session := ctx.Session()
+	s.Lock()
+	d, ok := s.devices[session.Account][session.Device]
+	if !ok {
+		s.Unlock()
+		_ = ctx.RespWithError(pkt.NotFound, dim.ErrSessionNil)
+		return
+	}
+	before := s.statusLocked(session.Account)
+	d.status = req.Status
+	s.notifyUnlock(session.Account, before)
+	_ = ctx.Resp(pkt.Success, nil)
+}
+
+// logout the device disconnected at the unix milliseconds
+func (s *Service) logout(account, name string, d *device, at int64) {
+	s.Lock()
+	if s.devices[account][name] != d || d.offline == nil {
+		s.Unlock()
+		return
+	}
+	before := s.statusLocked(account)
+	delete(s.devices[account], name)
+	if len(s.devices[account]) == 0 {
+		delete(s.devices, account)
+		// the subscriptions are kept by the connected devices only
+		accounts := make([]string, 0, len(s.subscriptions[account]))
+		for subscribed := range s.subscriptions[account] {
+			accounts = append(accounts, subscribed)
+		}
+		s.unsubscribeLocked(account, accounts...)
+
+		s.Unlock()
+		if err := s.lastSeen.Set(account, at); err != nil {
+			logger.WithFields(logger.Fields{
+				"module":  "presence",
+				"account": account,
+			}).Warnf("set last seen failed: %v", err)
+		}
+		s.Lock()
+	}
+	s.notifyUnlock(account, before)
+}
+
+// unsubscribeLocked removes the subscriptions of subscriber to the accounts
+func (s *Service) unsubscribeLocked(subscriber string, accounts ...string) {
+	for _, account := range accounts {
+		delete(s.subscribers[account], subscriber)
+		if len(s.subscribers[account]) == 0 {
+			delete(s.subscribers, account)
+		}
+		delete(s.subscriptions[subscriber], account)
+	}
+	if len(s.subscriptions[subscriber]) == 0 {
+		delete(s.subscriptions, subscriber)
+	}
+}
+
+// statusLocked returns online if a device is online, away if a device is
+// away, offline otherwise
+func (s *Service) statusLocked(account string) Status {
+	status := Offline
+	for _, d := range s.devices[account] {
+		if d.status == Online {
+			return Online
+		}
+		status = Away
+	}
+	return status
+}
+
+func (s *Service) presenceLocked(account string) *Presence {
+	p := &Presence{
+		Account: account,
+		Status:  s.statusLocked(account),
+	}
+	if devices := s.devices[account]; len(devices) > 0 {
+		p.Devices = make(map[string]Status, len(devices))
+		for name, d := range devices {
+			p.Devices[name] = d.status
+		}
+	}
+	return p
+}
+
+// withLastSeen sets the last seen of the presences offline
+func (s *Service) withLastSeen(presences ...*Presence) {
+	accounts := make([]string, 0, len(presences))
+	for _, p := range presences {
+		if p.Status == Offline {
+			accounts = append(accounts, p.Account)
+		}
+	}
+	if len(accounts) == 0 {
+		return
+	}
+	seen, err := s.lastSeen.Get(accounts...)
+	if err != nil {
+		logger.WithFields(logger.Fields{
+			"module": "presence",
+		}).Warnf("get last seen failed: %v", err)
+		return
+	}
+	for _, p := range presences {
+		p.LastSeen = seen[p.Account]
+	}
+}
+
+// notifyUnlock unlocks s and pushes the presence of account to the
+// subscribers if the status is not before
+func (s *Service) notifyUnlock(account string, before Status) {
+	if s.statusLocked(account) == before {
+		s.Unlock()
+		return
+	}
+	presence := s.presenceLocked(account)
+	subscribers := make([]string, 0, len(s.subscribers[account]))
+	for subscriber := range s.subscribers[account] {
+		subscribers = append(subscribers, subscriber)
+	}
+	s.Unlock()
+
+	if len(subscribers) == 0 {
+		return
+	}
+	locs, err := s.sessions.GetLocations(subscribers...)
+	if err != nil {
+		return
+	}
+	s.withLastSeen(presence)
+	// a change is stale once the subscriber is connected again, so it is not
+	// stored for the subscribers not connected
+	p := pkt.New(CommandChange, pkt.WithFlag(pkt.FlagPush)).WriteBody(presence)
+	p.AddMeta(router.MetaEphemeral, "1")
+	_ = router.Dispatch(s.dispatcher, p, locs...)
+}
@@ -0,0 +1,169 @@
+package presence
+
+import (
+	"testing"
+	"time"
+
+	"dim"
+	"dim/bus"
+	"dim/router"
+	"dim/router/routertest"
+	"dim/storage"
+	"dim/wire/pkt"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// presences decodes the presences pushed by d
+func presences(d *routertest.Dispatcher) []*Presence {
+	var pushes []*Presence
+	for _, p := range d.Take() {
+		var presence Presence
+		_ = p.Packet.ReadBody(&presence)
+		pushes = append(pushes, &presence)
+	}
+	return pushes
+}
+
+func TestPresence(t *testing.T) {
+	sessions := storage.NewMemoryStore()
+	_ = sessions.Add(&dim.Session{ChannelID: "u1", GateID: "gateway01", Account: "u1"})
+	disp := &routertest.Dispatcher{}
+	service := NewService(disp, sessions, Options{Debounce: time.Millisecond * 50})
+	r := router.NewRouter()
+	service.Register(r)
+
+	b := bus.NewMemoryBus()
+	if _, err := service.Subscribe(b); err != nil {
+		t.Fatal(err)
+	}
+	publisher := NewPublisher(b)
+
+	call := func(account, device, command string, body interface{}) *pkt.LogicPkt {
+		gw := routertest.NewGateway("gateway01")
+		session := &dim.Session{ChannelID: dim.ChannelID(account, device), Account: account, Device: device}
+		_ = r.Serve(gw, pkt.New(command).WriteBody(body), session, disp, sessions)
+		return gw.Last()
+	}
+
+	var resp PresencesResp
+	_ = call("u1", "", CommandSubscribe, &AccountsReq{Accounts: []string{"u2"}}).ReadBody(&resp)
+	if len(resp.Presences) != 1 || resp.Presences[0].Status != Offline {
+		t.Fatalf("unexpected presences %+v", resp.Presences)
+	}
+
+	ios := &dim.Session{ChannelID: "u2#ios", GateID: "gateway01", Account: "u2", Device: "ios"}
+	web := &dim.Session{ChannelID: "u2#web", GateID: "gateway02", Account: "u2", Device: "web"}
+	publisher.Login(ios)
+	if pushes := presences(disp); len(pushes) != 1 || pushes[0].Status != Online || pushes[0].Devices["ios"] != Online {
+		t.Fatalf("unexpected pushes %+v", pushes)
+	}
+	// another device doesn't change the status
+	publisher.Login(web)
+	if pushes := presences(disp); len(pushes) != 0 {
+		t.Fatalf("unexpected pushes %+v", pushes)
+	}
+
+	// away once all devices are away
+	if resp := call("u2", "ios", CommandSet, &SetReq{Status: Away}); resp.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if pushes := presences(disp); len(pushes) != 0 {
+		t.Fatalf("unexpected pushes %+v", pushes)
+	}
+	call("u2", "web", CommandSet, &SetReq{Status: Away})
+	if pushes := presences(disp); len(pushes) != 1 || pushes[0].Status != Away {
+		t.Fatalf("unexpected pushes %+v", pushes)
+	}
+	call("u2", "web", CommandSet, &SetReq{Status: Online})
+	presences(disp)
+
+	// a quick reconnect doesn't flap
+	publisher.Logout(web)
+	publisher.Login(web)
+	publisher.Logout(ios)
+	time.Sleep(time.Millisecond * 100)
+	if pushes := presences(disp); len(pushes) != 0 {
+		t.Fatalf("unexpected pushes %+v", pushes)
+	}
+	// the late logout of the old gateway is ignored
+	web.GateID = "gateway03"
+	publisher.Login(web)
+	web.GateID = "gateway02"
+	publisher.Logout(web)
+	time.Sleep(time.Millisecond * 100)
+	if got := service.Get("u2")[0]; got.Status != Online {
+		t.Fatalf("unexpected presence %+v", got)
+	}
+
+	web.GateID = "gateway03"
+	publisher.Logout(web)
+	time.Sleep(time.Millisecond * 100)
+	pushes := presences(disp)
+	if len(pushes) != 1 || pushes[0].Status != Offline || pushes[0].LastSeen == 0 {
+		t.Fatalf("unexpected pushes %+v", pushes)
+	}
+
+	call("u1", "", CommandUnsubscribe, &AccountsReq{Accounts: []string{"u2"}})
+	publisher.Login(ios)
+	if pushes := presences(disp); len(pushes) != 0 {
+		t.Fatalf("unexpected pushes %+v", pushes)
+	}
+	if len(service.subscribers) != 0 || len(service.subscriptions) != 0 {
+		t.Fatalf("unexpected subscriptions %v %v", service.subscribers, service.subscriptions)
+	}
+
+	// the subscriptions are removed once the subscriber is disconnected
+	u3 := &dim.Session{ChannelID: "u3", GateID: "gateway01", Account: "u3"}
+	publisher.Login(u3)
+	call("u3", "", CommandSubscribe, &AccountsReq{Accounts: []string{"u2"}})
+	publisher.Logout(u3)
+	time.Sleep(time.Millisecond * 100)
+	service.Lock()
+	defer service.Unlock()
+	if len(service.subscribers) != 0 || len(service.subscriptions) != 0 {
+		t.Fatalf("unexpected subscriptions %v %v", service.subscribers, service.subscriptions)
+	}
+}
+
+func TestPresenceLastSeen(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer cli.Close()
+
+	sessions := storage.NewMemoryStore()
+	_ = sessions.Add(&dim.Session{ChannelID: "u1", GateID: "gateway01", Account: "u1"})
+	disp := &routertest.Dispatcher{}
+	service := NewService(disp, sessions, Options{Debounce: time.Millisecond * 10})
+	service.SetLastSeen(NewRedisLastSeenStore(cli))
+	service.subscribers["u2"] = map[string]bool{"u1": true}
+
+	service.Handle(&Event{Account: "u2", Device: "ios", GateID: "gateway01", Online: true, At: 100})
+	// the logout of an earlier connection is delivered late
+	service.Handle(&Event{Account: "u2", Device: "ios", GateID: "gateway01", At: 50})
+	time.Sleep(time.Millisecond * 50)
+	if got := service.Get("u2")[0]; got.Status != Online {
+		t.Fatalf("unexpected presence %+v", got)
+	}
+	pushes := disp.Take()
+	if len(pushes) != 1 {
+		t.Fatalf("unexpected pushes %v", pushes)
+	}
+	if _, ok := pushes[0].Packet.GetMeta(router.MetaEphemeral); !ok {
+		t.Fatal("change is not ephemeral")
+	}
+
+	service.Handle(&Event{Account: "u2", Device: "ios", GateID: "gateway01", At: 200})
+	time.Sleep(time.Millisecond * 50)
+	if got := presences(disp); len(got) != 1 || got[0].Status != Offline || got[0].LastSeen != 200 {
+		t.Fatalf("unexpected pushes %+v", got)
+	}
+
+	// kept once restarted
+	service = NewService(disp, sessions, Options{})
+	service.SetLastSeen(NewRedisLastSeenStore(cli))
+	if got := service.Get("u2", "u3"); got[0].LastSeen != 200 || got[1].LastSeen != 0 {
+		t.Fatalf("unexpected presences %+v %+v", got[0], got[1])
+	}
+}
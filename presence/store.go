@@ -0,0 +1,113 @@
+package presence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// LastSeenStore keeps the time the last device of an account is
+// disconnected, so it is not lost once the presence service is restarted.
+type LastSeenStore interface {
+	// Set the last seen of account to at in milliseconds, an earlier one is
+	// ignored
+	Set(account string, at int64) error
+	// Get returns the last seen of the accounts, an account never seen is
+	// not in the result
+	Get(accounts ...string) (map[string]int64, error)
+}
+
+// MemoryLastSeenStore is a LastSeenStore in memory
+type MemoryLastSeenStore struct {
+	sync.Mutex
+	seen map[string]int64
+}
+
+// NewMemoryLastSeenStore NewMemoryLastSeenStore
+func NewMemoryLastSeenStore() *MemoryLastSeenStore {
+	return &MemoryLastSeenStore{
+		seen: make(map[string]int64),
+	}
+}
+
+// Set Set
+func (m *MemoryLastSeenStore) Set(account string, at int64) error {
+	m.Lock()
+	defer m.Unlock()
+	if at > m.seen[account] {
+		m.seen[account] = at
+	}
+	return nil
+}
+
+// Get Get
+func (m *MemoryLastSeenStore) Get(accounts ...string) (map[string]int64, error) {
+	m.Lock()
+	defer m.Unlock()
+	seen := make(map[string]int64, len(accounts))
+	for _, account := range accounts {
+		if at, ok := m.seen[account]; ok {
+			seen[account] = at
+		}
+	}
+	return seen, nil
+}
+
+// RedisLastSeenStore is a LastSeenStore in redis.
+//
+//	presence:seen:{account}  string of the last seen in milliseconds
+type RedisLastSeenStore struct {
+	cli *redis.Client
+}
+
+// NewRedisLastSeenStore NewRedisLastSeenStore
+func NewRedisLastSeenStore(cli *redis.Client) *RedisLastSeenStore {
+	return &RedisLastSeenStore{cli: cli}
+}
+
+// setScript sets KEYS[1] to ARGV[1] if it is later than the stored one
+var setScript = redis.NewScript(`
+local at = tonumber(redis.call('GET', KEYS[1]))
+if at == nil or at < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// Set Set
+func (r *RedisLastSeenStore) Set(account string, at int64) error {
+	return setScript.Run(context.Background(), r.cli, []string{keyLastSeen(account)}, at).Err()
+}
+
+// Get Get
+func (r *RedisLastSeenStore) Get(accounts ...string) (map[string]int64, error) {
+	seen := make(map[string]int64, len(accounts))
+	if len(accounts) == 0 {
+		return seen, nil
+	}
+	keys := make([]string, len(accounts))
+	for i, account := range accounts {
+		keys[i] = keyLastSeen(account)
+	}
+	values, err := r.cli.MGet(context.Background(), keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if at, err := strconv.ParseInt(s, 10, 64); err == nil {
+			seen[accounts[i]] = at
+		}
+	}
+	return seen, nil
+}
+
+func keyLastSeen(account string) string {
+	return fmt.Sprintf("presence:seen:%s", account)
+}
@@ -0,0 +1,303 @@
+package dim
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"dim/logger"
+)
+
+// errors of ReconnectClient
+var (
+	ErrDisconnected = errors.New("client is disconnected")
+	ErrClientClosed = errors.New("client is closed")
+	ErrBufferFull   = errors.New("send buffer is full")
+)
+
+// ReconnectOptions ReconnectOptions
+type ReconnectOptions struct {
+	// MinBackoff and MaxBackoff bound the jittered exponential backoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts of reconnecting, 0 means no limit
+	MaxAttempts int
+	// BufferSize is the max number of payloads buffered by Send while
+	// disconnected, they are sent once reconnected. 0 disables buffering.
+	BufferSize   int
+	OnConnect    func()
+	OnDisconnect func(error)
+}
+
+// ReconnectClient is a Client reconnecting with jittered exponential backoff
+// once the connection is broken. A new Client is built by newClient for each
+// connection, so the handshake of the Dialer is run again, a Dialer keeping
+// a resume token sends it on reconnecting.
+//
+// A broken connection is detected by Read or Send.
+type ReconnectClient struct {
+	sync.Mutex
+	newClient func() Client
+	dialer    Dialer
+	id        string
+	name      string
+	addr      string
+	options   ReconnectOptions
+	cli       Client
+	buffer    [][]byte
+	connected chan struct{}
+	closed    *Event
+	err       error
+}
+
+// NewReconnectClient NewReconnectClient
+func NewReconnectClient(newClient func() Client, opts ReconnectOptions) *ReconnectClient {
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = time.Second
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = time.Second * 30
+	}
+	tpl := newClient()
+	return &ReconnectClient{
+		newClient: newClient,
+		id:        tpl.ID(),
+		name:      tpl.Name(),
+		options:   opts,
+		connected: make(chan struct{}),
+		closed:    NewEvent(),
+	}
+}
+
+// ID ID
+func (c *ReconnectClient) ID() string { return c.id }
+
+// Name Name
+func (c *ReconnectClient) Name() string { return c.name }
+
+// SetDialer SetDialer
+func (c *ReconnectClient) SetDialer(dialer Dialer) {
+	c.dialer = dialer
+}
+
+// Connect to addr, it reconnects to addr since then
+func (c *ReconnectClient) Connect(addr string) error {
+	c.addr = addr
+	cli, err := c.dial()
+	if err != nil {
+		return err
+	}
+	c.setClient(cli)
+	return nil
+}
+
+// Send payload, it is buffered while disconnected if BufferSize > 0
+func (c *ReconnectClient) Send(payload []byte) error {
+	c.Lock()
+	if c.closed.HasFired() {
+		c.Unlock()
+		return c.closedErr()
+	}
+	cli := c.cli
+	if cli == nil {
+		defer c.Unlock()
+		return c.bufferLocked(payload)
+	}
+	c.Unlock()
+
+	err := cli.Send(payload)
+	if err != nil {
+		c.disconnect(cli, err)
+		c.Lock()
+		defer c.Unlock()
+		if c.options.BufferSize > 0 {
+			return c.bufferLocked(payload)
+		}
+	}
+	return err
+}
+
+// Read the next frame, it blocks while reconnecting and only returns an error
+// once the client is closed or reconnecting is given up.
+func (c *ReconnectClient) Read() (Frame, error) {
+	for {
+		c.Lock()
+		cli, connected := c.cli, c.connected
+		c.Unlock()
+
+		if cli == nil {
+			select {
+			case <-connected:
+				continue
+			case <-c.closed.Done():
+				return nil, c.closedErr()
+			}
+		}
+		frame, err := cli.Read()
+		if err == nil {
+			return frame, nil
+		}
+		if c.closed.HasFired() {
+			return nil, c.closedErr()
+		}
+		c.disconnect(cli, err)
+	}
+}
+
+// Close Close
+func (c *ReconnectClient) Close() {
+	c.Lock()
+	cli := c.cli
+	c.cli = nil
+	c.Unlock()
+
+	c.closed.Fire()
+	if cli != nil {
+		cli.Close()
+	}
+}
+
+// Done returns a channel closed once the client is closed or reconnecting is given up
+func (c *ReconnectClient) Done() <-chan struct{} {
+	return c.closed.Done()
+}
+
+// Err returns the error reconnecting is given up with
+func (c *ReconnectClient) Err() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.err
+}
+
+func (c *ReconnectClient) dial() (Client, error) {
+	cli := c.newClient()
+	cli.SetDialer(c.dialer)
+	if err := cli.Connect(c.addr); err != nil {
+		return nil, err
+	}
+	return cli, nil
+}
+
+func (c *ReconnectClient) setClient(cli Client) {
+	for {
+		c.Lock()
+		if c.closed.HasFired() {
+			c.Unlock()
+			cli.Close()
+			return
+		}
+		buffer := c.buffer
+		c.buffer = nil
+		if len(buffer) == 0 {
+			c.cli = cli
+			close(c.connected)
+			c.Unlock()
+			break
+		}
+		c.Unlock()
+
+		// the buffer is sent unlocked, the payloads sent meanwhile are
+		// buffered after it, and sent in the next round
+		for i, payload := range buffer {
+			if err := cli.Send(payload); err != nil {
+				c.Lock()
+				c.buffer = append(buffer[i:], c.buffer...)
+				c.Unlock()
+				c.broken(cli, err)
+				return
+			}
+		}
+	}
+
+	if c.options.OnConnect != nil {
+		c.options.OnConnect()
+	}
+}
+
+// disconnect starts reconnecting if cli is the current client
+func (c *ReconnectClient) disconnect(cli Client, err error) {
+	c.Lock()
+	if c.cli != cli {
+		c.Unlock()
+		return
+	}
+	c.cli = nil
+	c.connected = make(chan struct{})
+	c.Unlock()
+
+	c.broken(cli, err)
+}
+
+func (c *ReconnectClient) broken(cli Client, err error) {
+	cli.Close()
+	logger.WithFields(logger.Fields{
+		"module": "ReconnectClient",
+		"id":     c.id,
+	}).Warnf("disconnected: %v", err)
+	if c.options.OnDisconnect != nil {
+		c.options.OnDisconnect(err)
+	}
+	if !c.closed.HasFired() {
+		go c.reconnect()
+	}
+}
+
+func (c *ReconnectClient) reconnect() {
+	log := logger.WithFields(logger.Fields{
+		"module": "ReconnectClient",
+		"id":     c.id,
+	})
+	var err error
+	for attempt := 0; c.options.MaxAttempts == 0 || attempt < c.options.MaxAttempts; attempt++ {
+		select {
+		case <-time.After(c.backoff(attempt)):
+		case <-c.closed.Done():
+			return
+		}
+		var cli Client
+		cli, err = c.dial()
+		if err == nil {
+			log.Infof("reconnected after %d attempts", attempt+1)
+			c.setClient(cli)
+			return
+		}
+		log.Warnf("reconnect failed: %v", err)
+	}
+	c.Lock()
+	c.err = err
+	c.Unlock()
+	c.closed.Fire()
+}
+
+// backoff returns a duration between d/2 and d, d grows exponentially with attempt
+func (c *ReconnectClient) backoff(attempt int) time.Duration {
+	d := c.options.MinBackoff
+	for i := 0; i < attempt && d < c.options.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > c.options.MaxBackoff {
+		d = c.options.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (c *ReconnectClient) bufferLocked(payload []byte) error {
+	if c.options.BufferSize == 0 {
+		return ErrDisconnected
+	}
+	if len(c.buffer) >= c.options.BufferSize {
+		return ErrBufferFull
+	}
+	c.buffer = append(c.buffer, payload)
+	return nil
+}
+
+func (c *ReconnectClient) closedErr() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return ErrClientClosed
+}
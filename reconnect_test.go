@@ -0,0 +1,273 @@
+package dim_test
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dim"
+	"dim/auth"
+	"dim/handshake"
+	"dim/tcp"
+)
+
+type tcpDialer struct{}
+
+func (d *tcpDialer) DialAndHandshake(ctx dim.DialerContext) (net.Conn, error) {
+	return net.DialTimeout("tcp", ctx.Address, ctx.Timeout)
+}
+
+func port(lst net.Listener) string {
+	_, p, _ := net.SplitHostPort(lst.Addr().String())
+	return p
+}
+
+func TestReconnectClient(t *testing.T) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lst.Close()
+
+	// every connection receives one frame and is closed by the server
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := lst.Accept()
+			if err != nil {
+				return
+			}
+			_ = tcp.WriteFrame(conn, dim.OpBinary, []byte{byte(i)})
+			conn.Close()
+		}
+	}()
+
+	var connects, disconnects int32
+	cli := dim.NewReconnectClient(func() dim.Client {
+		return tcp.NewClient("c1", "client", tcp.ClientOptions{})
+	}, dim.ReconnectOptions{
+		MinBackoff:   time.Millisecond * 10,
+		MaxBackoff:   time.Millisecond * 50,
+		OnConnect:    func() { atomic.AddInt32(&connects, 1) },
+		OnDisconnect: func(error) { atomic.AddInt32(&disconnects, 1) },
+	})
+	cli.SetDialer(&tcpDialer{})
+	if err = cli.Connect("localhost:" + port(lst)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		frame, err := cli.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.GetPayload()[0] != byte(i) {
+			t.Fatalf("expect frame %d, got %d", i, frame.GetPayload()[0])
+		}
+	}
+	cli.Close()
+	if _, err = cli.Read(); err != dim.ErrClientClosed {
+		t.Fatalf("expect ErrClientClosed, got %v", err)
+	}
+	if atomic.LoadInt32(&connects) != 3 || atomic.LoadInt32(&disconnects) != 2 {
+		t.Fatalf("unexpected callbacks %d %d", connects, disconnects)
+	}
+}
+
+func TestReconnectGiveUp(t *testing.T) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := lst.Accept()
+		if err != nil {
+			return
+		}
+		lst.Close()
+		conn.Close()
+	}()
+
+	cli := dim.NewReconnectClient(func() dim.Client {
+		return tcp.NewClient("c1", "client", tcp.ClientOptions{})
+	}, dim.ReconnectOptions{
+		MinBackoff:  time.Millisecond * 10,
+		MaxAttempts: 2,
+		BufferSize:  1,
+	})
+	cli.SetDialer(&tcpDialer{})
+	if err = cli.Connect("localhost:" + port(lst)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = cli.Read(); err == nil {
+		t.Fatal("expect an error")
+	}
+	select {
+	case <-cli.Done():
+	case <-time.After(time.Second):
+		t.Fatal("reconnecting is not given up")
+	}
+	if cli.Err() == nil {
+		t.Fatal("expect the last dial error")
+	}
+}
+
+func TestReconnectBuffer(t *testing.T) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lst.Close()
+
+	// the first connection is closed by the server, the frames of the second
+	// one are received
+	received := make(chan string, 3)
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := lst.Accept()
+			if err != nil {
+				return
+			}
+			if i == 0 {
+				conn.Close()
+				continue
+			}
+			go func() {
+				defer conn.Close()
+				c := tcp.NewConn(conn)
+				for {
+					frame, err := c.ReadFrame()
+					if err != nil {
+						return
+					}
+					received <- string(frame.GetPayload())
+				}
+			}()
+		}
+	}()
+
+	disconnected := make(chan struct{}, 1)
+	cli := dim.NewReconnectClient(func() dim.Client {
+		return tcp.NewClient("c1", "client", tcp.ClientOptions{})
+	}, dim.ReconnectOptions{
+		MinBackoff:   time.Millisecond * 50,
+		BufferSize:   2,
+		OnDisconnect: func(error) { disconnected <- struct{}{} },
+	})
+	cli.SetDialer(&tcpDialer{})
+	if err = cli.Connect("localhost:" + port(lst)); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	go func() {
+		for {
+			if _, err := cli.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("not disconnected")
+	}
+	// buffered while reconnecting
+	for _, payload := range []string{"a", "b"} {
+		if err = cli.Send([]byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = cli.Send([]byte("c")); err != dim.ErrBufferFull {
+		t.Fatalf("expect ErrBufferFull, got %v", err)
+	}
+	for _, want := range []string{"a", "b"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Fatalf("expect %s, got %s", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s is not sent once reconnected", want)
+		}
+	}
+}
+
+// resumer resumes the session of the token issued last
+type resumer struct {
+	sync.Mutex
+	token string
+}
+
+func (r *resumer) Issue(channelID string) string {
+	r.Lock()
+	defer r.Unlock()
+	r.token = channelID + "/" + time.Now().String()
+	return r.token
+}
+
+func (r *resumer) Resume(channelID, token string, lastSeq uint64) bool {
+	r.Lock()
+	defer r.Unlock()
+	return token == r.token
+}
+
+func TestReconnectResume(t *testing.T) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lst.Close()
+
+	// every connection is closed by the server once handshaked
+	signer := auth.NewTicketSigner([]byte("secret"))
+	acceptor := handshake.NewAcceptor(signer, handshake.Options{Resumer: &resumer{}})
+	go func() {
+		for {
+			conn, err := lst.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = acceptor.Accept(tcp.NewConn(conn), time.Second)
+			conn.Close()
+		}
+	}()
+
+	dialer := handshake.NewTCPDialer(handshake.ClientHello{Token: signer.Sign("u1", time.Minute)})
+	resumed := make(chan bool, 2)
+	cli := dim.NewReconnectClient(func() dim.Client {
+		return tcp.NewClient("c1", "client", tcp.ClientOptions{})
+	}, dim.ReconnectOptions{
+		MinBackoff: time.Millisecond * 10,
+		OnConnect: func() {
+			select {
+			case resumed <- dialer.ServerHello().Resumed:
+			default:
+			}
+		},
+	})
+	cli.SetDialer(dialer)
+	if err = cli.Connect("localhost:" + port(lst)); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	go func() {
+		for {
+			if _, err := cli.Read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// the token issued by the first handshake is sent on reconnecting
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-resumed:
+			if r != (i == 1) {
+				t.Fatalf("unexpected resumed %v of connection %d", r, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("connection %d is not connected", i)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package reliable
+
+import (
+	"encoding/binary"
+
+	"dim/wire/pkt"
+)
+
+func encodePush(seq uint64, payload []byte) []byte {
+	body := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint64(body, seq)
+	copy(body[8:], payload)
+	return pkt.MarshalBasic(&pkt.BasicPkt{Code: pkt.CodeReliablePush, Body: body})
+}
+
+func encodeAck(seq uint64) []byte {
+	body := make([]byte, 8)
+	binary.BigEndian.PutUint64(body, seq)
+	return pkt.MarshalBasic(&pkt.BasicPkt{Code: pkt.CodeAck, Body: body})
+}
+
+// decode returns the code, sequence and payload of a reliable push or an ack
+func decode(buf []byte) (uint16, uint64, []byte, bool) {
+	p, err := pkt.Read(buf)
+	if err != nil {
+		return 0, 0, nil, false
+	}
+	basic, ok := p.(*pkt.BasicPkt)
+	if !ok || len(basic.Body) < 8 {
+		return 0, 0, nil, false
+	}
+	if basic.Code != pkt.CodeReliablePush && basic.Code != pkt.CodeAck {
+		return 0, 0, nil, false
+	}
+	return basic.Code, binary.BigEndian.Uint64(basic.Body), basic.Body[8:], true
+}
@@ -0,0 +1,499 @@
+package reliable
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"dim"
+	"dim/auth"
+	"dim/logger"
+	"dim/wire/pkt"
+
+	"github.com/segmentio/ksuid"
+)
+
+// ErrChannelNotFound is returned by Push if the channel is not attached
+var ErrChannelNotFound = errors.New("channel is not found")
+
+// UndeliveredHandler receives the payloads not acked by the client, they can
+// be stored and delivered on next login.
+type UndeliveredHandler interface {
+	Undelivered(channelID string, seq uint64, payload []byte)
+}
+
+// Options Options
+type Options struct {
+	// RetryInterval is the interval of the first retry, it is doubled for
+	// each retry. Default to 3s.
+	RetryInterval time.Duration
+	// MaxRetries default to 3
+	MaxRetries int
+	// ResumeWindow is how long the session of a disconnected channel is kept,
+	// the payloads pushed meanwhile are buffered and replayed once the client
+	// resumes the session. 0 disables resuming.
+	ResumeWindow time.Duration
+	// BufferSize is the max number of payloads waiting for acks of a session,
+	// the oldest one is handed to the UndeliveredHandler once exceeded.
+	// Default to 256.
+	BufferSize int
+	// Signer signs the resume tokens, it is required if ResumeWindow > 0
+	Signer *auth.TicketSigner
+}
+
+type message struct {
+	seq     uint64
+	payload []byte
+	retries int
+	timer   *time.Timer
+	// err is the error of the first push, it is set by the writer holding
+	// the wmutex of the session
+	err error
+}
+
+type sessionState int
+
+const (
+	// created by Issue, waiting for the channel
+	stateNew sessionState = iota
+	stateAttached
+	stateDetached
+	// resumed by Resume, waiting for the channel
+	stateResuming
+)
+
+type session struct {
+	nonce   string
+	state   sessionState
+	unacked map[uint64]*message
+	lastSeq uint64
+	expire  *time.Timer
+	// queue is the payloads waiting to be pushed in order of sequence, it is
+	// written by the holder of wmutex without holding the Pusher
+	queue  []*message
+	wmutex sync.Mutex
+}
+
+// Pusher pushes payloads through a Server and waits for the acks of them, a
+// payload not acked is pushed again with backoff, and it is handed to the
+// UndeliveredHandler once the retries are exhausted or the channel is
+// disconnected.
+//
+// If ResumeWindow is set, the session of a disconnected channel is kept for
+// the window, a client presenting the resume token issued at handshake and
+// the last sequence received gets the payloads after it replayed. Pusher is
+// a handshake.Resumer, and the ChannelMap of the server must be wrapped by
+// ChannelMap to replay on attaching.
+//
+// The sequences are increasing across the channels, starting from the time
+// the Pusher is created, so that they keep increasing for a client after the
+// server restarts.
+type Pusher struct {
+	sync.Mutex
+	server   dim.Server
+	handler  UndeliveredHandler
+	options  Options
+	seq      uint64
+	sessions map[string]*session
+}
+
+// NewPusher NewPusher
+func NewPusher(server dim.Server, handler UndeliveredHandler, opts Options) *Pusher {
+	if opts.RetryInterval == 0 {
+		opts.RetryInterval = time.Second * 3
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.BufferSize == 0 {
+		opts.BufferSize = 256
+	}
+	return &Pusher{
+		server:   server,
+		handler:  handler,
+		options:  opts,
+		seq:      uint64(time.Now().UnixNano()),
+		sessions: make(map[string]*session),
+	}
+}
+
+// Push payload to the channel, it is handed to the UndeliveredHandler if the
+// channel is not found. It is buffered if the session of the channel is
+// waiting for resuming.
+func (p *Pusher) Push(channelID string, payload []byte) (uint64, error) {
+	p.Lock()
+	s, ok := p.sessions[channelID]
+	if !ok && p.options.ResumeWindow > 0 {
+		// the sessions are created by attaching the channels, and they are
+		// never created here to not leak with the window
+		p.seq++
+		seq := p.seq
+		p.Unlock()
+		p.handler.Undelivered(channelID, seq, payload)
+		return seq, ErrChannelNotFound
+	}
+	if !ok {
+		// removed once the payloads are acked
+		s = p.newSession(channelID, stateAttached)
+	}
+	p.seq++
+	msg := &message{
+		seq:     p.seq,
+		payload: payload,
+	}
+	s.unacked[msg.seq] = msg
+	evicted := p.evictLocked(s)
+	if s.state != stateAttached {
+		p.Unlock()
+		p.undelivered(channelID, evicted)
+		return msg.seq, nil
+	}
+	msg.timer = time.AfterFunc(p.options.RetryInterval, func() {
+		p.retry(channelID, msg)
+	})
+	s.queue = append(s.queue, msg)
+	p.Unlock()
+
+	p.undelivered(channelID, evicted)
+	p.flush(channelID, s)
+	return msg.seq, msg.err
+}
+
+// Ack the push of seq
+func (p *Pusher) Ack(channelID string, seq uint64) {
+	p.Lock()
+	defer p.Unlock()
+	p.removeLocked(channelID, seq)
+}
+
+// Unacked returns the number of payloads of the channel waiting for acks
+func (p *Pusher) Unacked(channelID string) int {
+	p.Lock()
+	defer p.Unlock()
+	if s, ok := p.sessions[channelID]; ok {
+		return len(s.unacked)
+	}
+	return 0
+}
+
+// Disconnect hands the payloads not acked of the channel to the
+// UndeliveredHandler, or keeps them for ResumeWindow.
+func (p *Pusher) Disconnect(channelID string) {
+	p.Lock()
+	s, ok := p.sessions[channelID]
+	if !ok || s.state != stateAttached {
+		p.Unlock()
+		return
+	}
+	for _, msg := range s.unacked {
+		msg.timer.Stop()
+		msg.retries = 0
+	}
+	if p.options.ResumeWindow == 0 {
+		delete(p.sessions, channelID)
+		p.Unlock()
+		p.undelivered(channelID, sorted(s.unacked))
+		return
+	}
+	s.state = stateDetached
+	s.expire = time.AfterFunc(p.options.ResumeWindow, func() {
+		p.Lock()
+		if p.sessions[channelID] != s || s.state != stateDetached {
+			p.Unlock()
+			return
+		}
+		delete(p.sessions, channelID)
+		p.Unlock()
+		p.undelivered(channelID, sorted(s.unacked))
+	})
+	p.Unlock()
+}
+
+// Issue a resume token of the channel, it is called at handshake
+func (p *Pusher) Issue(channelID string) string {
+	if p.options.ResumeWindow == 0 || p.options.Signer == nil {
+		return ""
+	}
+	p.Lock()
+	s, ok := p.sessions[channelID]
+	var replaced []*message
+	if !ok || s.state == stateDetached {
+		if ok {
+			s.expire.Stop()
+			replaced = sorted(s.unacked)
+		}
+		s = p.newSession(channelID, stateNew)
+	}
+	nonce := s.nonce
+	p.Unlock()
+
+	p.undelivered(channelID, replaced)
+	return p.options.Signer.Sign(channelID+"/"+nonce, p.options.ResumeWindow+time.Hour*24)
+}
+
+// Resume the detached session of the channel, the payloads after lastSeq are
+// replayed once the channel is added.
+func (p *Pusher) Resume(channelID, token string, lastSeq uint64) bool {
+	if p.options.ResumeWindow == 0 || p.options.Signer == nil {
+		return false
+	}
+	subject, err := p.options.Signer.Verify(token)
+	if err != nil {
+		return false
+	}
+	p.Lock()
+	defer p.Unlock()
+	s, ok := p.sessions[channelID]
+	if !ok || s.state != stateDetached || subject != channelID+"/"+s.nonce {
+		return false
+	}
+	s.expire.Stop()
+	s.state = stateResuming
+	s.lastSeq = lastSeq
+	return true
+}
+
+// ChannelMap returns a ChannelMap attaching the session of a channel added,
+// the payloads buffered are pushed to the channel before it is added to next.
+func (p *Pusher) ChannelMap(next dim.ChannelMap) dim.ChannelMap {
+	return &channelMap{ChannelMap: next, pusher: p}
+}
+
+func (p *Pusher) attach(next dim.ChannelMap, ch dim.Channel) {
+	id := ch.ID()
+	p.Lock()
+	s, ok := p.sessions[id]
+	var dropped []*message
+	switch {
+	case !ok:
+		s = p.newSession(id, stateNew)
+	case s.state == stateDetached:
+		// not resumed
+		s.expire.Stop()
+		dropped = sorted(s.unacked)
+		s = p.newSession(id, stateNew)
+	case s.state == stateResuming || s.state == stateNew:
+		for seq := range s.unacked {
+			if seq <= s.lastSeq {
+				delete(s.unacked, seq)
+			}
+		}
+	}
+	p.Unlock()
+	p.undelivered(id, dropped)
+
+	// the payloads pushed until the session is attached are buffered, so
+	// they are replayed after the channel is added
+	next.Add(ch)
+
+	p.Lock()
+	if p.sessions[id] != s || s.state == stateAttached || s.state == stateDetached {
+		p.Unlock()
+		return
+	}
+	for _, msg := range sorted(s.unacked) {
+		msg := msg
+		msg.timer = time.AfterFunc(p.options.RetryInterval, func() {
+			p.retry(id, msg)
+		})
+		s.queue = append(s.queue, msg)
+	}
+	s.state = stateAttached
+	p.Unlock()
+
+	// the disconnection is ignored before the session is attached
+	if cur, ok := next.Get(id); !ok || cur != ch {
+		p.Disconnect(id)
+		return
+	}
+	p.flush(id, s)
+}
+
+// flush pushes the queue of the session in order of sequence, the payloads
+// failed to push are handed to the UndeliveredHandler.
+func (p *Pusher) flush(channelID string, s *session) {
+	s.wmutex.Lock()
+	p.Lock()
+	var queue []*message
+	for _, msg := range s.queue {
+		// evicted or detached meanwhile
+		if s.state == stateAttached && s.unacked[msg.seq] == msg {
+			queue = append(queue, msg)
+		}
+	}
+	s.queue = nil
+	p.Unlock()
+
+	var failed []*message
+	for _, msg := range queue {
+		msg.err = p.server.Push(channelID, encodePush(msg.seq, msg.payload))
+		if msg.err != nil {
+			failed = append(failed, msg)
+		}
+	}
+	s.wmutex.Unlock()
+	if len(failed) == 0 {
+		return
+	}
+
+	p.Lock()
+	var undelivered []*message
+	for _, msg := range failed {
+		if p.removeSessionLocked(channelID, s, msg.seq) {
+			undelivered = append(undelivered, msg)
+		}
+	}
+	p.Unlock()
+	p.undelivered(channelID, undelivered)
+}
+
+func (p *Pusher) newSession(channelID string, state sessionState) *session {
+	s := &session{
+		nonce:   ksuid.New().String(),
+		state:   state,
+		unacked: make(map[uint64]*message),
+	}
+	p.sessions[channelID] = s
+	return s
+}
+
+// evictLocked removes the oldest payloads exceeding BufferSize
+func (p *Pusher) evictLocked(s *session) []*message {
+	if len(s.unacked) <= p.options.BufferSize {
+		return nil
+	}
+	msgs := sorted(s.unacked)
+	evicted := msgs[:len(msgs)-p.options.BufferSize]
+	for _, msg := range evicted {
+		if msg.timer != nil {
+			msg.timer.Stop()
+		}
+		delete(s.unacked, msg.seq)
+	}
+	return evicted
+}
+
+// removeSessionLocked removes seq if s is still the attached session of the
+// channel, the payloads of a detached session are kept for resuming.
+func (p *Pusher) removeSessionLocked(channelID string, s *session, seq uint64) bool {
+	if p.sessions[channelID] != s || s.state != stateAttached {
+		return false
+	}
+	return p.removeLocked(channelID, seq)
+}
+
+func (p *Pusher) removeLocked(channelID string, seq uint64) bool {
+	s, ok := p.sessions[channelID]
+	if !ok {
+		return false
+	}
+	msg, ok := s.unacked[seq]
+	if !ok {
+		return false
+	}
+	if msg.timer != nil {
+		msg.timer.Stop()
+	}
+	delete(s.unacked, seq)
+	if len(s.unacked) == 0 && s.state == stateAttached && p.options.ResumeWindow == 0 {
+		delete(p.sessions, channelID)
+	}
+	return true
+}
+
+func (p *Pusher) retry(channelID string, msg *message) {
+	log := logger.WithFields(logger.Fields{
+		"module": "reliable.pusher",
+		"id":     channelID,
+	})
+	p.Lock()
+	s, ok := p.sessions[channelID]
+	if !ok || s.state != stateAttached || s.unacked[msg.seq] != msg {
+		p.Unlock()
+		return
+	}
+	if msg.retries >= p.options.MaxRetries {
+		p.removeLocked(channelID, msg.seq)
+		p.Unlock()
+		log.Warnf("push %d is not acked after %d retries", msg.seq, msg.retries)
+		p.handler.Undelivered(channelID, msg.seq, msg.payload)
+		return
+	}
+	msg.retries++
+	msg.timer.Reset(p.options.RetryInterval << msg.retries)
+	p.Unlock()
+
+	log.Debugf("retry push %d", msg.seq)
+	if err := p.server.Push(channelID, encodePush(msg.seq, msg.payload)); err == nil {
+		return
+	}
+	p.Lock()
+	removed := p.removeSessionLocked(channelID, s, msg.seq)
+	p.Unlock()
+	if removed {
+		p.handler.Undelivered(channelID, msg.seq, msg.payload)
+	}
+}
+
+func (p *Pusher) undelivered(channelID string, msgs []*message) {
+	for _, msg := range msgs {
+		p.handler.Undelivered(channelID, msg.seq, msg.payload)
+	}
+}
+
+func sorted(unacked map[uint64]*message) []*message {
+	msgs := make([]*message, 0, len(unacked))
+	for _, msg := range unacked {
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].seq < msgs[j].seq
+	})
+	return msgs
+}
+
+// MessageListener returns a MessageListener handling the acks, other
+// payloads are received by next.
+func (p *Pusher) MessageListener(next dim.MessageListener) dim.MessageListener {
+	return &messageListener{pusher: p, next: next}
+}
+
+// StateListener returns a StateListener calling Disconnect before next
+func (p *Pusher) StateListener(next dim.StateListener) dim.StateListener {
+	return &stateListener{pusher: p, next: next}
+}
+
+type messageListener struct {
+	pusher *Pusher
+	next   dim.MessageListener
+}
+
+func (l *messageListener) Receive(ag dim.Agent, payload []byte) {
+	if code, seq, _, ok := decode(payload); ok {
+		if code == pkt.CodeAck {
+			l.pusher.Ack(ag.ID(), seq)
+		}
+		return
+	}
+	l.next.Receive(ag, payload)
+}
+
+type stateListener struct {
+	pusher *Pusher
+	next   dim.StateListener
+}
+
+func (l *stateListener) Disconnect(id string) error {
+	l.pusher.Disconnect(id)
+	return l.next.Disconnect(id)
+}
+
+type channelMap struct {
+	dim.ChannelMap
+	pusher *Pusher
+}
+
+func (m *channelMap) Add(ch dim.Channel) {
+	m.pusher.attach(m.ChannelMap, ch)
+}
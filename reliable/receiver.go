@@ -0,0 +1,78 @@
+package reliable
+
+import (
+	"sync"
+
+	"dim"
+	"dim/logger"
+	"dim/wire/pkt"
+)
+
+// DefaultWindow is the number of the latest sequences remembered by a Receiver
+const DefaultWindow = 1024
+
+// Receiver is a ClientListener acking the reliable pushes and dropping the
+// duplicated ones, the payloads are received by next.
+type Receiver struct {
+	sync.Mutex
+	next   dim.ClientListener
+	seen   map[uint64]struct{}
+	order  []uint64
+	window int
+	last   uint64
+}
+
+// NewReceiver NewReceiver
+func NewReceiver(next dim.ClientListener) *Receiver {
+	return &Receiver{
+		next:   next,
+		seen:   make(map[uint64]struct{}, DefaultWindow),
+		window: DefaultWindow,
+	}
+}
+
+// Receive Receive
+func (r *Receiver) Receive(cli dim.Client, payload []byte) {
+	if body, ok := r.Unwrap(cli, payload); ok {
+		r.next.Receive(cli, body)
+	}
+}
+
+// Unwrap acks a reliable push and returns the payload of it, false is returned
+// if it is duplicated. Other payloads are returned as is.
+func (r *Receiver) Unwrap(cli dim.Client, payload []byte) ([]byte, bool) {
+	code, seq, body, ok := decode(payload)
+	if !ok || code != pkt.CodeReliablePush {
+		return payload, true
+	}
+	if err := cli.Send(encodeAck(seq)); err != nil {
+		logger.WithFields(logger.Fields{
+			"module": "reliable.receiver",
+			"id":     cli.ID(),
+		}).Warnf("ack %d failed: %v", seq, err)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.seen[seq]; ok {
+		return nil, false
+	}
+	r.seen[seq] = struct{}{}
+	if seq > r.last {
+		r.last = seq
+	}
+	r.order = append(r.order, seq)
+	if len(r.order) > r.window {
+		delete(r.seen, r.order[0])
+		r.order = r.order[1:]
+	}
+	return body, true
+}
+
+// LastSeq returns the sequence of the last push received, the pushes are
+// received in order of sequence on a connection.
+func (r *Receiver) LastSeq() uint64 {
+	r.Lock()
+	defer r.Unlock()
+	return r.last
+}
@@ -0,0 +1,282 @@
+package reliable
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"dim"
+	"dim/auth"
+)
+
+// server records the payloads pushed to the online channels
+type server struct {
+	dim.Server
+	sync.Mutex
+	online map[string]bool
+	pushed map[string][][]byte
+}
+
+func (s *server) Push(id string, payload []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	if !s.online[id] {
+		return errors.New("channel no found")
+	}
+	s.pushed[id] = append(s.pushed[id], payload)
+	return nil
+}
+
+func (s *server) count(id string) int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.pushed[id])
+}
+
+type undelivered struct {
+	sync.Mutex
+	seqs []uint64
+}
+
+func (u *undelivered) Undelivered(channelID string, seq uint64, payload []byte) {
+	u.Lock()
+	defer u.Unlock()
+	u.seqs = append(u.seqs, seq)
+}
+
+func (u *undelivered) count() int {
+	u.Lock()
+	defer u.Unlock()
+	return len(u.seqs)
+}
+
+// client sends the acks to the pusher
+type client struct {
+	dim.Client
+	pusher *Pusher
+}
+
+func (c *client) ID() string { return "c1" }
+
+func (c *client) Send(payload []byte) error {
+	c.pusher.MessageListener(nil).Receive(c, payload)
+	return nil
+}
+
+func (c *client) Push([]byte) error { return nil }
+
+type payloads chan []byte
+
+func (p payloads) Receive(cli dim.Client, payload []byte) {
+	p <- payload
+}
+
+func TestPusher(t *testing.T) {
+	srv := &server{online: map[string]bool{"c1": true}, pushed: map[string][][]byte{}}
+	handler := &undelivered{}
+	pusher := NewPusher(srv, handler, Options{
+		RetryInterval: time.Millisecond * 10,
+		MaxRetries:    2,
+	})
+
+	// offline
+	if _, err := pusher.Push("c2", []byte("hi")); err == nil {
+		t.Fatal("expect an error")
+	}
+	if handler.count() != 1 {
+		t.Fatalf("expect 1 undelivered, got %d", handler.count())
+	}
+
+	// acked by the receiver, the retried push is dropped
+	recv := make(payloads, 10)
+	receiver := NewReceiver(recv)
+	cli := &client{pusher: pusher}
+	seq, err := pusher.Push("c1", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 15)
+	srv.Lock()
+	pushed := srv.pushed["c1"]
+	srv.Unlock()
+	for _, payload := range pushed {
+		receiver.Receive(cli, payload)
+	}
+	if pusher.Unacked("c1") != 0 {
+		t.Fatalf("push %d is not acked", seq)
+	}
+	if len(recv) != 1 || string(<-recv) != "hello" {
+		t.Fatal("expect hello once")
+	}
+
+	// retries exhausted
+	if _, err = pusher.Push("c1", []byte("lost")); err != nil {
+		t.Fatal(err)
+	}
+	before := srv.count("c1")
+	time.Sleep(time.Millisecond * 150)
+	if srv.count("c1")-before != 2 {
+		t.Fatalf("expect 2 retries, got %d", srv.count("c1")-before)
+	}
+	if handler.count() != 2 {
+		t.Fatalf("expect 2 undelivered, got %d", handler.count())
+	}
+
+	// disconnected
+	_, _ = pusher.Push("c1", []byte("a"))
+	_, _ = pusher.Push("c1", []byte("b"))
+	pusher.Disconnect("c1")
+	if handler.count() != 4 {
+		t.Fatalf("expect 4 undelivered, got %d", handler.count())
+	}
+	if handler.seqs[2] > handler.seqs[3] {
+		t.Fatal("undelivered payloads are out of order")
+	}
+}
+
+// channel records the payloads pushed
+type channel struct {
+	dim.Channel
+	id     string
+	pushed payloads
+}
+
+func (c *channel) ID() string { return c.id }
+
+func (c *channel) Push(payload []byte) error {
+	c.pushed <- payload
+	return nil
+}
+
+type channelServer struct {
+	dim.Server
+	dim.ChannelMap
+}
+
+func (s *channelServer) Push(id string, payload []byte) error {
+	ch, ok := s.Get(id)
+	if !ok {
+		return errors.New("channel no found")
+	}
+	return ch.Push(payload)
+}
+
+func seqs(t *testing.T, p payloads, n int) []uint64 {
+	t.Helper()
+	if len(p) != n {
+		t.Fatalf("expect %d payloads, got %d", n, len(p))
+	}
+	arr := make([]uint64, 0, n)
+	for i := 0; i < n; i++ {
+		_, seq, _, _ := decode(<-p)
+		arr = append(arr, seq)
+	}
+	return arr
+}
+
+func TestResume(t *testing.T) {
+	srv := &channelServer{}
+	handler := &undelivered{}
+	pusher := NewPusher(srv, handler, Options{
+		RetryInterval: time.Second,
+		ResumeWindow:  time.Millisecond * 100,
+		Signer:        auth.NewTicketSigner([]byte("secret")),
+	})
+	srv.ChannelMap = pusher.ChannelMap(dim.NewChannels(10))
+
+	token := pusher.Issue("c1")
+	ch1 := &channel{id: "c1", pushed: make(payloads, 10)}
+	srv.Add(ch1)
+	a, _ := pusher.Push("c1", []byte("a"))
+	b, _ := pusher.Push("c1", []byte("b"))
+	pusher.Ack("c1", b)
+	_ = seqs(t, ch1.pushed, 2)
+
+	// a is received but the ack is lost
+	srv.Remove("c1")
+	pusher.Disconnect("c1")
+	c, err := pusher.Push("c1", []byte("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pusher.Resume("c1", "invalid", a) {
+		t.Fatal("resumed with an invalid token")
+	}
+	if !pusher.Resume("c1", token, b) {
+		t.Fatal("not resumed")
+	}
+	token = pusher.Issue("c1")
+	ch2 := &channel{id: "c1", pushed: make(payloads, 10)}
+	srv.Add(ch2)
+	if replayed := seqs(t, ch2.pushed, 1); replayed[0] != c {
+		t.Fatalf("expect %d replayed, got %v", c, replayed)
+	}
+
+	// expired
+	srv.Remove("c1")
+	pusher.Disconnect("c1")
+	time.Sleep(time.Millisecond * 150)
+	if pusher.Resume("c1", token, c) {
+		t.Fatal("resumed after the window")
+	}
+	if handler.count() != 1 || handler.seqs[0] != c {
+		t.Fatalf("expect %d undelivered, got %v", c, handler.seqs)
+	}
+}
+
+func TestPushUnknownChannel(t *testing.T) {
+	srv := &channelServer{}
+	handler := &undelivered{}
+	pusher := NewPusher(srv, handler, Options{
+		ResumeWindow: time.Minute,
+		Signer:       auth.NewTicketSigner([]byte("secret")),
+	})
+	srv.ChannelMap = pusher.ChannelMap(dim.NewChannels(10))
+
+	if _, err := pusher.Push("c1", []byte("a")); err != ErrChannelNotFound {
+		t.Fatalf("expect ErrChannelNotFound, got %v", err)
+	}
+	if handler.count() != 1 {
+		t.Fatalf("expect 1 undelivered, got %d", handler.count())
+	}
+	pusher.Lock()
+	n := len(pusher.sessions)
+	pusher.Unlock()
+	if n != 0 {
+		t.Fatalf("expect no session, got %d", n)
+	}
+}
+
+// ackServer acks the payloads synchronously in Push
+type ackServer struct {
+	dim.Server
+	pusher *Pusher
+}
+
+func (s *ackServer) Push(id string, payload []byte) error {
+	_, seq, _, _ := decode(payload)
+	s.pusher.Ack(id, seq)
+	return nil
+}
+
+func TestPushUnlocked(t *testing.T) {
+	srv := &ackServer{}
+	srv.pusher = NewPusher(srv, &undelivered{}, Options{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := srv.pusher.Push("c1", []byte("a")); err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("server is called with the pusher locked")
+	}
+	if srv.pusher.Unacked("c1") != 0 {
+		t.Fatal("push is not acked")
+	}
+}
@@ -0,0 +1,43 @@
+package router
+
+import (
+	"dim"
+	"dim/logger"
+	"dim/wire/pkt"
+)
+
+// GatewayTopic is the topic of the packets pushed to a gateway on a bus
+func GatewayTopic(gateID string) string {
+	return "gateway." + gateID
+}
+
+// BusDispatcher pushes packets to gateways over a bus instead of the links
+// of them, so a node can push to a gateway without being connected by it.
+type BusDispatcher struct {
+	bus dim.Bus
+}
+
+// NewBusDispatcher NewBusDispatcher
+func NewBusDispatcher(bus dim.Bus) *BusDispatcher {
+	return &BusDispatcher{bus: bus}
+}
+
+// Push publishes p to the topic of the gateway
+func (d *BusDispatcher) Push(gateID string, channels []string, p *pkt.LogicPkt) error {
+	return d.bus.Publish(GatewayTopic(gateID), pkt.Marshal(forward(p, channels)))
+}
+
+// Subscribe delivers the packets published to the topic of the gateway
+func (g *Gateway) Subscribe(bus dim.Bus) (dim.Subscription, error) {
+	return bus.Subscribe(GatewayTopic(g.id), func(topic string, msg []byte) {
+		p, err := pkt.Unmarshal(msg)
+		if err != nil {
+			logger.WithFields(logger.Fields{
+				"module": "router.gateway",
+				"id":     g.id,
+			}).Warn(err)
+			return
+		}
+		g.Deliver(p)
+	})
+}
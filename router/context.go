@@ -0,0 +1,170 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"dim"
+	"dim/logger"
+	"dim/tracing"
+	"dim/wire/pkt"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrNoHandler is responded if no handler is registered for a command
+var ErrNoHandler = errors.New("no handler of the command")
+
+// Context is the context of a request in the handlers
+type Context interface {
+	// Context returns the context with the span of the request, it is used
+	// to log with the trace id by logger.WithContext
+	Context() context.Context
+	// Logger returns the entry with the command and the channel of the
+	// request, it logs the trace id of Context
+	Logger() *logrus.Entry
+	Header() *pkt.Header
+	ReadBody(v interface{}) error
+	// SetBody replaces the body of the request, the handlers after read the
+	// new one
+	SetBody(body interface{})
+	Session() *dim.Session
+	// Resp responds to the sender of the request
+	Resp(status pkt.Status, body interface{}) error
+	RespWithError(status pkt.Status, err error) error
+	// Dispatch pushes body with the command of the request to recvs
+	Dispatch(body interface{}, recvs ...*dim.Location) error
+	// DispatchMessage pushes body like Dispatch, the push carries the id of
+	// the message stored by the handler in MetaMessageID, so it is not
+	// stored again by the Offline of the gateways.
+	DispatchMessage(id int64, body interface{}, recvs ...*dim.Location) error
+	// DispatchEphemeral pushes body like Dispatch, the push is marked by
+	// MetaEphemeral, so it is dropped for the receivers not connected.
+	DispatchEphemeral(body interface{}, recvs ...*dim.Location) error
+	GetLocations(accounts ...string) ([]*dim.Location, error)
+	// Next calls the remaining handlers, it is used by a handler to run code
+	// after them.
+	Next()
+	// Abort stops the remaining handlers from being called
+	Abort()
+}
+
+// ContextImpl ContextImpl
+type ContextImpl struct {
+	ctx        context.Context
+	ag         dim.Agent
+	request    *pkt.LogicPkt
+	session    *dim.Session
+	dispatcher Dispatcher
+	store      dim.SessionStore
+	handlers   HandlersChain
+	index      int
+	log        *logrus.Entry
+}
+
+// Context Context
+func (c *ContextImpl) Context() context.Context {
+	return c.ctx
+}
+
+// Logger Logger
+func (c *ContextImpl) Logger() *logrus.Entry {
+	if c.log == nil {
+		c.log = logger.WithFields(logger.Fields{
+			"command": c.request.Command,
+			"channel": c.request.ChannelID,
+		}).WithContext(c.ctx)
+	}
+	return c.log
+}
+
+// Header Header
+func (c *ContextImpl) Header() *pkt.Header {
+	return &c.request.Header
+}
+
+// ReadBody ReadBody
+func (c *ContextImpl) ReadBody(v interface{}) error {
+	return c.request.ReadBody(v)
+}
+
+// SetBody SetBody
+func (c *ContextImpl) SetBody(body interface{}) {
+	c.request.WriteBody(body)
+}
+
+// Session Session
+func (c *ContextImpl) Session() *dim.Session {
+	return c.session
+}
+
+// Resp Resp
+func (c *ContextImpl) Resp(status pkt.Status, body interface{}) error {
+	resp := pkt.NewFrom(&c.request.Header)
+	resp.Status = status
+	resp.WriteBody(body)
+	tracing.Inject(c.ctx, resp)
+	tracing.SetStatus(trace.SpanFromContext(c.ctx), status)
+	return c.ag.Push(pkt.Marshal(resp))
+}
+
+// RespWithError responds with the message of err as the body
+func (c *ContextImpl) RespWithError(status pkt.Status, err error) error {
+	return c.Resp(status, []byte(err.Error()))
+}
+
+// Dispatch Dispatch
+func (c *ContextImpl) Dispatch(body interface{}, recvs ...*dim.Location) error {
+	if len(recvs) == 0 {
+		return nil
+	}
+	p := pkt.New(c.request.Command, pkt.WithFlag(pkt.FlagPush), pkt.WithChannel(c.session.ChannelID))
+	p.WriteBody(body)
+	tracing.Inject(c.ctx, p)
+	return Dispatch(c.dispatcher, p, recvs...)
+}
+
+// DispatchMessage DispatchMessage
+func (c *ContextImpl) DispatchMessage(id int64, body interface{}, recvs ...*dim.Location) error {
+	if len(recvs) == 0 {
+		return nil
+	}
+	p := pkt.New(c.request.Command, pkt.WithFlag(pkt.FlagPush), pkt.WithChannel(c.session.ChannelID))
+	p.AddMeta(MetaMessageID, strconv.FormatInt(id, 10))
+	p.WriteBody(body)
+	tracing.Inject(c.ctx, p)
+	return Dispatch(c.dispatcher, p, recvs...)
+}
+
+// DispatchEphemeral DispatchEphemeral
+func (c *ContextImpl) DispatchEphemeral(body interface{}, recvs ...*dim.Location) error {
+	if len(recvs) == 0 {
+		return nil
+	}
+	p := pkt.New(c.request.Command, pkt.WithFlag(pkt.FlagPush), pkt.WithChannel(c.session.ChannelID))
+	p.AddMeta(MetaEphemeral, "1")
+	p.WriteBody(body)
+	tracing.Inject(c.ctx, p)
+	return Dispatch(c.dispatcher, p, recvs...)
+}
+
+// GetLocations GetLocations
+func (c *ContextImpl) GetLocations(accounts ...string) ([]*dim.Location, error) {
+	return c.store.GetLocations(accounts...)
+}
+
+// Next Next
+func (c *ContextImpl) Next() {
+	c.index++
+	for c.index < len(c.handlers) {
+		c.handlers[c.index](c)
+		c.index++
+	}
+}
+
+// Abort Abort
+func (c *ContextImpl) Abort() {
+	c.index = len(c.handlers)
+}
@@ -0,0 +1,237 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"dim"
+	"dim/logger"
+	"dim/pool"
+	"dim/tracing"
+	"dim/wire/pkt"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrNoService is responded if no logic service serves a command
+var ErrNoService = errors.New("no service of the command")
+
+// Gateway forwards the requests of the channels connected on it to the logic
+// services, and delivers the packets pushed by logic services to the local
+// channels. It wraps the ChannelMap of the server to keep the sessions of the
+// channels in the session store.
+type Gateway struct {
+	dim.ChannelMap
+	sync.RWMutex
+	id       string
+	store    dim.SessionStore
+	services map[string]*pool.Pool
+	offline  *Offline
+	listener SessionListener
+	// multiDevice is on if the channel ids are dim.ChannelID of the
+	// accounts and devices
+	multiDevice bool
+}
+
+// SessionListener is notified once a channel is added to or removed from a
+// gateway, it is called synchronously, so it should not block.
+type SessionListener interface {
+	Login(session *dim.Session)
+	Logout(session *dim.Session)
+}
+
+// NewGateway of the gateway service id
+func NewGateway(id string, channels dim.ChannelMap, store dim.SessionStore) *Gateway {
+	return &Gateway{
+		ChannelMap: channels,
+		id:         id,
+		store:      store,
+		services:   make(map[string]*pool.Pool),
+	}
+}
+
+// AddService routes the commands of serviceName to the pool, the pool should
+// be dialed with Deliver as the push handler.
+func (g *Gateway) AddService(serviceName string, p *pool.Pool) {
+	g.Lock()
+	defer g.Unlock()
+	g.services[serviceName] = p
+}
+
+// SetOffline stores the pushes to the channels not found, and delivers the
+// stored messages of a channel once it is added
+func (g *Gateway) SetOffline(offline *Offline) {
+	g.offline = offline
+}
+
+// SetMultiDevice parses the account and the device of the sessions from the
+// channel ids, it should be on if the handshake accepts MultiDevice.
+func (g *Gateway) SetMultiDevice(on bool) {
+	g.multiDevice = on
+}
+
+// SetSessionListener SetSessionListener
+func (g *Gateway) SetSessionListener(listener SessionListener) {
+	g.listener = listener
+}
+
+// Add the channel and its session, the account of the session is the channel
+// id, or the account and the device are parsed from it by dim.ParseChannelID
+// if multi device is on.
+func (g *Gateway) Add(channel dim.Channel) {
+	g.ChannelMap.Add(channel)
+	account, device := parseChannelID(channel.ID(), g.multiDevice)
+	session := &dim.Session{
+		ChannelID: channel.ID(),
+		GateID:    g.id,
+		Account:   account,
+		Device:    device,
+		LoginAt:   time.Now().Unix(),
+	}
+	if addr := channel.RemoteAddr(); addr != nil {
+		session.RemoteIP, _, _ = net.SplitHostPort(addr.String())
+	}
+	if err := g.store.Add(session); err != nil {
+		logger.WithFields(logger.Fields{
+			"module": "router.gateway",
+			"id":     g.id,
+		}).Warnf("add session of %s failed: %v", channel.ID(), err)
+	}
+	if g.listener != nil {
+		g.listener.Login(session)
+	}
+	if g.offline != nil {
+		g.offline.deliver(account, device, channel)
+	}
+}
+
+// Remove the channel and its session
+func (g *Gateway) Remove(id string) {
+	g.ChannelMap.Remove(id)
+	account, device := parseChannelID(id, g.multiDevice)
+	if err := g.store.Delete(account, id, g.id); err != nil {
+		logger.WithFields(logger.Fields{
+			"module": "router.gateway",
+			"id":     g.id,
+		}).Warnf("delete session of %s failed: %v", id, err)
+	}
+	if g.listener != nil {
+		g.listener.Logout(&dim.Session{
+			ChannelID: id,
+			GateID:    g.id,
+			Account:   account,
+			Device:    device,
+		})
+	}
+}
+
+// Receive forwards a request of ag to the service named by the prefix of the
+// command, e.g. chat.user.talk is served by chat.
+func (g *Gateway) Receive(ag dim.Agent, payload []byte) {
+	p, err := pkt.Unmarshal(payload)
+	if err != nil {
+		logger.WithFields(logger.Fields{
+			"module":  "router.gateway",
+			"id":      g.id,
+			"channel": ag.ID(),
+		}).Warn(err)
+		return
+	}
+	p.ChannelID = ag.ID()
+
+	// the trace is started by the client, or here if it is not traced
+	ctx, span := tracing.Start(context.Background(), p, trace.SpanKindServer)
+	defer span.End()
+	tracing.Inject(ctx, p)
+	log := logger.WithFields(logger.Fields{
+		"module":  "router.gateway",
+		"id":      g.id,
+		"channel": ag.ID(),
+	}).WithContext(ctx)
+
+	g.RLock()
+	pl, ok := g.services[serviceOf(p.Command)]
+	g.RUnlock()
+	if !ok {
+		tracing.SetStatus(span, pkt.NoDestination)
+		g.respWithError(ag, p, pkt.NoDestination, ErrNoService)
+		return
+	}
+	cli, err := pl.Pick()
+	if err != nil {
+		log.Warnf("pick %s failed: %v", p.Command, err)
+		tracing.SetStatus(span, pkt.SystemException)
+		g.respWithError(ag, p, pkt.SystemException, err)
+		return
+	}
+	if err = cli.Send(p); err != nil {
+		log.Warnf("forward %s failed: %v", p.Command, err)
+		tracing.SetStatus(span, pkt.SystemException)
+		g.respWithError(ag, p, pkt.SystemException, err)
+	}
+}
+
+// Deliver a packet of a logic service to the local channels, a response is
+// delivered to the channel of its request, and a push to the channels in its
+// MetaDestChannels.
+func (g *Gateway) Deliver(p *pkt.LogicPkt) {
+	ctx, span := tracing.Start(context.Background(), p, trace.SpanKindConsumer)
+	defer span.End()
+	log := logger.WithFields(logger.Fields{
+		"module": "router.gateway",
+		"id":     g.id,
+	}).WithContext(ctx)
+	channels := []string{p.ChannelID}
+	if p.Flag == pkt.FlagPush {
+		channels = DestChannels(p)
+		p.DelMeta(MetaDestChannels)
+	}
+	// the receivers continue the trace with the span of the delivery
+	tracing.Inject(ctx, p)
+	payload := pkt.Marshal(p)
+	var missing []string
+	for _, id := range channels {
+		ch, ok := g.Get(id)
+		if !ok {
+			log.Debugf("channel %s is not found", id)
+			account, _ := parseChannelID(id, g.multiDevice)
+			missing = append(missing, account)
+			continue
+		}
+		if err := ch.Push(payload); err != nil {
+			log.Debugf("push to %s failed: %v", id, err)
+		}
+	}
+	if g.offline != nil && p.Flag == pkt.FlagPush {
+		if err := g.offline.Save(p, missing...); err != nil {
+			log.Warnf("save %s failed: %v", p.Command, err)
+		}
+	}
+}
+
+func (g *Gateway) respWithError(ag dim.Agent, p *pkt.LogicPkt, status pkt.Status, err error) {
+	resp := pkt.NewFrom(&p.Header)
+	resp.Status = status
+	resp.WriteBody([]byte(err.Error()))
+	_ = ag.Push(pkt.Marshal(resp))
+}
+
+// parseChannelID returns the account and the device of a channel id, the
+// channel id is the account if not multiDevice
+func parseChannelID(id string, multiDevice bool) (account, device string) {
+	if !multiDevice {
+		return id, ""
+	}
+	return dim.ParseChannelID(id)
+}
+
+func serviceOf(command string) string {
+	if i := strings.Index(command, "."); i > 0 {
+		return command[:i]
+	}
+	return command
+}
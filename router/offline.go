@@ -0,0 +1,141 @@
+package router
+
+import (
+	"strconv"
+	"time"
+
+	"dim"
+	"dim/idgen"
+	"dim/logger"
+	"dim/wire/pkt"
+)
+
+// MetaMessageID is the meta key of the id of a stored message in the push
+// packet delivered on login
+const MetaMessageID = "msg.id"
+
+// MetaEphemeral is the meta key marking a push not stored for the accounts
+// not connected, e.g. a typing signal, it is only meaningful once pushed.
+const MetaEphemeral = "ephemeral"
+
+// Offline stores the pushes to accounts not connected, and delivers them
+// once the accounts login. The messages delivered are not removed until the
+// client acks them with the id in MetaMessageID, e.g. by chat.talk.ack of the
+// chat service sharing the store, so a message pushed to a connection lost
+// before receiving it is delivered again on next login. The acks are tracked
+// by the store for each device, so a device delivers since its own cursor.
+type Offline struct {
+	store    dim.MessageStore
+	gen      idgen.Generator
+	pageSize int
+	// multiDevice is on if the channel ids are dim.ChannelID of the
+	// accounts and devices
+	multiDevice bool
+}
+
+// NewOffline NewOffline
+func NewOffline(store dim.MessageStore, gen idgen.Generator) *Offline {
+	return &Offline{
+		store:    store,
+		gen:      gen,
+		pageSize: 100,
+	}
+}
+
+// SetMultiDevice parses the accounts of the undelivered pushes from the
+// channel ids, it should be on if the handshake accepts MultiDevice.
+func (o *Offline) SetMultiDevice(on bool) {
+	o.multiDevice = on
+}
+
+// Save the push p for the accounts, a push with MetaMessageID is stored by
+// its service already, so it is not saved again, and a push with
+// MetaEphemeral is dropped.
+func (o *Offline) Save(p *pkt.LogicPkt, accounts ...string) error {
+	if len(accounts) == 0 {
+		return nil
+	}
+	if _, ok := p.GetMeta(MetaMessageID); ok {
+		return nil
+	}
+	if _, ok := p.GetMeta(MetaEphemeral); ok {
+		return nil
+	}
+	id, err := o.gen.Next()
+	if err != nil {
+		return err
+	}
+	return o.store.Insert(&dim.Message{
+		ID:       id,
+		Command:  p.Command,
+		Sender:   p.ChannelID,
+		Body:     p.Body,
+		SendTime: time.Now().UnixMilli(),
+	}, accounts...)
+}
+
+// Deliver pushes the stored messages of account to ag on device since the
+// cursor of the device, they are kept until acked on every device delivered.
+func (o *Offline) Deliver(account, device string, ag dim.Agent) error {
+	since, err := o.store.Cursor(account, device)
+	if err != nil {
+		return err
+	}
+	// the device is tracked by the store once acked, so the messages are
+	// not removed by the acks of the other devices
+	if err = o.store.Ack(account, device, since); err != nil {
+		return err
+	}
+	for {
+		msgs, err := o.store.Fetch(account, since, o.pageSize)
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			p := pkt.New(msg.Command, pkt.WithFlag(pkt.FlagPush), pkt.WithChannel(msg.Sender))
+			p.AddMeta(MetaMessageID, strconv.FormatInt(msg.ID, 10))
+			p.Body = msg.Body
+			if err = ag.Push(pkt.Marshal(p)); err != nil {
+				return err
+			}
+			since = msg.ID
+		}
+		if len(msgs) < o.pageSize {
+			return nil
+		}
+	}
+}
+
+// Undelivered saves a push not acked by the client of channelID, so that an
+// Offline is the reliable.UndeliveredHandler of the pusher of a gateway.
+func (o *Offline) Undelivered(channelID string, seq uint64, payload []byte) {
+	log := logger.WithFields(logger.Fields{
+		"module":  "router.offline",
+		"channel": channelID,
+	})
+	p, err := pkt.Unmarshal(payload)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if p.Flag != pkt.FlagPush {
+		return
+	}
+	account, _ := parseChannelID(channelID, o.multiDevice)
+	if err = o.Save(p, account); err != nil {
+		log.Warnf("save push %d failed: %v", seq, err)
+	}
+}
+
+// deliver runs Deliver in background once ag is logged in
+func (o *Offline) deliver(account, device string, ag dim.Agent) {
+	go func() {
+		if err := o.Deliver(account, device, ag); err != nil {
+			logger.WithFields(logger.Fields{
+				"module":  "router.offline",
+				"account": account,
+				"device":  device,
+			}).Warnf("deliver offline messages failed: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,132 @@
+package router
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"dim"
+	"dim/logger"
+	"dim/tracing"
+	"dim/wire/pkt"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MetaDestChannels is the meta key of the channels a push packet forwarded
+// to a gateway is delivered to, the channel ids are joined by comma.
+const MetaDestChannels = "dest.channels"
+
+// HandlerFunc handles a request
+type HandlerFunc func(ctx Context)
+
+// HandlersChain HandlersChain
+type HandlersChain []HandlerFunc
+
+// Dispatcher pushes a packet to the channels connected on a gateway
+type Dispatcher interface {
+	Push(gateID string, channels []string, p *pkt.LogicPkt) error
+}
+
+// Router finds the handlers of a request by its command, like the router of
+// a http server.
+type Router struct {
+	sync.RWMutex
+	interceptors HandlersChain
+	handlers     map[string]HandlersChain
+}
+
+// NewRouter NewRouter
+func NewRouter() *Router {
+	return &Router{
+		handlers: make(map[string]HandlersChain),
+	}
+}
+
+// Handle registers the handlers of command, they are called in order
+func (r *Router) Handle(command string, handlers ...HandlerFunc) {
+	r.Lock()
+	defer r.Unlock()
+	r.handlers[command] = append(r.handlers[command], handlers...)
+}
+
+// Use adds interceptors called before the handlers of every command, an
+// interceptor rejects a request by responding and calling Abort, or rewrites
+// it by SetBody of the context.
+func (r *Router) Use(interceptors ...HandlerFunc) {
+	r.Lock()
+	defer r.Unlock()
+	r.interceptors = append(r.interceptors, interceptors...)
+}
+
+// Serve calls the handlers of the request p sent by session, the responses
+// are pushed to ag, which is the link of the gateway p is forwarded by. The
+// handlers are called in a span continuing the trace in the meta of p.
+func (r *Router) Serve(ag dim.Agent, p *pkt.LogicPkt, session *dim.Session, dispatcher Dispatcher, store dim.SessionStore) error {
+	ctx, span := tracing.Start(context.Background(), p, trace.SpanKindServer)
+	defer span.End()
+
+	r.RLock()
+	handlers, ok := r.handlers[p.Command]
+	chain := make(HandlersChain, 0, len(r.interceptors)+len(handlers))
+	chain = append(append(chain, r.interceptors...), handlers...)
+	r.RUnlock()
+
+	c := &ContextImpl{
+		ctx:        ctx,
+		ag:         ag,
+		request:    p,
+		session:    session,
+		dispatcher: dispatcher,
+		store:      store,
+		handlers:   chain,
+		index:      -1,
+	}
+	if !ok {
+		return c.RespWithError(pkt.InvalidCommand, ErrNoHandler)
+	}
+	c.Next()
+	return nil
+}
+
+// Dispatch pushes p to the locations, grouped by their gateways
+func Dispatch(dispatcher Dispatcher, p *pkt.LogicPkt, recvs ...*dim.Location) error {
+	group := make(map[string][]string)
+	for _, recv := range recvs {
+		if recv == nil {
+			continue
+		}
+		group[recv.GateID] = append(group[recv.GateID], recv.ChannelID)
+	}
+	var err error
+	for gateID, channels := range group {
+		if e := dispatcher.Push(gateID, channels, p); e != nil {
+			logger.WithFields(logger.Fields{
+				"module":  "router",
+				"gate_id": gateID,
+			}).Warnf("push %s failed: %v", p.Command, e)
+			err = e
+		}
+	}
+	return err
+}
+
+// forward returns a copy of p to push to channels
+func forward(p *pkt.LogicPkt, channels []string) *pkt.LogicPkt {
+	fwd := *p
+	fwd.Meta = make(map[string]string, len(p.Meta)+1)
+	for k, v := range p.Meta {
+		fwd.Meta[k] = v
+	}
+	fwd.Meta[MetaDestChannels] = strings.Join(channels, ",")
+	return &fwd
+}
+
+// DestChannels returns the channels a forwarded push packet is delivered to
+func DestChannels(p *pkt.LogicPkt) []string {
+	dest, ok := p.GetMeta(MetaDestChannels)
+	if !ok || dest == "" {
+		return nil
+	}
+	return strings.Split(dest, ",")
+}
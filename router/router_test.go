@@ -0,0 +1,412 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"dim"
+	"dim/auth"
+	"dim/bus"
+	"dim/idgen"
+	"dim/naming"
+	"dim/pool"
+	"dim/reliable"
+	"dim/storage"
+	"dim/tcp"
+	"dim/wire/pkt"
+)
+
+var signer = auth.NewTicketSigner([]byte("secret"))
+
+type talkReq struct {
+	Dest string `json:"dest"`
+	Text string `json:"text"`
+}
+
+func freePort(t *testing.T) int {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lst.Close()
+	return lst.Addr().(*net.TCPAddr).Port
+}
+
+// connect a channel to the gateway, the packets delivered to it are sent to the returned chan
+func connect(t *testing.T, gw *Gateway, id string) <-chan *pkt.LogicPkt {
+	local, remote := net.Pipe()
+	ch := dim.NewChannel(id, tcp.NewConn(local))
+	gw.Add(ch)
+	recv := make(chan *pkt.LogicPkt, 10)
+	go func() {
+		conn := tcp.NewConn(remote)
+		for {
+			frame, err := conn.ReadFrame()
+			if err != nil {
+				return
+			}
+			p, err := pkt.Unmarshal(frame.GetPayload())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			recv <- p
+		}
+	}()
+	t.Cleanup(func() {
+		gw.Remove(id)
+		ch.Close()
+	})
+	return recv
+}
+
+func newGateway(t *testing.T, id string, nm naming.Naming, store dim.SessionStore) *Gateway {
+	gw := NewGateway(id, dim.NewChannels(10), store)
+	dial := pool.NewDialFunc(func() dim.Client {
+		return tcp.NewClient(id, "gateway", tcp.ClientOptions{})
+	}, NewDialer(id, signer), gw.Deliver)
+	pl, err := pool.New("chat", nm, dial, pool.Options{Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pl.Close)
+	gw.AddService("chat", pl)
+	return gw
+}
+
+func receive(t *testing.T, recv <-chan *pkt.LogicPkt) *pkt.LogicPkt {
+	t.Helper()
+	select {
+	case p := <-recv:
+		return p
+	case <-time.After(time.Second * 2):
+		t.Fatal("receive timeout")
+	}
+	return nil
+}
+
+// waitLinks waits until n links of each gateway are accepted by service
+func waitLinks(t *testing.T, service *Service, n int, gateIDs ...string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		linked := 0
+		service.mutex.RLock()
+		for _, id := range gateIDs {
+			if len(service.links[id]) == n {
+				linked++
+			}
+		}
+		service.mutex.RUnlock()
+		if linked == len(gateIDs) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("links of %v are not accepted", gateIDs)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestGatewaySession(t *testing.T) {
+	store := storage.NewMemoryStore()
+	gw := NewGateway("gateway01", dim.NewChannels(10), store)
+	connect(t, gw, "u1#ios")
+	if session, _ := store.Get("u1#ios"); session == nil || session.Account != "u1#ios" || session.Device != "" {
+		t.Fatalf("unexpected session %+v", session)
+	}
+
+	gw.SetMultiDevice(true)
+	connect(t, gw, "u2#ios")
+	if session, _ := store.Get("u2#ios"); session == nil || session.Account != "u2" || session.Device != "ios" {
+		t.Fatalf("unexpected session %+v", session)
+	}
+}
+
+func TestCrossGatewayPush(t *testing.T) {
+	store := storage.NewMemoryStore()
+	port := freePort(t)
+	server := tcp.NewServer("127.0.0.1:"+strconv.Itoa(port), naming.NewEntry("chat01", "chat", "tcp", "127.0.0.1", port))
+	service := NewService(server, store, signer)
+	service.Handle("chat.user.talk", func(ctx Context) {
+		var req talkReq
+		if err := ctx.ReadBody(&req); err != nil {
+			_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+			return
+		}
+		locs, err := ctx.GetLocations(req.Dest)
+		if err != nil {
+			_ = ctx.RespWithError(pkt.NotFound, err)
+			return
+		}
+		_ = ctx.Dispatch(&req, locs...)
+		_ = ctx.Resp(pkt.Success, nil)
+	})
+	go func() { _ = server.Start() }()
+	defer server.Shutdown(context.Background())
+
+	nm := naming.NewMemoryNaming()
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port)); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	_ = nm.Register(naming.NewEntry("chat01", "chat", "tcp", "127.0.0.1", port))
+
+	gw1 := newGateway(t, "gateway01", nm, store)
+	gw2 := newGateway(t, "gateway02", nm, store)
+	u1 := connect(t, gw1, "u1")
+	u2 := connect(t, gw2, "u2")
+	// the links of the gateways are connected in background
+	waitLinks(t, service, 2, "gateway01", "gateway02")
+
+	req := pkt.New("chat.user.talk", pkt.WithSeq(7)).WriteBody(&talkReq{Dest: "u2", Text: "hello"})
+	gw1.Receive(&agent{id: "u1"}, pkt.Marshal(req))
+
+	push := receive(t, u2)
+	if push.Flag != pkt.FlagPush || push.ChannelID != "u1" {
+		t.Fatalf("unexpected push %s", push)
+	}
+	if _, ok := push.GetMeta(MetaDestChannels); ok {
+		t.Fatal("dest channels should be removed")
+	}
+	var body talkReq
+	if err := push.ReadBody(&body); err != nil || body.Text != "hello" {
+		t.Fatalf("unexpected body %+v %v", body, err)
+	}
+
+	resp := receive(t, u1)
+	if resp.Flag != pkt.FlagResponse || resp.Sequence != 7 || resp.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", resp)
+	}
+
+	// offline
+	req = pkt.New("chat.user.talk", pkt.WithSeq(8)).WriteBody(&talkReq{Dest: "u3"})
+	gw1.Receive(&agent{id: "u1"}, pkt.Marshal(req))
+	if resp = receive(t, u1); resp.Status != pkt.NotFound {
+		t.Fatalf("unexpected response %s", resp)
+	}
+
+	// unknown service
+	gw1.Receive(&agent{id: "u1", push: func(payload []byte) {
+		p, _ := pkt.Unmarshal(payload)
+		if p.Status != pkt.NoDestination {
+			t.Errorf("unexpected response %s", p)
+		}
+	}}, pkt.Marshal(pkt.New("group.create")))
+}
+
+func TestBusDispatcher(t *testing.T) {
+	store := storage.NewMemoryStore()
+	b := bus.NewMemoryBus()
+	gw1 := NewGateway("gateway01", dim.NewChannels(10), store)
+	gw2 := NewGateway("gateway02", dim.NewChannels(10), store)
+	for _, gw := range []*Gateway{gw1, gw2} {
+		if _, err := gw.Subscribe(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	u1 := connect(t, gw1, "u1")
+	u2 := connect(t, gw2, "u2")
+
+	locs, err := store.GetLocations("u1", "u2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pkt.New("group.talk", pkt.WithFlag(pkt.FlagPush)).WriteBody([]byte("hi"))
+	if err = Dispatch(NewBusDispatcher(b), p, locs...); err != nil {
+		t.Fatal(err)
+	}
+	for _, recv := range []<-chan *pkt.LogicPkt{u1, u2} {
+		if got := receive(t, recv); string(got.Body) != "hi" {
+			t.Fatalf("unexpected push %s", got)
+		}
+	}
+}
+
+func TestOffline(t *testing.T) {
+	store := storage.NewMemoryStore()
+	messages := storage.NewMemoryMessageStore()
+	gen, _ := idgen.NewSnowflake(1, idgen.Options{})
+	offline := NewOffline(messages, gen)
+
+	service := NewService(tcp.NewServer("127.0.0.1:0", naming.NewEntry("chat01", "chat", "tcp", "127.0.0.1", 0)), store, signer)
+	service.SetOffline(offline)
+	p := pkt.New("chat.user.talk", pkt.WithFlag(pkt.FlagPush), pkt.WithChannel("u1")).WriteBody([]byte("m1"))
+	if err := service.PushTo(p, "u2"); err != nil {
+		t.Fatal(err)
+	}
+
+	gw := NewGateway("gateway01", dim.NewChannels(10), store)
+	gw.SetOffline(offline)
+	// the channel of u2 is closed after the location is found
+	p = pkt.New("chat.user.talk", pkt.WithFlag(pkt.FlagPush), pkt.WithChannel("u1")).WriteBody([]byte("m2"))
+	gw.Deliver(forward(p, []string{"u2"}))
+	// stored by the service already
+	p = pkt.New("chat.user.talk", pkt.WithFlag(pkt.FlagPush), pkt.WithChannel("u1")).WriteBody([]byte("m3"))
+	p.AddMeta(MetaMessageID, "1")
+	gw.Deliver(forward(p, []string{"u2"}))
+
+	login := func() []int64 {
+		u2 := connect(t, gw, "u2")
+		var ids []int64
+		for _, body := range []string{"m1", "m2"} {
+			got := receive(t, u2)
+			if string(got.Body) != body || got.ChannelID != "u1" {
+				t.Fatalf("unexpected push %s", got)
+			}
+			v, _ := got.GetMeta(MetaMessageID)
+			id, _ := strconv.ParseInt(v, 10, 64)
+			if len(ids) > 0 && id <= ids[len(ids)-1] {
+				t.Fatalf("unexpected message id %s", v)
+			}
+			ids = append(ids, id)
+		}
+		gw.Remove("u2")
+		return ids
+	}
+	ids := login()
+	// not acked by the client, delivered again
+	if again := login(); again[0] != ids[0] || again[1] != ids[1] {
+		t.Fatalf("unexpected ids %v, expect %v", again, ids)
+	}
+	_ = messages.Ack("u2", "", ids[1])
+	if msgs, _ := messages.Fetch("u2", 0, 10); len(msgs) != 0 {
+		t.Fatalf("unexpected messages %v", msgs)
+	}
+}
+
+func TestOfflineEphemeral(t *testing.T) {
+	store := storage.NewMemoryStore()
+	messages := storage.NewMemoryMessageStore()
+	gen, _ := idgen.NewSnowflake(1, idgen.Options{})
+	offline := NewOffline(messages, gen)
+	gw := NewGateway("gateway01", dim.NewChannels(10), store)
+	gw.SetOffline(offline)
+
+	// u2 is not connected
+	p := pkt.New("chat.typing", pkt.WithFlag(pkt.FlagPush), pkt.WithChannel("u1")).WriteBody([]byte("typing"))
+	p.AddMeta(MetaEphemeral, "1")
+	gw.Deliver(forward(p, []string{"u2"}))
+	offline.Undelivered("u2", 1, pkt.Marshal(p))
+
+	if msgs, _ := messages.Fetch("u2", 0, 10); len(msgs) != 0 {
+		t.Fatalf("unexpected messages %v", msgs)
+	}
+}
+
+func TestOfflineDevices(t *testing.T) {
+	messages := storage.NewMemoryMessageStore()
+	gen, _ := idgen.NewSnowflake(1, idgen.Options{})
+	gw := NewGateway("gateway01", dim.NewChannels(10), storage.NewMemoryStore())
+	gw.SetMultiDevice(true)
+	gw.SetOffline(NewOffline(messages, gen))
+
+	p := pkt.New("chat.user.talk", pkt.WithFlag(pkt.FlagPush), pkt.WithChannel("u1")).WriteBody([]byte("m1"))
+	gw.Deliver(forward(p, []string{"u2#ios"}))
+	var id int64
+	for _, channel := range []string{"u2#ios", "u2#web"} {
+		got := receive(t, connect(t, gw, channel))
+		v, _ := got.GetMeta(MetaMessageID)
+		id, _ = strconv.ParseInt(v, 10, 64)
+		gw.Remove(channel)
+	}
+
+	// acked on ios, still delivered to web
+	_ = messages.Ack("u2", "ios", id)
+	if got := receive(t, connect(t, gw, "u2#web")); string(got.Body) != "m1" {
+		t.Fatalf("unexpected push %s", got)
+	}
+	gw.Remove("u2#web")
+	_ = messages.Ack("u2", "web", id)
+	if msgs, _ := messages.Fetch("u2", 0, 10); len(msgs) != 0 {
+		t.Fatalf("unexpected messages %v", msgs)
+	}
+}
+
+// offlineServer is a server whose channels are all disconnected
+type offlineServer struct {
+	dim.Server
+}
+
+func (offlineServer) Push(string, []byte) error {
+	return errors.New("channel no found")
+}
+
+func TestOfflineUndelivered(t *testing.T) {
+	messages := storage.NewMemoryMessageStore()
+	gen, _ := idgen.NewSnowflake(1, idgen.Options{})
+	offline := NewOffline(messages, gen)
+	offline.SetMultiDevice(true)
+	pusher := reliable.NewPusher(offlineServer{}, offline, reliable.Options{})
+
+	p := pkt.New("chat.user.talk", pkt.WithFlag(pkt.FlagPush), pkt.WithChannel("u1")).WriteBody([]byte("hi"))
+	if _, err := pusher.Push("u2#ios", pkt.Marshal(p)); err == nil {
+		t.Fatal("expect an error")
+	}
+	resp := pkt.New("chat.user.talk", pkt.WithChannel("u2#ios"))
+	_, _ = pusher.Push("u2#ios", pkt.Marshal(pkt.NewFrom(&resp.Header)))
+
+	msgs, _ := messages.Fetch("u2", 0, 10)
+	if len(msgs) != 1 || string(msgs[0].Body) != "hi" || msgs[0].Sender != "u1" {
+		t.Fatalf("unexpected messages %v", msgs)
+	}
+}
+
+type agent struct {
+	id   string
+	push func([]byte)
+}
+
+func (a *agent) ID() string { return a.id }
+
+func (a *agent) Push(payload []byte) error {
+	if a.push != nil {
+		a.push(payload)
+	}
+	return nil
+}
+
+func TestServiceAccept(t *testing.T) {
+	service := NewService(tcp.NewServer("127.0.0.1:0", naming.NewEntry("chat01", "chat", "tcp", "127.0.0.1", 0)), storage.NewMemoryStore(), signer)
+
+	accept := func(ticket string) (string, error) {
+		cli, srv := net.Pipe()
+		defer cli.Close()
+		defer srv.Close()
+		go func() {
+			_ = tcp.WriteFrame(cli, dim.OpBinary, []byte(ticket))
+		}()
+		return service.Accept(tcp.NewConn(srv), time.Second)
+	}
+
+	id1, err := accept(signer.Sign("gateway01", time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, _ := accept(signer.Sign("gateway01", time.Minute))
+	if id1 == id2 || gateOf(id1) != "gateway01" || gateOf(id2) != "gateway01" {
+		t.Fatalf("unexpected link ids %s %s", id1, id2)
+	}
+	if len(service.links["gateway01"]) != 2 {
+		t.Fatalf("unexpected links %v", service.links)
+	}
+	_ = service.Disconnect(id1)
+	if links := service.links["gateway01"]; len(links) != 1 || links[0] != id2 {
+		t.Fatalf("unexpected links %v", links)
+	}
+
+	if _, err = accept("gateway01"); !errors.Is(err, dim.ErrUnauthorized) {
+		t.Fatalf("expect ErrUnauthorized, got %v", err)
+	}
+	forged := auth.NewTicketSigner([]byte("forged")).Sign("gateway01", time.Minute)
+	if _, err = accept(forged); !errors.Is(err, dim.ErrUnauthorized) {
+		t.Fatalf("expect ErrUnauthorized, got %v", err)
+	}
+}
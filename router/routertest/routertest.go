@@ -0,0 +1,88 @@
+// Package routertest provides the fakes of a gateway and a dispatcher to test
+// the handlers of a router.Router.
+package routertest
+
+import (
+	"sync"
+
+	"dim/wire/pkt"
+)
+
+// Gateway is the dim.Agent of a gateway link passed to Router.Serve, it
+// records the responses pushed to it.
+type Gateway struct {
+	sync.Mutex
+	id    string
+	Resps []*pkt.LogicPkt
+}
+
+// NewGateway NewGateway
+func NewGateway(id string) *Gateway {
+	return &Gateway{id: id}
+}
+
+// ID ID
+func (g *Gateway) ID() string {
+	return g.id
+}
+
+// Push Push
+func (g *Gateway) Push(payload []byte) error {
+	p, err := pkt.Unmarshal(payload)
+	if err != nil {
+		return err
+	}
+	g.Lock()
+	defer g.Unlock()
+	g.Resps = append(g.Resps, p)
+	return nil
+}
+
+// Last returns the last response, nil if nothing is responded
+func (g *Gateway) Last() *pkt.LogicPkt {
+	g.Lock()
+	defer g.Unlock()
+	if len(g.Resps) == 0 {
+		return nil
+	}
+	return g.Resps[len(g.Resps)-1]
+}
+
+// Take returns the responses and clears them
+func (g *Gateway) Take() []*pkt.LogicPkt {
+	g.Lock()
+	defer g.Unlock()
+	resps := g.Resps
+	g.Resps = nil
+	return resps
+}
+
+// Push is a packet pushed by a Dispatcher
+type Push struct {
+	GateID   string
+	Channels []string
+	Packet   *pkt.LogicPkt
+}
+
+// Dispatcher is a router.Dispatcher recording the packets pushed
+type Dispatcher struct {
+	sync.Mutex
+	Pushes []Push
+}
+
+// Push Push
+func (d *Dispatcher) Push(gateID string, channels []string, p *pkt.LogicPkt) error {
+	d.Lock()
+	defer d.Unlock()
+	d.Pushes = append(d.Pushes, Push{GateID: gateID, Channels: channels, Packet: p})
+	return nil
+}
+
+// Take returns the pushes and clears them
+func (d *Dispatcher) Take() []Push {
+	d.Lock()
+	defer d.Unlock()
+	pushes := d.Pushes
+	d.Pushes = nil
+	return pushes
+}
@@ -0,0 +1,230 @@
+package router
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dim"
+	"dim/auth"
+	"dim/logger"
+	"dim/tcp"
+	"dim/wire/pkt"
+
+	"github.com/segmentio/ksuid"
+)
+
+// ErrNoLink is returned by Push if the gateway has no link to the service
+var ErrNoLink = errors.New("no link of the gateway")
+
+// TicketTTL is the ttl of the ticket a Dialer authenticates a gateway with
+const TicketTTL = time.Minute
+
+// linkSeparator separates the gateway id and the nonce in a link id
+const linkSeparator = "/"
+
+// Service serves the requests forwarded by gateways on a logic server. The
+// channels of the server are the links of the gateways, a gateway may have
+// several links, e.g. dialed by a pool, so a link is identified by the
+// service id of the gateway and a nonce. A push to an account is forwarded
+// to the gateway found in the session store over one of its links.
+type Service struct {
+	*Router
+	server     dim.Server
+	store      dim.SessionStore
+	verifier   auth.Verifier
+	dispatcher Dispatcher
+	offline    *Offline
+	mutex      sync.RWMutex
+	links      map[string][]string
+	next       uint32
+}
+
+// NewService sets the service as the acceptor and the listeners of server,
+// a gateway is authenticated by verifier with the ticket sent by the Dialer,
+// e.g. a TicketSigner of a secret shared by the gateways and the services.
+func NewService(server dim.Server, store dim.SessionStore, verifier auth.Verifier) *Service {
+	s := &Service{
+		Router:   NewRouter(),
+		server:   server,
+		store:    store,
+		verifier: verifier,
+		links:    make(map[string][]string),
+	}
+	s.dispatcher = s
+	server.SetAcceptor(s)
+	server.SetMessageListener(s)
+	server.SetStateListener(s)
+	return s
+}
+
+// SetDispatcher pushes the packets by dispatcher instead of the links of
+// the gateways, e.g. a BusDispatcher.
+func (s *Service) SetDispatcher(dispatcher Dispatcher) {
+	s.dispatcher = dispatcher
+}
+
+// Dispatcher returns the dispatcher pushing the packets of the service
+func (s *Service) Dispatcher() Dispatcher {
+	return s.dispatcher
+}
+
+// SetOffline stores the pushes of PushTo to the accounts not connected
+func (s *Service) SetOffline(offline *Offline) {
+	s.offline = offline
+}
+
+// Accept verifies the ticket of a gateway sent by the Dialer, and returns a
+// link id of the gateway
+func (s *Service) Accept(conn dim.Conn, timeout time.Duration) (string, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	frame, err := conn.ReadFrame()
+	if err != nil {
+		return "", err
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	gateID, err := s.verifier.Verify(string(frame.GetPayload()))
+	if err != nil {
+		return "", err
+	}
+	if gateID == "" {
+		return "", errors.New("gateway id is empty")
+	}
+	id := gateID + linkSeparator + ksuid.New().String()
+	s.mutex.Lock()
+	s.links[gateID] = append(s.links[gateID], id)
+	s.mutex.Unlock()
+	return id, nil
+}
+
+// Receive a request forwarded by the gateway ag
+func (s *Service) Receive(ag dim.Agent, payload []byte) {
+	log := logger.WithFields(logger.Fields{
+		"module":  "router.service",
+		"gate_id": gateOf(ag.ID()),
+	})
+	p, err := pkt.Unmarshal(payload)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	session, err := s.store.Get(p.ChannelID)
+	if err != nil {
+		log.Warnf("session of %s: %v", p.ChannelID, err)
+		resp := pkt.NewFrom(&p.Header)
+		resp.Status = pkt.Unauthorized
+		resp.WriteBody([]byte(err.Error()))
+		_ = ag.Push(pkt.Marshal(resp))
+		return
+	}
+	if err = s.Serve(ag, p, session, s.dispatcher, s.store); err != nil {
+		log.Warn(err)
+	}
+}
+
+// Disconnect a link of a gateway
+func (s *Service) Disconnect(id string) error {
+	gateID := gateOf(id)
+	s.mutex.Lock()
+	links := s.links[gateID]
+	for i, link := range links {
+		if link == id {
+			links = append(links[:i:i], links[i+1:]...)
+			break
+		}
+	}
+	if len(links) == 0 {
+		delete(s.links, gateID)
+	} else {
+		s.links[gateID] = links
+	}
+	s.mutex.Unlock()
+	logger.WithFields(logger.Fields{
+		"module": "router.service",
+	}).Infof("link %s of gateway %s is disconnected", id, gateID)
+	return nil
+}
+
+// Push p to the channels connected on the gateway, the links of the gateway
+// are taken in turn, and the next one is tried if the push fails.
+func (s *Service) Push(gateID string, channels []string, p *pkt.LogicPkt) error {
+	s.mutex.RLock()
+	links := s.links[gateID]
+	s.mutex.RUnlock()
+	if len(links) == 0 {
+		return ErrNoLink
+	}
+	payload := pkt.Marshal(forward(p, channels))
+	start := int(atomic.AddUint32(&s.next, 1))
+	var err error
+	for i := range links {
+		if err = s.server.Push(links[(start+i)%len(links)], payload); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// gateOf returns the gateway id of a link id
+func gateOf(id string) string {
+	if i := strings.LastIndex(id, linkSeparator); i >= 0 {
+		return id[:i]
+	}
+	return id
+}
+
+// PushTo pushes p to every device of the accounts, wherever they are
+// connected. The push to the accounts not connected is stored if the
+// offline is set, it fails with dim.ErrSessionNil otherwise.
+func (s *Service) PushTo(p *pkt.LogicPkt, accounts ...string) error {
+	locs, err := s.store.GetLocations(accounts...)
+	if err != nil && err != dim.ErrSessionNil {
+		return err
+	}
+	if s.offline != nil {
+		online := make(map[string]bool, len(locs))
+		for _, loc := range locs {
+			online[loc.Account] = true
+		}
+		var offline []string
+		for _, account := range accounts {
+			if !online[account] {
+				offline = append(offline, account)
+			}
+		}
+		if err = s.offline.Save(p, offline...); err != nil {
+			return err
+		}
+	} else if len(locs) == 0 {
+		return dim.ErrSessionNil
+	}
+	return Dispatch(s.dispatcher, p, locs...)
+}
+
+// Dialer connects a gateway to the tcp server of a logic service
+type Dialer struct {
+	gateID string
+	signer *auth.TicketSigner
+}
+
+// NewDialer NewDialer
+func NewDialer(gateID string, signer *auth.TicketSigner) *Dialer {
+	return &Dialer{gateID: gateID, signer: signer}
+}
+
+// DialAndHandshake sends a ticket of the service id of the gateway
+func (d *Dialer) DialAndHandshake(ctx dim.DialerContext) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", ctx.Address, ctx.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	ticket := d.signer.Sign(d.gateID, TicketTTL)
+	if err = tcp.WriteFrame(conn, dim.OpBinary, []byte(ticket)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
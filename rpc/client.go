@@ -0,0 +1,204 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"dim"
+	"dim/logger"
+	"dim/tracing"
+	"dim/wire/pkt"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrClosed is returned by Call once the read loop is stopped
+var ErrClosed = errors.New("rpc client is closed")
+
+// Error is returned by Call with the response whose status is not Success
+type Error struct {
+	Command string
+	Status  pkt.Status
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s responded with status %d", e.Command, e.Status)
+}
+
+// PushHandler handles packets not responding to a Call, it is called by the
+// read loop, so it should not block.
+type PushHandler func(p *pkt.LogicPkt)
+
+// Client is a request/response client on top of a connected dim.Client, the
+// response of a request is matched by the sequence in the packet header.
+type Client struct {
+	sync.Mutex
+	cli     dim.Client
+	seq     uint32
+	pending map[uint32]chan *pkt.LogicPkt
+	handler PushHandler
+	done    *dim.Event
+	err     error
+}
+
+// NewClient starts the read loop on cli, handler may be nil
+func NewClient(cli dim.Client, handler PushHandler) *Client {
+	c := &Client{
+		cli:     cli,
+		pending: make(map[uint32]chan *pkt.LogicPkt),
+		handler: handler,
+		done:    dim.NewEvent(),
+	}
+	go c.readloop()
+	return c
+}
+
+// ID ID
+func (c *Client) ID() string {
+	return c.cli.ID()
+}
+
+// Call sends a request of command and waits for its response until ctx is
+// done, the request is sent in a span of the trace of ctx.
+func (c *Client) Call(ctx context.Context, command string, body interface{}) (resp *pkt.LogicPkt, err error) {
+	seq := c.nextSeq()
+	req := pkt.New(command, pkt.WithSeq(seq)).WriteBody(body)
+
+	ctx, span := tracing.Tracer().Start(ctx, command,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			tracing.AttrCommand.String(command),
+			tracing.AttrSequence.Int64(int64(seq)),
+		))
+	defer func() {
+		if resp != nil {
+			tracing.SetStatus(span, resp.Status)
+		} else if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	tracing.Inject(ctx, req)
+
+	ch := make(chan *pkt.LogicPkt, 1)
+	c.Lock()
+	if c.done.HasFired() {
+		c.Unlock()
+		return nil, c.Err()
+	}
+	c.pending[seq] = ch
+	c.Unlock()
+	defer func() {
+		c.Lock()
+		delete(c.pending, seq)
+		c.Unlock()
+	}()
+
+	if err := c.Send(req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Status != pkt.Success {
+			return resp, &Error{Command: command, Status: resp.Status}
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done.Done():
+		return nil, c.Err()
+	}
+}
+
+// Send a packet without waiting for a response
+func (c *Client) Send(p *pkt.LogicPkt) error {
+	return c.cli.Send(pkt.Marshal(p))
+}
+
+// Pending returns the number of calls waiting for responses
+func (c *Client) Pending() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.pending)
+}
+
+// Done returns a channel closed once the read loop is stopped
+func (c *Client) Done() <-chan struct{} {
+	return c.done.Done()
+}
+
+// Err returns the error the read loop is stopped with
+func (c *Client) Err() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.err == nil {
+		return ErrClosed
+	}
+	return c.err
+}
+
+// Close the underlying client
+func (c *Client) Close() {
+	c.cli.Close()
+}
+
+func (c *Client) nextSeq() uint32 {
+	for {
+		if seq := atomic.AddUint32(&c.seq, 1); seq != 0 {
+			return seq
+		}
+	}
+}
+
+func (c *Client) readloop() {
+	log := logger.WithFields(logger.Fields{
+		"module": "rpc.client",
+		"id":     c.cli.ID(),
+	})
+	for {
+		frame, err := c.cli.Read()
+		if err != nil {
+			log.Info(err)
+			c.Lock()
+			c.err = err
+			c.Unlock()
+			c.done.Fire()
+			return
+		}
+		if frame.GetOpCode() != dim.OpBinary {
+			continue
+		}
+		p, err := pkt.Unmarshal(frame.GetPayload())
+		if err != nil {
+			log.Warn(err)
+			continue
+		}
+		// the requests of Call carry no channel, the responses of the packets
+		// forwarded by Send are handled by the handler
+		if p.Flag == pkt.FlagResponse && p.ChannelID == "" {
+			c.Lock()
+			ch, ok := c.pending[p.Sequence]
+			c.Unlock()
+			if !ok {
+				// the call is timed out or canceled
+				log.Debugf("drop late response %s", p)
+				continue
+			}
+			select {
+			case ch <- p:
+			default:
+			}
+			continue
+		}
+		if c.handler != nil {
+			c.handler(p)
+		} else {
+			log.Debugf("no handler for %s", p)
+		}
+	}
+}
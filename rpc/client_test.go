@@ -0,0 +1,175 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"dim"
+	"dim/tcp"
+	"dim/wire/pkt"
+)
+
+type dialer struct{}
+
+func (d *dialer) DialAndHandshake(ctx dim.DialerContext) (net.Conn, error) {
+	return net.DialTimeout("tcp", ctx.Address, ctx.Timeout)
+}
+
+// serve responds requests in reverse order of each pair, a push is sent
+// before every response, and the request of command "slow" is never responded.
+func serve(t *testing.T) string {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lst.Close() })
+	go func() {
+		rawconn, err := lst.Accept()
+		if err != nil {
+			return
+		}
+		defer rawconn.Close()
+		conn := tcp.NewConn(rawconn)
+		var held *pkt.LogicPkt
+		for {
+			frame, err := conn.ReadFrame()
+			if err != nil {
+				return
+			}
+			req, err := pkt.Unmarshal(frame.GetPayload())
+			if err != nil || req.Command == "slow" {
+				continue
+			}
+			if held == nil {
+				held = req
+				continue
+			}
+			for _, r := range []*pkt.LogicPkt{req, held} {
+				push := pkt.New("push", pkt.WithFlag(pkt.FlagPush))
+				_ = conn.WriteFrame(dim.OpBinary, pkt.Marshal(push))
+				resp := pkt.NewFrom(&r.Header).WriteBody(r.Body)
+				_ = conn.WriteFrame(dim.OpBinary, pkt.Marshal(resp))
+			}
+			held = nil
+		}
+	}()
+	return lst.Addr().String()
+}
+
+func TestCall(t *testing.T) {
+	cli := tcp.NewClient("c1", "client", tcp.ClientOptions{})
+	cli.SetDialer(&dialer{})
+	if err := cli.Connect(serve(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	pushes := make(chan *pkt.LogicPkt, 10)
+	c := NewClient(cli, func(p *pkt.LogicPkt) {
+		pushes <- p
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if _, err := c.Call(ctx, "slow", nil); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded, got %v", err)
+	}
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 2)
+	for _, body := range []string{"a", "b"} {
+		go func(body string) {
+			resp, err := c.Call(context.Background(), "echo", []byte(body))
+			if err != nil {
+				results <- result{err: err}
+				return
+			}
+			results <- result{body: string(resp.Body)}
+			if string(resp.Body) != body {
+				t.Errorf("expect %s, got %s", body, resp.Body)
+			}
+		}(body)
+	}
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+	}
+	if len(pushes) != 2 {
+		t.Fatalf("expect 2 pushes, got %d", len(pushes))
+	}
+	if c.Pending() != 0 {
+		t.Fatalf("expect no pending call, got %d", c.Pending())
+	}
+
+	c.Close()
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("read loop is not stopped")
+	}
+	if _, err := c.Call(context.Background(), "echo", nil); err == nil {
+		t.Fatal("expect an error")
+	}
+}
+
+func TestLateResponse(t *testing.T) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lst.Close() })
+	release := make(chan struct{})
+	go func() {
+		rawconn, err := lst.Accept()
+		if err != nil {
+			return
+		}
+		defer rawconn.Close()
+		conn := tcp.NewConn(rawconn)
+		frame, err := conn.ReadFrame()
+		if err != nil {
+			return
+		}
+		req, _ := pkt.Unmarshal(frame.GetPayload())
+		<-release
+		_ = conn.WriteFrame(dim.OpBinary, pkt.Marshal(pkt.NewFrom(&req.Header)))
+		// a response of a packet forwarded by Send with the same sequence
+		forwarded := pkt.NewFrom(&pkt.Header{Command: "forward", ChannelID: "u1", Sequence: req.Sequence})
+		_ = conn.WriteFrame(dim.OpBinary, pkt.Marshal(forwarded))
+		_, _ = conn.ReadFrame()
+	}()
+
+	cli := tcp.NewClient("c1", "client", tcp.ClientOptions{})
+	cli.SetDialer(&dialer{})
+	if err := cli.Connect(lst.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	pushes := make(chan *pkt.LogicPkt, 10)
+	c := NewClient(cli, func(p *pkt.LogicPkt) {
+		pushes <- p
+	})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if _, err := c.Call(ctx, "slow", nil); err != context.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded, got %v", err)
+	}
+	close(release)
+
+	select {
+	case p := <-pushes:
+		if p.Command != "forward" || p.ChannelID != "u1" {
+			t.Fatalf("late response is handled %s", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("forwarded response is not received")
+	}
+}
@@ -2,6 +2,7 @@ package dim
 
 import (
 	"context"
+	"errors"
 	"net"
 	"time"
 )
@@ -25,6 +26,24 @@ const (
 	OpPong         OpCode = 0xa
 )
 
+func (c OpCode) String() string {
+	switch c {
+	case OpContinuation:
+		return "continuation"
+	case OpText:
+		return "text"
+	case OpBinary:
+		return "binary"
+	case OpClose:
+		return "close"
+	case OpPing:
+		return "ping"
+	case OpPong:
+		return "pong"
+	}
+	return "unknown"
+}
+
 // server define a tcp/websocket interface
 type Server interface {
 	SetAcceptor(Acceptor)
@@ -37,6 +56,10 @@ type Server interface {
 	Shutdown(context.Context) error
 }
 
+// ErrUnauthorized is matched by errors.Is on the errors of an Acceptor
+// rejecting a client for its credentials
+var ErrUnauthorized = errors.New("err:unauthorized")
+
 // Acceptor
 type Acceptor interface {
 	Accept(Conn, time.Duration) (string, error)
@@ -75,6 +98,22 @@ type Channel interface {
 	SetReadWait(time.Duration)
 }
 
+// StatsChannel is a Channel reporting its state
+type StatsChannel interface {
+	Stats() ChannelStats
+}
+
+// ChannelStats is the state of a channel
+type ChannelStats struct {
+	ID          string    `json:"id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+	BytesIn     int64     `json:"bytes_in"`
+	BytesOut    int64     `json:"bytes_out"`
+	// QueueDepth is the number of the payloads pushed but not written
+	QueueDepth int `json:"queue_depth"`
+}
+
 // Client interface
 type Client interface {
 	ID() string
@@ -86,6 +125,14 @@ type Client interface {
 	Close()
 }
 
+// ClientListener receives the payloads read by a Client in the background.
+// A Client always reads frames in the background once connected, so that the
+// heartbeat is handled even if nobody is reading, the payloads are delivered
+// to the ClientListener if set, or buffered for Read otherwise.
+type ClientListener interface {
+	Receive(Client, []byte)
+}
+
 // Diaer Diaer
 type Dialer interface {
 	DialAndHandshake(DialerContext) (net.Conn, error)
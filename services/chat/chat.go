@@ -0,0 +1,276 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"dim"
+	"dim/idgen"
+	"dim/router"
+	"dim/wire/pkt"
+)
+
+// commands
+const (
+	CommandUserTalk    = "chat.user.talk"
+	CommandTalkAck     = "chat.talk.ack"
+	CommandOfflinePull = "chat.offline.pull"
+)
+
+// DefaultPullLimit is the default number of messages of an offline pull
+const DefaultPullLimit = 50
+
+// errors
+var (
+	ErrNoDest   = errors.New("dest is required")
+	ErrSelfDest = errors.New("dest is the sender")
+)
+
+// TalkReq is the request of chat.user.talk
+type TalkReq struct {
+	Dest  string `json:"dest"`
+	Type  int32  `json:"type"`
+	Body  string `json:"body"`
+	Extra string `json:"extra,omitempty"`
+}
+
+// TalkResp is the response of chat.user.talk
+type TalkResp struct {
+	MessageID int64 `json:"message_id"`
+	SendTime  int64 `json:"send_time"`
+}
+
+// MessagePush is pushed to the receivers of a message
+type MessagePush struct {
+	MessageID int64  `json:"message_id"`
+	Sender    string `json:"sender"`
+	Group     string `json:"group,omitempty"`
+	Type      int32  `json:"type"`
+	Body      string `json:"body"`
+	Extra     string `json:"extra,omitempty"`
+	SendTime  int64  `json:"send_time"`
+	// Command is the command of a pulled message, it is one of talk, recall
+	// and edit
+	Command string `json:"command,omitempty"`
+	// Target is the message recalled or edited by a recall or edit event
+	Target   int64 `json:"target,omitempty"`
+	Recalled bool  `json:"recalled,omitempty"`
+	EditTime int64 `json:"edit_time,omitempty"`
+}
+
+// AckReq acks the messages received with id not greater than MessageID
+type AckReq struct {
+	MessageID int64 `json:"message_id"`
+}
+
+// PullReq pulls the messages with id greater than Since
+type PullReq struct {
+	Since int64 `json:"since"`
+	Limit int   `json:"limit"`
+}
+
+// PullResp is the response of chat.offline.pull
+type PullResp struct {
+	Messages []*MessagePush `json:"messages"`
+	HasMore  bool           `json:"has_more"`
+}
+
+// Handler is the one-to-one chat service. A message is stored in the inbox
+// of the receiver until it is acked, so a receiver not connected pulls it
+// once logged in. A copy is stored in the inbox of the sender too, so the
+// other devices of the sender are synced.
+type Handler struct {
+	store         dim.MessageStore
+	gen           idgen.Generator
+	history       HistoryStore
+	conversations dim.ConversationStore
+}
+
+// NewHandler NewHandler
+func NewHandler(store dim.MessageStore, gen idgen.Generator) *Handler {
+	return &Handler{
+		store: store,
+		gen:   gen,
+	}
+}
+
+// SetHistory records the messages sent in history, so they can be recalled
+// or edited
+func (h *Handler) SetHistory(history HistoryStore) {
+	h.history = history
+}
+
+// SetConversations maintains the conversations of the sender and the
+// receiver of the messages sent
+func (h *Handler) SetConversations(conversations dim.ConversationStore) {
+	h.conversations = conversations
+}
+
+// Register the handlers of the commands to r
+func (h *Handler) Register(r *router.Router) {
+	r.Handle(CommandUserTalk, h.DoUserTalk)
+	r.Handle(CommandTalkAck, h.DoTalkAck)
+	r.Handle(CommandOfflinePull, h.DoOfflinePull)
+}
+
+// DoUserTalk DoUserTalk
+func (h *Handler) DoUserTalk(ctx router.Context) {
+	var req TalkReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	if req.Dest == "" {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, ErrNoDest)
+		return
+	}
+	sender := ctx.Session().Account
+	if req.Dest == sender {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, ErrSelfDest)
+		return
+	}
+	id, err := h.gen.Next()
+	if err != nil {
+		_ = ctx.RespWithError(pkt.SystemException, err)
+		return
+	}
+	push := &MessagePush{
+		MessageID: id,
+		Sender:    sender,
+		Type:      req.Type,
+		Body:      req.Body,
+		Extra:     req.Extra,
+		SendTime:  time.Now().UnixMilli(),
+	}
+	if err = h.store.Insert(toMessage(CommandUserTalk, push), req.Dest, sender); err != nil {
+		_ = ctx.RespWithError(pkt.SystemException, err)
+		return
+	}
+	if h.history != nil {
+		err = h.history.Add(&Record{
+			MessageID: id,
+			Sender:    push.Sender,
+			Dest:      req.Dest,
+			Type:      req.Type,
+			SendTime:  push.SendTime,
+		})
+		if err != nil {
+			ctx.Logger().WithField("module", "chat").Warnf("add history of %d failed: %v", id, err)
+		}
+	}
+	if h.conversations != nil {
+		indexConversation(ctx, h.conversations, push, req.Dest, false, req.Dest)
+	}
+
+	// the receiver pulls the message once logged in if it is not connected
+	locs, err := ctx.GetLocations(req.Dest, sender)
+	if err == nil {
+		err = ctx.DispatchMessage(id, push, exclude(locs, ctx.Session().ChannelID)...)
+	}
+	if err != nil {
+		ctx.Logger().WithField("module", "chat").Warnf("dispatch %d failed: %v", id, err)
+	}
+	_ = ctx.Resp(pkt.Success, &TalkResp{
+		MessageID: id,
+		SendTime:  push.SendTime,
+	})
+}
+
+// DoTalkAck acks the messages received on the device of the sender, they are
+// kept for the other devices until acked on them
+func (h *Handler) DoTalkAck(ctx router.Context) {
+	var req AckReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	session := ctx.Session()
+	if err := h.store.Ack(session.Account, session.Device, req.MessageID); err != nil {
+		_ = ctx.RespWithError(pkt.SystemException, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Success, nil)
+}
+
+// DoOfflinePull DoOfflinePull
+func (h *Handler) DoOfflinePull(ctx router.Context) {
+	var req PullReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	pull(ctx, h.store, ctx.Session().Account, &req, 0)
+}
+
+// pull responds the messages of the inbox sent not before the unix
+// milliseconds from
+func pull(ctx router.Context, store dim.MessageStore, inbox string, req *PullReq, from int64) {
+	if req.Limit <= 0 || req.Limit > DefaultPullLimit {
+		req.Limit = DefaultPullLimit
+	}
+	var (
+		msgs    []*dim.Message
+		hasMore bool
+	)
+	for since := req.Since; ; {
+		// one more to know if there are more
+		batch, err := store.Fetch(inbox, since, req.Limit+1)
+		if err != nil {
+			_ = ctx.RespWithError(pkt.SystemException, err)
+			return
+		}
+		// the messages are in order of sending, so only the earlier ones
+		// are skipped
+		for i, msg := range batch {
+			if msg.SendTime >= from {
+				msgs = batch[i:]
+				break
+			}
+		}
+		hasMore = len(batch) > req.Limit
+		if msgs != nil || !hasMore {
+			break
+		}
+		since = batch[len(batch)-1].ID
+	}
+	resp := &PullResp{Messages: make([]*MessagePush, 0, len(msgs)), HasMore: hasMore}
+	if len(msgs) > req.Limit {
+		msgs = msgs[:req.Limit]
+	}
+	for _, msg := range msgs {
+		resp.Messages = append(resp.Messages, fromMessage(msg))
+	}
+	_ = ctx.Resp(pkt.Success, resp)
+}
+
+// encodeContent encodes the body of a stored message, it is the push itself,
+// so a message delivered on login by the router.Offline sharing the store is
+// the same as the one pushed to a connected receiver.
+func encodeContent(push *MessagePush) []byte {
+	stored := *push
+	stored.Command = ""
+	body, _ := json.Marshal(&stored)
+	return body
+}
+
+func toMessage(command string, push *MessagePush) *dim.Message {
+	body := encodeContent(push)
+	return &dim.Message{
+		ID:       push.MessageID,
+		Command:  command,
+		Sender:   push.Sender,
+		Body:     body,
+		SendTime: push.SendTime,
+	}
+}
+
+func fromMessage(msg *dim.Message) *MessagePush {
+	var push MessagePush
+	_ = json.Unmarshal(msg.Body, &push)
+	push.MessageID = msg.ID
+	push.Sender = msg.Sender
+	push.SendTime = msg.SendTime
+	push.Command = msg.Command
+	return &push
+}
@@ -0,0 +1,201 @@
+package chat
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"dim"
+	"dim/idgen"
+	"dim/router"
+	"dim/router/routertest"
+	"dim/storage"
+	"dim/wire/pkt"
+)
+
+type env struct {
+	t             *testing.T
+	router        *router.Router
+	store         dim.MessageStore
+	sessions      dim.SessionStore
+	conversations dim.ConversationStore
+	disp          *routertest.Dispatcher
+	gw            *routertest.Gateway
+}
+
+func newEnv(t *testing.T) *env {
+	gen, _ := idgen.NewSnowflake(1, idgen.Options{})
+	r := router.NewRouter()
+	store := storage.NewMemoryMessageStore()
+	groups := NewMemoryGroupStore()
+	history := NewMemoryHistoryStore(time.Hour)
+	conversations := storage.NewMemoryConversationStore()
+	handler := NewHandler(store, gen)
+	handler.SetHistory(history)
+	handler.SetConversations(conversations)
+	handler.Register(r)
+	group := NewGroupHandler(groups, store, gen, GroupOptions{WriteDiffusionLimit: 3})
+	group.SetHistory(history)
+	group.SetConversations(conversations)
+	group.Register(r)
+	recall := NewRecallHandler(history, store, groups, gen, RecallOptions{
+		RecallWindow:        time.Millisecond * 100,
+		WriteDiffusionLimit: 3,
+	})
+	recall.SetConversations(conversations)
+	recall.Register(r)
+	conversation := NewConversationHandler(conversations)
+	conversation.SetTimeline(groups, store)
+	conversation.Register(r)
+	receipt := NewReceiptHandler(NewMemoryReadStore(), ReceiptOptions{TypingInterval: time.Millisecond * 50})
+	receipt.SetHistory(history)
+	receipt.SetConversations(conversations)
+	receipt.Register(r)
+	return &env{
+		t:             t,
+		router:        r,
+		store:         store,
+		sessions:      storage.NewMemoryStore(),
+		conversations: conversations,
+		disp:          &routertest.Dispatcher{},
+		gw:            routertest.NewGateway("gateway01"),
+	}
+}
+
+func (e *env) login(account string) {
+	_ = e.sessions.Add(&dim.Session{ChannelID: account, GateID: "gateway01", Account: account})
+}
+
+// call command as account, and returns the response
+func (e *env) call(account, command string, body interface{}) *pkt.LogicPkt {
+	e.t.Helper()
+	p := pkt.New(command, pkt.WithChannel(account)).WriteBody(body)
+	session := &dim.Session{ChannelID: account, GateID: "gateway01", Account: account}
+	if err := e.router.Serve(e.gw, p, session, e.disp, e.sessions); err != nil {
+		e.t.Fatal(err)
+	}
+	resp := e.gw.Last()
+	if resp == nil {
+		e.t.Fatalf("no response of %s", command)
+	}
+	e.gw.Take()
+	return resp
+}
+
+func TestUserTalk(t *testing.T) {
+	e := newEnv(t)
+	e.login("u1")
+	e.login("u2")
+
+	resp := e.call("u1", CommandUserTalk, &TalkReq{Dest: "u2", Body: "hello"})
+	var talk TalkResp
+	if err := resp.ReadBody(&talk); err != nil || resp.Status != pkt.Success || talk.MessageID == 0 {
+		t.Fatalf("unexpected response %s %v", resp, err)
+	}
+	if len(e.disp.Pushes) != 1 || e.disp.Pushes[0].Channels[0] != "u2" {
+		t.Fatalf("unexpected pushes %v", e.disp.Pushes)
+	}
+	var push MessagePush
+	_ = e.disp.Pushes[0].Packet.ReadBody(&push)
+	if push.MessageID != talk.MessageID || push.Sender != "u1" || push.Body != "hello" {
+		t.Fatalf("unexpected push %+v", push)
+	}
+	// stored by the service, so not stored again by the gateway
+	if id, _ := e.disp.Pushes[0].Packet.GetMeta(router.MetaMessageID); id != strconv.FormatInt(talk.MessageID, 10) {
+		t.Fatalf("unexpected message id %q of push", id)
+	}
+
+	// stored until acked
+	resp = e.call("u2", CommandOfflinePull, &PullReq{})
+	var pull PullResp
+	_ = resp.ReadBody(&pull)
+	if len(pull.Messages) != 1 || pull.Messages[0].MessageID != talk.MessageID {
+		t.Fatalf("unexpected pull %+v", pull)
+	}
+	if resp = e.call("u2", CommandTalkAck, &AckReq{MessageID: talk.MessageID}); resp.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	resp = e.call("u2", CommandOfflinePull, &PullReq{})
+	pull = PullResp{}
+	_ = resp.ReadBody(&pull)
+	if len(pull.Messages) != 0 {
+		t.Fatalf("unexpected pull %+v", pull)
+	}
+
+	if resp = e.call("u1", CommandUserTalk, &TalkReq{Body: "hello"}); resp.Status != pkt.InvalidPacketBody {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if resp = e.call("u1", CommandUserTalk, &TalkReq{Dest: "u1", Body: "hello"}); resp.Status != pkt.InvalidPacketBody {
+		t.Fatalf("unexpected response %s", resp)
+	}
+}
+
+func TestUserTalkDelivered(t *testing.T) {
+	e := newEnv(t)
+	e.login("u1")
+	e.login("u2")
+	_ = e.call("u1", CommandUserTalk, &TalkReq{Dest: "u2", Type: 1, Body: "hello", Extra: "x"})
+	var live MessagePush
+	if err := e.disp.Pushes[0].Packet.ReadBody(&live); err != nil {
+		t.Fatal(err)
+	}
+
+	// not acked, so delivered again on next login
+	gen, _ := idgen.NewSnowflake(2, idgen.Options{})
+	gw := routertest.NewGateway("gateway02")
+	if err := router.NewOffline(e.store, gen).Deliver("u2", "", gw); err != nil {
+		t.Fatal(err)
+	}
+	var delivered MessagePush
+	if err := gw.Last().ReadBody(&delivered); err != nil {
+		t.Fatal(err)
+	}
+	if delivered != live {
+		t.Fatalf("delivered %+v is not the push %+v", delivered, live)
+	}
+}
+
+func TestUserTalkSync(t *testing.T) {
+	e := newEnv(t)
+	e.loginDevice("u1#ios")
+	e.loginDevice("u1#web")
+	e.login("u2")
+
+	var talk TalkResp
+	_ = e.serve("u1#ios", CommandUserTalk, &TalkReq{Dest: "u2", Body: "hello"}).ReadBody(&talk)
+	if got := e.pushedTo(); len(got) != 2 || got[0] != "u1#web" || got[1] != "u2" {
+		t.Fatalf("unexpected pushes %v", got)
+	}
+	// the other devices of the sender pull the copy once logged in
+	var pull PullResp
+	_ = e.serve("u1#pad", CommandOfflinePull, &PullReq{}).ReadBody(&pull)
+	if len(pull.Messages) != 1 || pull.Messages[0].MessageID != talk.MessageID || pull.Messages[0].Sender != "u1" {
+		t.Fatalf("unexpected pull %+v", pull)
+	}
+}
+
+func TestOfflinePull(t *testing.T) {
+	e := newEnv(t)
+	e.login("u1")
+	var ids []int64
+	for i := 0; i < 5; i++ {
+		resp := e.call("u1", CommandUserTalk, &TalkReq{Dest: "u3", Body: "offline"})
+		var talk TalkResp
+		_ = resp.ReadBody(&talk)
+		ids = append(ids, talk.MessageID)
+	}
+	if len(e.disp.Pushes) != 0 {
+		t.Fatalf("unexpected pushes %v", e.disp.Pushes)
+	}
+
+	var pull PullResp
+	_ = e.call("u3", CommandOfflinePull, &PullReq{Limit: 3}).ReadBody(&pull)
+	if len(pull.Messages) != 3 || !pull.HasMore || pull.Messages[2].MessageID != ids[2] {
+		t.Fatalf("unexpected pull %+v", pull)
+	}
+	pull = PullResp{}
+	_ = e.call("u3", CommandOfflinePull, &PullReq{Since: ids[2], Limit: 3}).ReadBody(&pull)
+	if len(pull.Messages) != 2 || pull.HasMore || pull.Messages[1].MessageID != ids[4] {
+		t.Fatalf("unexpected pull %+v", pull)
+	}
+}
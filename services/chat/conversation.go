@@ -0,0 +1,297 @@
+package chat
+
+import (
+	"sort"
+
+	"dim"
+	"dim/logger"
+	"dim/router"
+	"dim/wire/pkt"
+)
+
+// commands of conversation
+const (
+	CommandConversationList  = "chat.conversation.list"
+	CommandConversationClear = "chat.conversation.clear"
+	CommandConversationPin   = "chat.conversation.pin"
+	CommandConversationMute  = "chat.conversation.mute"
+)
+
+// DefaultConversationLimit is the default number of conversations of a page
+const DefaultConversationLimit = 50
+
+// PreviewLength is the max runes of the preview of a conversation
+const PreviewLength = 64
+
+// MaxTimelineUnread is the max unread count of a group using read diffusion
+// counted from its timeline
+const MaxTimelineUnread = 99
+
+// ConversationListReq ConversationListReq
+type ConversationListReq struct {
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// ConversationListResp ConversationListResp
+type ConversationListResp struct {
+	Conversations []*dim.Conversation `json:"conversations"`
+	HasMore       bool                `json:"has_more"`
+}
+
+// ConversationReq is the request of chat.conversation.clear, ID is the peer
+// account or the group id
+type ConversationReq struct {
+	ID    string `json:"id"`
+	Group bool   `json:"group,omitempty"`
+}
+
+// PinReq PinReq
+type PinReq struct {
+	ConversationReq
+	Pinned bool `json:"pinned"`
+}
+
+// MuteReq MuteReq
+type MuteReq struct {
+	ConversationReq
+	Muted bool `json:"muted"`
+}
+
+// ConversationHandler serves the conversation list of an account, the list
+// is maintained by Handler and GroupHandler once the store is set to them.
+// The conversation of a group using read diffusion is not touched for every
+// member, the last message is kept by a shared conversation of the timeline,
+// and the unread count is counted from the timeline once listed.
+type ConversationHandler struct {
+	conversations dim.ConversationStore
+	groups        GroupStore
+	store         dim.MessageStore
+}
+
+// NewConversationHandler NewConversationHandler
+func NewConversationHandler(conversations dim.ConversationStore) *ConversationHandler {
+	return &ConversationHandler{conversations: conversations}
+}
+
+// SetTimeline lists the groups using read diffusion with the last message
+// and the unread count of their timelines in store
+func (h *ConversationHandler) SetTimeline(groups GroupStore, store dim.MessageStore) {
+	h.groups = groups
+	h.store = store
+}
+
+// Register the handlers of the commands to r
+func (h *ConversationHandler) Register(r *router.Router) {
+	r.Handle(CommandConversationList, h.DoList)
+	r.Handle(CommandConversationClear, h.DoClear)
+	r.Handle(CommandConversationPin, h.DoPin)
+	r.Handle(CommandConversationMute, h.DoMute)
+}
+
+// DoList DoList
+func (h *ConversationHandler) DoList(ctx router.Context) {
+	var req ConversationListReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	if req.Limit <= 0 || req.Limit > DefaultConversationLimit {
+		req.Limit = DefaultConversationLimit
+	}
+	account := ctx.Session().Account
+	var (
+		list []*dim.Conversation
+		err  error
+	)
+	if h.store == nil {
+		// one more to know if there are more
+		list, err = h.conversations.List(account, req.Offset, req.Limit+1)
+	} else {
+		// the order is changed by the timelines, so all are listed
+		if list, err = h.conversations.List(account, 0, 0); err == nil {
+			list = h.page(h.withTimelines(account, list), req.Offset, req.Limit+1)
+		}
+	}
+	if err != nil {
+		_ = ctx.RespWithError(pkt.SystemException, err)
+		return
+	}
+	resp := &ConversationListResp{Conversations: list}
+	if len(list) > req.Limit {
+		resp.Conversations, resp.HasMore = list[:req.Limit], true
+	}
+	if resp.Conversations == nil {
+		resp.Conversations = []*dim.Conversation{}
+	}
+	_ = ctx.Resp(pkt.Success, resp)
+}
+
+// DoClear clears the unread count of a conversation
+func (h *ConversationHandler) DoClear(ctx router.Context) {
+	var req ConversationReq
+	if err := ctx.ReadBody(&req); err != nil || req.ID == "" {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, ErrNoDest)
+		return
+	}
+	account := ctx.Session().Account
+	err := h.conversations.ClearUnread(account, req.ID, req.Group)
+	if err == nil && req.Group && h.store != nil {
+		// the last message of the timeline is not in the conversation
+		if shared := h.shared(req.ID); shared != nil {
+			err = h.conversations.SetRead(account, req.ID, true, shared.LastMessageID)
+		}
+	}
+	h.resp(ctx, err)
+}
+
+// DoPin DoPin
+func (h *ConversationHandler) DoPin(ctx router.Context) {
+	var req PinReq
+	if err := ctx.ReadBody(&req); err != nil || req.ID == "" {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, ErrNoDest)
+		return
+	}
+	h.resp(ctx, h.conversations.SetPinned(ctx.Session().Account, req.ID, req.Group, req.Pinned))
+}
+
+// DoMute DoMute
+func (h *ConversationHandler) DoMute(ctx router.Context) {
+	var req MuteReq
+	if err := ctx.ReadBody(&req); err != nil || req.ID == "" {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, ErrNoDest)
+		return
+	}
+	h.resp(ctx, h.conversations.SetMuted(ctx.Session().Account, req.ID, req.Group, req.Muted))
+}
+
+// withTimelines sets the last message and the unread count of the timelines
+// to the conversations of the groups using read diffusion
+func (h *ConversationHandler) withTimelines(account string, list []*dim.Conversation) []*dim.Conversation {
+	for _, c := range list {
+		if !c.Group {
+			continue
+		}
+		shared := h.shared(c.ID)
+		if shared == nil {
+			continue
+		}
+		if shared.LastMessageID > c.LastMessageID {
+			c.LastMessageID = shared.LastMessageID
+			c.LastSender = shared.LastSender
+			c.Preview = shared.Preview
+			c.UpdateTime = shared.UpdateTime
+		}
+		joined, err := h.groups.JoinedAt(c.ID, account)
+		if err != nil {
+			continue
+		}
+		c.Unread += h.unread(account, c.ID, c.ReadMessageID, joined)
+	}
+	return list
+}
+
+// shared returns the conversation of the timeline of a group, it is nil if
+// the group doesn't use read diffusion
+func (h *ConversationHandler) shared(groupID string) *dim.Conversation {
+	list, err := h.conversations.List(timeline(groupID), 0, 1)
+	if err != nil || len(list) == 0 {
+		return nil
+	}
+	return list[0]
+}
+
+// unread counts the messages of the timeline of a group after the read
+// position, sent by the others since the account joined, at most
+// MaxTimelineUnread
+func (h *ConversationHandler) unread(account, groupID string, since, joined int64) int {
+	unread := 0
+	for unread < MaxTimelineUnread {
+		msgs, err := h.store.Fetch(timeline(groupID), since, DefaultPullLimit)
+		if err != nil {
+			break
+		}
+		for _, msg := range msgs {
+			if msg.Command == CommandGroupTalk && msg.Sender != account && msg.SendTime >= joined {
+				unread++
+			}
+		}
+		if len(msgs) < DefaultPullLimit {
+			break
+		}
+		since = msgs[len(msgs)-1].ID
+	}
+	if unread > MaxTimelineUnread {
+		unread = MaxTimelineUnread
+	}
+	return unread
+}
+
+// page sorts the conversations as listed by the store, the pinned first,
+// and then the latest first
+func (h *ConversationHandler) page(list []*dim.Conversation, offset, limit int) []*dim.Conversation {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Pinned != list[j].Pinned {
+			return list[i].Pinned
+		}
+		return list[i].LastMessageID > list[j].LastMessageID
+	})
+	if offset < 0 || offset >= len(list) {
+		return nil
+	}
+	list = list[offset:]
+	if limit < len(list) {
+		list = list[:limit]
+	}
+	return list
+}
+
+func (h *ConversationHandler) resp(ctx router.Context, err error) {
+	switch err {
+	case nil:
+		_ = ctx.Resp(pkt.Success, nil)
+	case dim.ErrConversationNotFound:
+		_ = ctx.RespWithError(pkt.NotFound, err)
+	default:
+		_ = ctx.RespWithError(pkt.SystemException, err)
+	}
+}
+
+// indexConversation sets push as the last message of the conversation of
+// the sender, and of the receivers with the unread count incremented. The
+// conversation of a receiver of a one-to-one message is the sender. The
+// receivers of a group using read diffusion are not touched, push is set to
+// the shared conversation of the timeline instead.
+func indexConversation(ctx router.Context, conversations dim.ConversationStore, push *MessagePush, dest string, readDiffusion bool, receivers ...string) {
+	c := &dim.Conversation{
+		ID:            dest,
+		Group:         push.Group != "",
+		LastMessageID: push.MessageID,
+		LastSender:    push.Sender,
+		Preview:       preview(push.Body),
+		UpdateTime:    push.SendTime,
+	}
+	err := conversations.Touch(c, false, push.Sender)
+	if err == nil && readDiffusion {
+		err = conversations.Touch(c, false, timeline(dest))
+	} else if err == nil {
+		if !c.Group {
+			c.ID = push.Sender
+		}
+		err = conversations.Touch(c, true, receivers...)
+	}
+	if err != nil {
+		ctx.Logger().WithFields(logger.Fields{
+			"module":  "chat.conversation",
+			"message": push.MessageID,
+		}).Warnf("index conversation failed: %v", err)
+	}
+}
+
+func preview(body string) string {
+	runes := []rune(body)
+	if len(runes) > PreviewLength {
+		return string(runes[:PreviewLength])
+	}
+	return body
+}
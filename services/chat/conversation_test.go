@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"testing"
+
+	"dim"
+	"dim/wire/pkt"
+)
+
+func TestConversation(t *testing.T) {
+	e := newEnv(t)
+	e.login("u1")
+	e.login("u2")
+
+	var created GroupCreateResp
+	_ = e.call("u1", CommandGroupCreate, &GroupCreateReq{Name: "g", Members: []string{"u2"}}).ReadBody(&created)
+	_ = e.call("u2", CommandUserTalk, &TalkReq{Dest: "u1", Body: "hi"})
+	_ = e.call("u2", CommandUserTalk, &TalkReq{Dest: "u1", Body: "there"})
+	_ = e.call("u2", CommandGroupTalk, &GroupTalkReq{GroupID: created.GroupID, Body: "all"})
+
+	list := func(account string, offset, limit int) ConversationListResp {
+		var resp ConversationListResp
+		_ = e.call(account, CommandConversationList, &ConversationListReq{Offset: offset, Limit: limit}).ReadBody(&resp)
+		return resp
+	}
+	resp := list("u1", 0, 1)
+	if len(resp.Conversations) != 1 || !resp.HasMore {
+		t.Fatalf("unexpected conversations %+v", resp)
+	}
+	if c := resp.Conversations[0]; c.ID != created.GroupID || !c.Group || c.Unread != 1 || c.Preview != "all" {
+		t.Fatalf("unexpected conversation %+v", c)
+	}
+	resp = list("u1", 1, 1)
+	if c := resp.Conversations[0]; resp.HasMore || c.ID != "u2" || c.Unread != 2 || c.Preview != "there" || c.LastSender != "u2" {
+		t.Fatalf("unexpected conversations %+v", resp)
+	}
+	// the sender has nothing unread
+	if resp = list("u2", 0, 10); len(resp.Conversations) != 2 || resp.Conversations[1].ID != "u1" || resp.Conversations[1].Unread != 0 {
+		t.Fatalf("unexpected conversations %+v", resp)
+	}
+
+	// cleared once read
+	if r := e.call("u1", CommandRead, &ReadReq{Dest: created.GroupID, Group: true, MessageID: resp.Conversations[0].LastMessageID}); r.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", r)
+	}
+	if c := list("u1", 0, 1).Conversations[0]; c.ID != created.GroupID || c.Unread != 0 {
+		t.Fatalf("unexpected conversation %+v", c)
+	}
+	if r := e.call("u1", CommandConversationClear, &ConversationReq{ID: "u2"}); r.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", r)
+	}
+	if r := e.call("u1", CommandConversationClear, &ConversationReq{ID: "u3"}); r.Status != pkt.NotFound {
+		t.Fatalf("unexpected response %s", r)
+	}
+	if r := e.call("u1", CommandConversationPin, &PinReq{ConversationReq{ID: "u2"}, true}); r.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", r)
+	}
+	if r := e.call("u1", CommandConversationMute, &MuteReq{ConversationReq{ID: "u3"}, true}); r.Status != pkt.NotFound {
+		t.Fatalf("unexpected response %s", r)
+	}
+	if c := list("u1", 0, 10).Conversations[0]; c.ID != "u2" || !c.Pinned || c.Unread != 0 {
+		t.Fatalf("unexpected conversation %+v", c)
+	}
+}
+
+func TestConversationReadPosition(t *testing.T) {
+	e := newEnv(t)
+	e.login("u1")
+	var talks [3]TalkResp
+	for i := range talks {
+		_ = e.call("u2", CommandUserTalk, &TalkReq{Dest: "u1", Body: "hi"}).ReadBody(&talks[i])
+	}
+	// read up to the first, the later ones are still unread
+	_ = e.call("u1", CommandRead, &ReadReq{Dest: "u2", MessageID: talks[0].MessageID})
+	var resp ConversationListResp
+	_ = e.call("u1", CommandConversationList, &ConversationListReq{}).ReadBody(&resp)
+	if c := resp.Conversations[0]; c.Unread != 2 || c.ReadMessageID != talks[0].MessageID {
+		t.Fatalf("unexpected conversation %+v", c)
+	}
+}
+
+func TestConversationReadDiffusion(t *testing.T) {
+	e := newEnv(t)
+	var created GroupCreateResp
+	_ = e.call("u1", CommandGroupCreate, &GroupCreateReq{Name: "g", Members: []string{"u2", "u3", "u4"}}).ReadBody(&created)
+	gid := created.GroupID
+
+	list := func(account string) *dim.Conversation {
+		var resp ConversationListResp
+		_ = e.call(account, CommandConversationList, &ConversationListReq{}).ReadBody(&resp)
+		if len(resp.Conversations) != 1 {
+			t.Fatalf("unexpected conversations %+v", resp)
+		}
+		return resp.Conversations[0]
+	}
+	// listed before any message
+	if c := list("u4"); c.ID != gid || c.LastMessageID != 0 {
+		t.Fatalf("unexpected conversation %+v", c)
+	}
+
+	var talks [3]TalkResp
+	for i := range talks {
+		_ = e.call("u1", CommandGroupTalk, &GroupTalkReq{GroupID: gid, Body: "all"}).ReadBody(&talks[i])
+	}
+	// the members are not touched
+	stored, _ := e.conversations.List("u4", 0, 10)
+	if len(stored) != 1 || stored[0].LastMessageID != 0 {
+		t.Fatalf("unexpected stored conversations %+v", stored)
+	}
+	if c := list("u4"); c.LastMessageID != talks[2].MessageID || c.Preview != "all" || c.Unread != 3 {
+		t.Fatalf("unexpected conversation %+v", c)
+	}
+	if c := list("u1"); c.Unread != 0 {
+		t.Fatalf("unexpected conversation %+v", c)
+	}
+	_ = e.call("u4", CommandRead, &ReadReq{Dest: gid, Group: true, MessageID: talks[0].MessageID})
+	if c := list("u4"); c.Unread != 2 {
+		t.Fatalf("unexpected conversation %+v", c)
+	}
+	if r := e.call("u4", CommandConversationClear, &ConversationReq{ID: gid, Group: true}); r.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", r)
+	}
+	if c := list("u4"); c.Unread != 0 {
+		t.Fatalf("unexpected conversation %+v", c)
+	}
+}
@@ -0,0 +1,433 @@
+package chat
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"dim"
+	"dim/idgen"
+	"dim/router"
+	"dim/wire/pkt"
+)
+
+// commands of group
+const (
+	CommandGroupCreate  = "chat.group.create"
+	CommandGroupJoin    = "chat.group.join"
+	CommandGroupInvite  = "chat.group.invite"
+	CommandGroupApprove = "chat.group.approve"
+	CommandGroupQuit    = "chat.group.quit"
+	CommandGroupDetail  = "chat.group.detail"
+	CommandGroupMembers = "chat.group.members"
+	CommandGroupTalk    = "chat.group.talk"
+	CommandGroupPull    = "chat.group.pull"
+)
+
+// DefaultWriteDiffusionLimit is the default max members of a group using
+// write diffusion
+const DefaultWriteDiffusionLimit = 200
+
+// errors of group
+var (
+	// ErrNoGroupName is responded if the name of a new group is empty
+	ErrNoGroupName = errors.New("group name is required")
+	// ErrNotOwner is responded if a join is approved by a member not the owner
+	ErrNotOwner = errors.New("not the owner of the group")
+)
+
+// GroupCreateReq GroupCreateReq
+type GroupCreateReq struct {
+	Name         string   `json:"name"`
+	Avatar       string   `json:"avatar,omitempty"`
+	Introduction string   `json:"introduction,omitempty"`
+	Members      []string `json:"members"`
+}
+
+// GroupCreateResp GroupCreateResp
+type GroupCreateResp struct {
+	GroupID string `json:"group_id"`
+}
+
+// GroupReq is the request of join, quit, detail and members
+type GroupReq struct {
+	GroupID string `json:"group_id"`
+}
+
+// GroupJoinResp is pending if the join is applied to the owner
+type GroupJoinResp struct {
+	Pending bool `json:"pending,omitempty"`
+}
+
+// GroupApplyPush is pushed to the owner with the account applying to join
+type GroupApplyPush struct {
+	GroupID string `json:"group_id"`
+	Account string `json:"account"`
+}
+
+// GroupInviteReq GroupInviteReq
+type GroupInviteReq struct {
+	GroupID string   `json:"group_id"`
+	Members []string `json:"members"`
+}
+
+// GroupApproveReq approves the application of an account
+type GroupApproveReq struct {
+	GroupID string `json:"group_id"`
+	Account string `json:"account"`
+}
+
+// GroupMembersResp GroupMembersResp
+type GroupMembersResp struct {
+	Members []string `json:"members"`
+}
+
+// GroupTalkReq GroupTalkReq
+type GroupTalkReq struct {
+	GroupID string `json:"group_id"`
+	Type    int32  `json:"type"`
+	Body    string `json:"body"`
+	Extra   string `json:"extra,omitempty"`
+}
+
+// GroupPullReq pulls the messages of a group using read diffusion
+type GroupPullReq struct {
+	GroupID string `json:"group_id"`
+	PullReq
+}
+
+// GroupOptions GroupOptions
+type GroupOptions struct {
+	// WriteDiffusionLimit is the max members of a group whose messages are
+	// stored in the inbox of every member, the messages of a larger group are
+	// stored once in the timeline of the group and pulled by chat.group.pull.
+	// Default DefaultWriteDiffusionLimit
+	WriteDiffusionLimit int
+}
+
+// GroupHandler is the group chat service, the messages are pushed to the
+// online members on every gateway, and stored for pulling by write or read
+// diffusion by the size of the group.
+type GroupHandler struct {
+	groups        GroupStore
+	store         dim.MessageStore
+	gen           idgen.Generator
+	options       GroupOptions
+	history       HistoryStore
+	conversations dim.ConversationStore
+}
+
+// NewGroupHandler NewGroupHandler
+func NewGroupHandler(groups GroupStore, store dim.MessageStore, gen idgen.Generator, opts GroupOptions) *GroupHandler {
+	if opts.WriteDiffusionLimit <= 0 {
+		opts.WriteDiffusionLimit = DefaultWriteDiffusionLimit
+	}
+	return &GroupHandler{
+		groups:  groups,
+		store:   store,
+		gen:     gen,
+		options: opts,
+	}
+}
+
+// SetHistory records the messages sent in history, so they can be recalled
+// or edited
+func (h *GroupHandler) SetHistory(history HistoryStore) {
+	h.history = history
+}
+
+// SetConversations maintains the conversations of the members with the
+// messages sent
+func (h *GroupHandler) SetConversations(conversations dim.ConversationStore) {
+	h.conversations = conversations
+}
+
+// Register the handlers of the commands to r
+func (h *GroupHandler) Register(r *router.Router) {
+	r.Handle(CommandGroupCreate, h.DoCreate)
+	r.Handle(CommandGroupJoin, h.DoJoin)
+	r.Handle(CommandGroupInvite, h.member, h.DoInvite)
+	r.Handle(CommandGroupApprove, h.member, h.DoApprove)
+	r.Handle(CommandGroupQuit, h.DoQuit)
+	r.Handle(CommandGroupDetail, h.member, h.DoDetail)
+	r.Handle(CommandGroupMembers, h.member, h.DoMembers)
+	r.Handle(CommandGroupTalk, h.member, h.DoTalk)
+	r.Handle(CommandGroupPull, h.member, h.DoPull)
+}
+
+// DoCreate creates a group owned by the sender with the members
+func (h *GroupHandler) DoCreate(ctx router.Context) {
+	var req GroupCreateReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	if req.Name == "" {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, ErrNoGroupName)
+		return
+	}
+	id, err := h.gen.Next()
+	if err != nil {
+		_ = ctx.RespWithError(pkt.SystemException, err)
+		return
+	}
+	owner := ctx.Session().Account
+	group := &Group{
+		ID:           strconv.FormatInt(id, 10),
+		Name:         req.Name,
+		Avatar:       req.Avatar,
+		Introduction: req.Introduction,
+		Owner:        owner,
+		CreatedAt:    time.Now().UnixMilli(),
+	}
+	members := append([]string{owner}, req.Members...)
+	if err = h.groups.Create(group, members...); err != nil {
+		_ = ctx.RespWithError(pkt.SystemException, err)
+		return
+	}
+	h.joined(ctx, group.ID, members...)
+	_ = ctx.Resp(pkt.Success, &GroupCreateResp{GroupID: group.ID})
+}
+
+// DoJoin joins the sender invited by a member, or applies to the owner
+// otherwise, the response is pending until the owner approves.
+func (h *GroupHandler) DoJoin(ctx router.Context) {
+	var req GroupReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	account := ctx.Session().Account
+	err := h.groups.Accept(req.GroupID, account, true)
+	if err == nil {
+		h.joined(ctx, req.GroupID, account)
+		_ = ctx.Resp(pkt.Success, &GroupJoinResp{})
+		return
+	}
+	if err != ErrNotPending {
+		respWithGroupError(ctx, err)
+		return
+	}
+	group, err := h.groups.Get(req.GroupID)
+	if err == nil {
+		err = h.groups.Apply(req.GroupID, account)
+	}
+	if err != nil {
+		respWithGroupError(ctx, err)
+		return
+	}
+	if locs, err := ctx.GetLocations(group.Owner); err == nil {
+		_ = ctx.Dispatch(&GroupApplyPush{GroupID: group.ID, Account: account}, locs...)
+	}
+	_ = ctx.Resp(pkt.Success, &GroupJoinResp{Pending: true})
+}
+
+// DoInvite invites the accounts to join the group
+func (h *GroupHandler) DoInvite(ctx router.Context) {
+	var req GroupInviteReq
+	_ = ctx.ReadBody(&req)
+	if err := h.groups.Invite(req.GroupID, req.Members...); err != nil {
+		respWithGroupError(ctx, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Success, nil)
+}
+
+// DoApprove joins the account applied, only by the owner
+func (h *GroupHandler) DoApprove(ctx router.Context) {
+	var req GroupApproveReq
+	_ = ctx.ReadBody(&req)
+	group, err := h.groups.Get(req.GroupID)
+	if err == nil && group.Owner != ctx.Session().Account {
+		err = ErrNotOwner
+	}
+	if err == nil {
+		err = h.groups.Accept(req.GroupID, req.Account, false)
+	}
+	if err != nil {
+		respWithGroupError(ctx, err)
+		return
+	}
+	h.joined(ctx, req.GroupID, req.Account)
+	_ = ctx.Resp(pkt.Success, nil)
+}
+
+// joined adds the conversation of the group to the members joined, so the
+// group is listed before its first message is sent with read diffusion
+func (h *GroupHandler) joined(ctx router.Context, groupID string, members ...string) {
+	if h.conversations == nil {
+		return
+	}
+	c := &dim.Conversation{ID: groupID, Group: true, UpdateTime: time.Now().UnixMilli()}
+	if err := h.conversations.Touch(c, false, members...); err != nil {
+		ctx.Logger().WithField("module", "chat.group").Warnf("index conversation of %s failed: %v", groupID, err)
+	}
+}
+
+// DoQuit DoQuit
+func (h *GroupHandler) DoQuit(ctx router.Context) {
+	var req GroupReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	if err := h.groups.RemoveMember(req.GroupID, ctx.Session().Account); err != nil {
+		respWithGroupError(ctx, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Success, nil)
+}
+
+// DoDetail DoDetail
+func (h *GroupHandler) DoDetail(ctx router.Context) {
+	var req GroupReq
+	_ = ctx.ReadBody(&req)
+	group, err := h.groups.Get(req.GroupID)
+	if err != nil {
+		respWithGroupError(ctx, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Success, group)
+}
+
+// DoMembers DoMembers
+func (h *GroupHandler) DoMembers(ctx router.Context) {
+	var req GroupReq
+	_ = ctx.ReadBody(&req)
+	members, err := h.groups.Members(req.GroupID)
+	if err != nil {
+		respWithGroupError(ctx, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Success, &GroupMembersResp{Members: members})
+}
+
+// DoTalk stores the message by the diffusion of the group, and pushes it to
+// the other online members and the other devices of the sender. A copy is
+// stored in the inbox of the sender too with write diffusion, as a message of
+// DoUserTalk, so the other devices of the sender are synced.
+func (h *GroupHandler) DoTalk(ctx router.Context) {
+	var req GroupTalkReq
+	_ = ctx.ReadBody(&req)
+	members, err := h.groups.Members(req.GroupID)
+	if err != nil {
+		respWithGroupError(ctx, err)
+		return
+	}
+	id, err := h.gen.Next()
+	if err != nil {
+		_ = ctx.RespWithError(pkt.SystemException, err)
+		return
+	}
+	sender := ctx.Session().Account
+	push := &MessagePush{
+		MessageID: id,
+		Sender:    sender,
+		Group:     req.GroupID,
+		Type:      req.Type,
+		Body:      req.Body,
+		Extra:     req.Extra,
+		SendTime:  time.Now().UnixMilli(),
+	}
+	receivers := make([]string, 0, len(members))
+	for _, account := range members {
+		if account != sender {
+			receivers = append(receivers, account)
+		}
+	}
+	msg := toMessage(CommandGroupTalk, push)
+	if err = diffuse(h.store, msg, req.GroupID, members, len(members), h.options.WriteDiffusionLimit); err != nil {
+		_ = ctx.RespWithError(pkt.SystemException, err)
+		return
+	}
+	if h.history != nil {
+		err = h.history.Add(&Record{
+			MessageID: id,
+			Sender:    sender,
+			Dest:      req.GroupID,
+			Group:     true,
+			Type:      req.Type,
+			SendTime:  push.SendTime,
+		})
+		if err != nil {
+			ctx.Logger().WithField("module", "chat.group").Warnf("add history of %d failed: %v", id, err)
+		}
+	}
+	if h.conversations != nil {
+		readDiffusion := len(members) > h.options.WriteDiffusionLimit
+		indexConversation(ctx, h.conversations, push, req.GroupID, readDiffusion, receivers...)
+	}
+
+	locs, err := ctx.GetLocations(members...)
+	if err == nil {
+		err = ctx.DispatchMessage(id, push, exclude(locs, ctx.Session().ChannelID)...)
+	}
+	if err != nil {
+		ctx.Logger().WithField("module", "chat.group").Warnf("dispatch %d failed: %v", id, err)
+	}
+	_ = ctx.Resp(pkt.Success, &TalkResp{
+		MessageID: id,
+		SendTime:  push.SendTime,
+	})
+}
+
+// DoPull pulls the timeline of a group using read diffusion, the messages
+// are kept in the timeline, so a member pulls since the last message read,
+// and the messages sent before the member joined are skipped.
+func (h *GroupHandler) DoPull(ctx router.Context) {
+	var req GroupPullReq
+	_ = ctx.ReadBody(&req)
+	joined, err := h.groups.JoinedAt(req.GroupID, ctx.Session().Account)
+	if err != nil {
+		respWithGroupError(ctx, err)
+		return
+	}
+	pull(ctx, h.store, timeline(req.GroupID), &req.PullReq, joined)
+}
+
+// member aborts the request of a sender not in the group
+func (h *GroupHandler) member(ctx router.Context) {
+	var req GroupReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		ctx.Abort()
+		return
+	}
+	ok, err := h.groups.IsMember(req.GroupID, ctx.Session().Account)
+	if err == nil && !ok {
+		err = ErrNotMember
+	}
+	if err != nil {
+		respWithGroupError(ctx, err)
+		ctx.Abort()
+	}
+}
+
+// diffuse stores msg of a group in the inboxes of the receivers if the size
+// of the group is not larger than limit, in the timeline otherwise
+func diffuse(store dim.MessageStore, msg *dim.Message, groupID string, receivers []string, size, limit int) error {
+	if size > limit {
+		return store.Insert(msg, timeline(groupID))
+	}
+	return store.Insert(msg, receivers...)
+}
+
+// timeline is the inbox of the messages of a group using read diffusion
+func timeline(groupID string) string {
+	return "group:" + groupID
+}
+
+func respWithGroupError(ctx router.Context, err error) {
+	switch err {
+	case ErrGroupNotFound:
+		_ = ctx.RespWithError(pkt.NotFound, err)
+	case ErrNotMember:
+		_ = ctx.RespWithError(pkt.Unauthorized, err)
+	case ErrNotOwner:
+		_ = ctx.RespWithError(pkt.Forbidden, err)
+	case ErrNotPending:
+		_ = ctx.RespWithError(pkt.NotFound, err)
+	default:
+		_ = ctx.RespWithError(pkt.SystemException, err)
+	}
+}
@@ -0,0 +1,219 @@
+package chat
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errors
+var (
+	ErrGroupNotFound = errors.New("group not found")
+	ErrNotMember     = errors.New("not a member of the group")
+	ErrNotPending    = errors.New("no pending invitation or application")
+)
+
+// Group is the metadata of a group
+type Group struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Avatar       string `json:"avatar,omitempty"`
+	Introduction string `json:"introduction,omitempty"`
+	Owner        string `json:"owner"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+// GroupStore stores the groups and their members
+type GroupStore interface {
+	Create(group *Group, members ...string) error
+	Get(groupID string) (*Group, error)
+	AddMembers(groupID string, accounts ...string) error
+	RemoveMember(groupID, account string) error
+	Members(groupID string) ([]string, error)
+	IsMember(groupID, account string) (bool, error)
+	// JoinedAt returns the unix milliseconds an account joined the group
+	JoinedAt(groupID, account string) (int64, error)
+	// Invite records the invitations of the accounts by a member
+	Invite(groupID string, accounts ...string) error
+	// Apply records the application of an account to be approved by the owner
+	Apply(groupID, account string) error
+	// Accept adds an account invited, or applied if invited is false, to the
+	// members, ErrNotPending is returned if there is no such a record
+	Accept(groupID, account string, invited bool) error
+}
+
+// MemoryGroupStore is a GroupStore in memory
+type MemoryGroupStore struct {
+	sync.RWMutex
+	groups map[string]*Group
+	// groupID -> account -> join time
+	members map[string]map[string]int64
+	// groupID -> account -> invited or applied
+	pending map[string]map[string]bool
+}
+
+// NewMemoryGroupStore NewMemoryGroupStore
+func NewMemoryGroupStore() GroupStore {
+	return &MemoryGroupStore{
+		groups:  make(map[string]*Group),
+		members: make(map[string]map[string]int64),
+		pending: make(map[string]map[string]bool),
+	}
+}
+
+// Create Create
+func (m *MemoryGroupStore) Create(group *Group, members ...string) error {
+	m.Lock()
+	defer m.Unlock()
+	g := *group
+	m.groups[g.ID] = &g
+	m.members[g.ID] = make(map[string]int64, len(members))
+	m.pending[g.ID] = make(map[string]bool)
+	m.addLocked(g.ID, members)
+	return nil
+}
+
+// Get Get
+func (m *MemoryGroupStore) Get(groupID string) (*Group, error) {
+	m.RLock()
+	defer m.RUnlock()
+	g, ok := m.groups[groupID]
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
+	group := *g
+	return &group, nil
+}
+
+// AddMembers AddMembers
+func (m *MemoryGroupStore) AddMembers(groupID string, accounts ...string) error {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.groups[groupID]; !ok {
+		return ErrGroupNotFound
+	}
+	m.addLocked(groupID, accounts)
+	return nil
+}
+
+// RemoveMember RemoveMember
+func (m *MemoryGroupStore) RemoveMember(groupID, account string) error {
+	m.Lock()
+	defer m.Unlock()
+	members, ok := m.members[groupID]
+	if !ok {
+		return ErrGroupNotFound
+	}
+	if _, ok = members[account]; !ok {
+		return ErrNotMember
+	}
+	delete(members, account)
+	return nil
+}
+
+// Members returns the members in order of joining
+func (m *MemoryGroupStore) Members(groupID string) ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+	members, ok := m.members[groupID]
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
+	accounts := make([]string, 0, len(members))
+	for account := range members {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		ti, tj := members[accounts[i]], members[accounts[j]]
+		if ti != tj {
+			return ti < tj
+		}
+		return accounts[i] < accounts[j]
+	})
+	return accounts, nil
+}
+
+// IsMember IsMember
+func (m *MemoryGroupStore) IsMember(groupID, account string) (bool, error) {
+	m.RLock()
+	defer m.RUnlock()
+	members, ok := m.members[groupID]
+	if !ok {
+		return false, ErrGroupNotFound
+	}
+	_, ok = members[account]
+	return ok, nil
+}
+
+// JoinedAt JoinedAt
+func (m *MemoryGroupStore) JoinedAt(groupID, account string) (int64, error) {
+	m.RLock()
+	defer m.RUnlock()
+	members, ok := m.members[groupID]
+	if !ok {
+		return 0, ErrGroupNotFound
+	}
+	joined, ok := members[account]
+	if !ok {
+		return 0, ErrNotMember
+	}
+	return time.Unix(0, joined).UnixMilli(), nil
+}
+
+// Invite Invite
+func (m *MemoryGroupStore) Invite(groupID string, accounts ...string) error {
+	m.Lock()
+	defer m.Unlock()
+	pending, ok := m.pending[groupID]
+	if !ok {
+		return ErrGroupNotFound
+	}
+	for _, account := range accounts {
+		if _, ok := m.members[groupID][account]; !ok {
+			pending[account] = true
+		}
+	}
+	return nil
+}
+
+// Apply Apply
+func (m *MemoryGroupStore) Apply(groupID, account string) error {
+	m.Lock()
+	defer m.Unlock()
+	pending, ok := m.pending[groupID]
+	if !ok {
+		return ErrGroupNotFound
+	}
+	// an invitation is not overwritten by an application
+	if _, ok := pending[account]; !ok {
+		pending[account] = false
+	}
+	return nil
+}
+
+// Accept Accept
+func (m *MemoryGroupStore) Accept(groupID, account string, invited bool) error {
+	m.Lock()
+	defer m.Unlock()
+	pending, ok := m.pending[groupID]
+	if !ok {
+		return ErrGroupNotFound
+	}
+	if v, ok := pending[account]; !ok || v != invited {
+		return ErrNotPending
+	}
+	delete(pending, account)
+	m.addLocked(groupID, []string{account})
+	return nil
+}
+
+func (m *MemoryGroupStore) addLocked(groupID string, accounts []string) {
+	now := time.Now().UnixNano()
+	for _, account := range accounts {
+		if _, ok := m.members[groupID][account]; !ok {
+			m.members[groupID][account] = now
+		}
+		delete(m.pending[groupID], account)
+	}
+}
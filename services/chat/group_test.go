@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"testing"
+	"time"
+
+	"dim/wire/pkt"
+)
+
+func TestGroup(t *testing.T) {
+	e := newEnv(t)
+	e.login("u1")
+	e.login("u2")
+
+	var created GroupCreateResp
+	resp := e.call("u1", CommandGroupCreate, &GroupCreateReq{Name: "g", Members: []string{"u2", "u3"}})
+	if err := resp.ReadBody(&created); err != nil || created.GroupID == "" {
+		t.Fatalf("unexpected response %s %v", resp, err)
+	}
+	gid := created.GroupID
+
+	var group Group
+	_ = e.call("u2", CommandGroupDetail, &GroupReq{GroupID: gid}).ReadBody(&group)
+	if group.Name != "g" || group.Owner != "u1" {
+		t.Fatalf("unexpected group %+v", group)
+	}
+	if resp = e.call("u4", CommandGroupDetail, &GroupReq{GroupID: gid}); resp.Status != pkt.Unauthorized {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if resp = e.call("u1", CommandGroupDetail, &GroupReq{GroupID: "404"}); resp.Status != pkt.NotFound {
+		t.Fatalf("unexpected response %s", resp)
+	}
+
+	// write diffusion
+	var talk TalkResp
+	_ = e.call("u1", CommandGroupTalk, &GroupTalkReq{GroupID: gid, Body: "hi"}).ReadBody(&talk)
+	if len(e.disp.Pushes) != 1 || len(e.disp.Pushes[0].Channels) != 1 || e.disp.Pushes[0].Channels[0] != "u2" {
+		t.Fatalf("unexpected pushes %v", e.disp.Pushes)
+	}
+	var push MessagePush
+	_ = e.disp.Pushes[0].Packet.ReadBody(&push)
+	if push.Group != gid || push.MessageID != talk.MessageID || e.disp.Pushes[0].Packet.Command != CommandGroupTalk {
+		t.Fatalf("unexpected push %+v", push)
+	}
+	var pull PullResp
+	_ = e.call("u3", CommandOfflinePull, &PullReq{}).ReadBody(&pull)
+	if len(pull.Messages) != 1 || pull.Messages[0].Group != gid {
+		t.Fatalf("unexpected pull %+v", pull)
+	}
+
+	// read diffusion once the group is larger than 3
+	if resp = e.call("u2", CommandGroupInvite, &GroupInviteReq{GroupID: gid, Members: []string{"u4"}}); resp.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	var join GroupJoinResp
+	_ = e.call("u4", CommandGroupJoin, &GroupReq{GroupID: gid}).ReadBody(&join)
+	if join.Pending {
+		t.Fatalf("unexpected pending join of invited u4")
+	}
+	// joined by the approval of the owner without an invitation
+	e.disp.Take()
+	_ = e.call("u5", CommandGroupJoin, &GroupReq{GroupID: gid}).ReadBody(&join)
+	if !join.Pending || len(e.disp.Pushes) != 1 || e.disp.Pushes[0].Channels[0] != "u1" {
+		t.Fatalf("unexpected join %+v %v", join, e.disp.Pushes)
+	}
+	if resp = e.call("u5", CommandGroupTalk, &GroupTalkReq{GroupID: gid}); resp.Status != pkt.Unauthorized {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if resp = e.call("u2", CommandGroupApprove, &GroupApproveReq{GroupID: gid, Account: "u5"}); resp.Status != pkt.Forbidden {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if resp = e.call("u1", CommandGroupApprove, &GroupApproveReq{GroupID: gid, Account: "u5"}); resp.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if resp = e.call("u1", CommandGroupApprove, &GroupApproveReq{GroupID: gid, Account: "u6"}); resp.Status != pkt.NotFound {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	_ = e.call("u2", CommandGroupTalk, &GroupTalkReq{GroupID: gid, Body: "all"}).ReadBody(&talk)
+	pull = PullResp{}
+	_ = e.call("u5", CommandOfflinePull, &PullReq{}).ReadBody(&pull)
+	if len(pull.Messages) != 0 {
+		t.Fatalf("unexpected pull %+v", pull)
+	}
+	_ = e.call("u5", CommandGroupPull, &GroupPullReq{GroupID: gid}).ReadBody(&pull)
+	if len(pull.Messages) != 1 || pull.Messages[0].MessageID != talk.MessageID || pull.Messages[0].Sender != "u2" {
+		t.Fatalf("unexpected pull %+v", pull)
+	}
+
+	// the messages before joining are not pulled
+	time.Sleep(time.Millisecond * 2)
+	_ = e.call("u1", CommandGroupInvite, &GroupInviteReq{GroupID: gid, Members: []string{"u6"}})
+	_ = e.call("u6", CommandGroupJoin, &GroupReq{GroupID: gid})
+	pull = PullResp{}
+	_ = e.call("u6", CommandGroupPull, &GroupPullReq{GroupID: gid}).ReadBody(&pull)
+	if len(pull.Messages) != 0 {
+		t.Fatalf("unexpected pull %+v", pull)
+	}
+	_ = e.call("u6", CommandGroupQuit, &GroupReq{GroupID: gid})
+
+	if resp = e.call("u5", CommandGroupQuit, &GroupReq{GroupID: gid}); resp.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	var members GroupMembersResp
+	_ = e.call("u1", CommandGroupMembers, &GroupReq{GroupID: gid}).ReadBody(&members)
+	if len(members.Members) != 4 || members.Members[0] != "u1" || members.Members[3] != "u4" {
+		t.Fatalf("unexpected members %v", members.Members)
+	}
+	if resp = e.call("u5", CommandGroupTalk, &GroupTalkReq{GroupID: gid}); resp.Status != pkt.Unauthorized {
+		t.Fatalf("unexpected response %s", resp)
+	}
+}
+
+func TestGroupTalkSync(t *testing.T) {
+	e := newEnv(t)
+	e.loginDevice("u1#ios")
+	e.loginDevice("u1#web")
+	e.login("u2")
+
+	var created GroupCreateResp
+	_ = e.serve("u1#ios", CommandGroupCreate, &GroupCreateReq{Name: "g", Members: []string{"u2"}}).ReadBody(&created)
+	e.disp.Take()
+
+	var talk TalkResp
+	_ = e.serve("u1#ios", CommandGroupTalk, &GroupTalkReq{GroupID: created.GroupID, Body: "hi"}).ReadBody(&talk)
+	if got := e.pushedTo(); len(got) != 2 || got[0] != "u1#web" || got[1] != "u2" {
+		t.Fatalf("unexpected pushes %v", got)
+	}
+	// the other devices of the sender pull the copy once logged in
+	var pull PullResp
+	_ = e.serve("u1#pad", CommandOfflinePull, &PullReq{}).ReadBody(&pull)
+	if len(pull.Messages) != 1 || pull.Messages[0].MessageID != talk.MessageID {
+		t.Fatalf("unexpected pull %+v", pull)
+	}
+}
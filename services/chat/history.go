@@ -0,0 +1,116 @@
+package chat
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrMessageNotFound is responded if a message is not in the history
+var ErrMessageNotFound = errors.New("message not found")
+
+// Record is the record of a message sent, it is used to validate the recall
+// and the edit of the message.
+type Record struct {
+	MessageID int64  `json:"message_id"`
+	Sender    string `json:"sender"`
+	// Dest is the receiver or the group of the message
+	Dest     string `json:"dest"`
+	Group    bool   `json:"group,omitempty"`
+	Type     int32  `json:"type"`
+	SendTime int64  `json:"send_time"`
+	Recalled bool   `json:"recalled,omitempty"`
+}
+
+// HistoryStore keeps the records of the messages sent
+type HistoryStore interface {
+	Add(record *Record) error
+	Get(messageID int64) (*Record, error)
+	Update(record *Record) error
+	// List returns the records of the messages sent to dest with id in
+	// (since, until] in ascending order
+	List(dest string, group bool, since, until int64) ([]*Record, error)
+}
+
+// MemoryHistoryStore is a HistoryStore in memory, a record is dropped once
+// it is older than the ttl.
+type MemoryHistoryStore struct {
+	sync.RWMutex
+	ttl     time.Duration
+	records map[int64]*Record
+}
+
+// NewMemoryHistoryStore NewMemoryHistoryStore
+func NewMemoryHistoryStore(ttl time.Duration) HistoryStore {
+	return &MemoryHistoryStore{
+		ttl:     ttl,
+		records: make(map[int64]*Record),
+	}
+}
+
+// Add Add
+func (m *MemoryHistoryStore) Add(record *Record) error {
+	m.Lock()
+	defer m.Unlock()
+	r := *record
+	m.records[r.MessageID] = &r
+	if len(m.records)%1024 == 0 {
+		m.expireLocked()
+	}
+	return nil
+}
+
+// Get Get
+func (m *MemoryHistoryStore) Get(messageID int64) (*Record, error) {
+	m.RLock()
+	defer m.RUnlock()
+	r, ok := m.records[messageID]
+	if !ok || m.expired(r) {
+		return nil, ErrMessageNotFound
+	}
+	record := *r
+	return &record, nil
+}
+
+// Update Update
+func (m *MemoryHistoryStore) Update(record *Record) error {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.records[record.MessageID]; !ok {
+		return ErrMessageNotFound
+	}
+	r := *record
+	m.records[r.MessageID] = &r
+	return nil
+}
+
+// List List
+func (m *MemoryHistoryStore) List(dest string, group bool, since, until int64) ([]*Record, error) {
+	m.RLock()
+	defer m.RUnlock()
+	var records []*Record
+	for id, r := range m.records {
+		if id <= since || id > until || r.Dest != dest || r.Group != group || m.expired(r) {
+			continue
+		}
+		record := *r
+		records = append(records, &record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].MessageID < records[j].MessageID
+	})
+	return records, nil
+}
+
+func (m *MemoryHistoryStore) expired(r *Record) bool {
+	return time.Since(time.UnixMilli(r.SendTime)) > m.ttl
+}
+
+func (m *MemoryHistoryStore) expireLocked() {
+	for id, r := range m.records {
+		if m.expired(r) {
+			delete(m.records, id)
+		}
+	}
+}
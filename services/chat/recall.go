@@ -0,0 +1,259 @@
+package chat
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"dim"
+	"dim/idgen"
+	"dim/logger"
+	"dim/router"
+	"dim/wire/pkt"
+)
+
+// commands of recall and edit
+const (
+	CommandRecall = "chat.message.recall"
+	CommandEdit   = "chat.message.edit"
+)
+
+// errors of recall and edit
+var (
+	ErrNotSender     = errors.New("not the sender of the message")
+	ErrWindowExpired = errors.New("time window of the message expired")
+	ErrRecalled      = errors.New("message is recalled")
+)
+
+// RecallReq RecallReq
+type RecallReq struct {
+	MessageID int64 `json:"message_id"`
+}
+
+// EditReq EditReq
+type EditReq struct {
+	MessageID int64  `json:"message_id"`
+	Body      string `json:"body"`
+	Extra     string `json:"extra,omitempty"`
+}
+
+// RecallOptions RecallOptions
+type RecallOptions struct {
+	// RecallWindow is the time a message can be recalled in, default 2m
+	RecallWindow time.Duration
+	// EditWindow is the time a message can be edited in, default 24h
+	EditWindow time.Duration
+	// WriteDiffusionLimit should be the same as the GroupOptions
+	WriteDiffusionLimit int
+}
+
+// RecallHandler recalls and edits the messages recorded in the history by
+// Handler and GroupHandler. The stored message is updated, and an event
+// with the message as Target is pushed to the online channels of the
+// participants and stored like a message, so the devices offline converge
+// once they pull. The recall and the edits of a message are serialized by
+// the lock of its id, so a message recalled is never changed by an edit
+// validated before, the commands should be served by one instance.
+type RecallHandler struct {
+	history HistoryStore
+	store   dim.MessageStore
+	groups  GroupStore
+	gen     idgen.Generator
+	options RecallOptions
+	locks   [64]sync.Mutex
+
+	conversations dim.ConversationStore
+}
+
+// NewRecallHandler NewRecallHandler
+func NewRecallHandler(history HistoryStore, store dim.MessageStore, groups GroupStore, gen idgen.Generator, opts RecallOptions) *RecallHandler {
+	if opts.RecallWindow == 0 {
+		opts.RecallWindow = time.Minute * 2
+	}
+	if opts.EditWindow == 0 {
+		opts.EditWindow = time.Hour * 24
+	}
+	if opts.WriteDiffusionLimit <= 0 {
+		opts.WriteDiffusionLimit = DefaultWriteDiffusionLimit
+	}
+	return &RecallHandler{
+		history: history,
+		store:   store,
+		groups:  groups,
+		gen:     gen,
+		options: opts,
+	}
+}
+
+// SetConversations updates the preview of the conversations whose last
+// message is recalled or edited
+func (h *RecallHandler) SetConversations(conversations dim.ConversationStore) {
+	h.conversations = conversations
+}
+
+// Register the handlers of the commands to r
+func (h *RecallHandler) Register(r *router.Router) {
+	r.Handle(CommandRecall, h.DoRecall)
+	r.Handle(CommandEdit, h.DoEdit)
+}
+
+// DoRecall DoRecall
+func (h *RecallHandler) DoRecall(ctx router.Context) {
+	var req RecallReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	unlock := h.lock(req.MessageID)
+	defer unlock()
+	record, ok := h.validate(ctx, req.MessageID, h.options.RecallWindow)
+	if !ok {
+		return
+	}
+	h.apply(ctx, record, &MessagePush{Recalled: true})
+}
+
+// DoEdit DoEdit
+func (h *RecallHandler) DoEdit(ctx router.Context) {
+	var req EditReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	unlock := h.lock(req.MessageID)
+	defer unlock()
+	record, ok := h.validate(ctx, req.MessageID, h.options.EditWindow)
+	if !ok {
+		return
+	}
+	h.apply(ctx, record, &MessagePush{
+		Body:     req.Body,
+		Extra:    req.Extra,
+		EditTime: time.Now().UnixMilli(),
+	})
+}
+
+// lock the message until the returned unlock is called
+func (h *RecallHandler) lock(messageID int64) (unlock func()) {
+	l := &h.locks[uint64(messageID)%uint64(len(h.locks))]
+	l.Lock()
+	return l.Unlock
+}
+
+// validate the sender and the window of the message
+func (h *RecallHandler) validate(ctx router.Context, messageID int64, window time.Duration) (*Record, bool) {
+	record, err := h.history.Get(messageID)
+	if err != nil {
+		if err == ErrMessageNotFound {
+			_ = ctx.RespWithError(pkt.NotFound, err)
+		} else {
+			_ = ctx.RespWithError(pkt.SystemException, err)
+		}
+		return nil, false
+	}
+	if record.Sender != ctx.Session().Account {
+		_ = ctx.RespWithError(pkt.Unauthorized, ErrNotSender)
+		return nil, false
+	}
+	if record.Recalled {
+		_ = ctx.RespWithError(pkt.Forbidden, ErrRecalled)
+		return nil, false
+	}
+	if time.Since(time.UnixMilli(record.SendTime)) > window {
+		_ = ctx.RespWithError(pkt.Forbidden, ErrWindowExpired)
+		return nil, false
+	}
+	return record, true
+}
+
+// apply the change to the stored message, and pushes and stores the event
+func (h *RecallHandler) apply(ctx router.Context, record *Record, change *MessagePush) {
+	// the stored message
+	change.MessageID = record.MessageID
+	change.Sender = record.Sender
+	change.Type = record.Type
+	change.SendTime = record.SendTime
+	if record.Group {
+		change.Group = record.Dest
+	}
+	if err := h.store.Update(record.MessageID, encodeContent(change)); err != nil {
+		_ = ctx.RespWithError(pkt.SystemException, err)
+		return
+	}
+	if change.Recalled {
+		record.Recalled = true
+		if err := h.history.Update(record); err != nil {
+			_ = ctx.RespWithError(pkt.SystemException, err)
+			return
+		}
+	}
+	if h.conversations != nil {
+		h.preview(ctx, record, change)
+	}
+
+	id, err := h.gen.Next()
+	if err != nil {
+		_ = ctx.RespWithError(pkt.SystemException, err)
+		return
+	}
+	command := ctx.Header().Command
+	event := *change
+	event.MessageID = id
+	event.Target = record.MessageID
+	event.SendTime = time.Now().UnixMilli()
+
+	participants := []string{record.Sender, record.Dest}
+	msg := toMessage(command, &event)
+	if record.Group {
+		participants, err = h.groups.Members(record.Dest)
+		if err == nil {
+			err = diffuse(h.store, msg, record.Dest, participants, len(participants), h.options.WriteDiffusionLimit)
+		}
+	} else {
+		err = h.store.Insert(msg, participants...)
+	}
+	if err != nil {
+		_ = ctx.RespWithError(pkt.SystemException, err)
+		return
+	}
+
+	locs, err := ctx.GetLocations(participants...)
+	if err == nil {
+		err = ctx.DispatchMessage(id, &event, exclude(locs, ctx.Session().ChannelID)...)
+	}
+	if err != nil {
+		ctx.Logger().WithField("module", "chat.recall").Warnf("dispatch %d failed: %v", id, err)
+	}
+	_ = ctx.Resp(pkt.Success, nil)
+}
+
+// preview sets the preview of the conversations of the message changed, the
+// conversation of a one-to-one message is the peer of each side.
+func (h *RecallHandler) preview(ctx router.Context, record *Record, change *MessagePush) {
+	c := &dim.Conversation{
+		ID:            record.Dest,
+		Group:         record.Group,
+		LastMessageID: record.MessageID,
+		Preview:       preview(change.Body),
+	}
+	var err error
+	if record.Group {
+		// the members of a group using read diffusion list the shared
+		// conversation of the timeline
+		var members []string
+		if members, err = h.groups.Members(record.Dest); err == nil && len(members) > h.options.WriteDiffusionLimit {
+			err = h.conversations.SetPreview(c, record.Sender, timeline(record.Dest))
+		} else if err == nil {
+			err = h.conversations.SetPreview(c, append(members, timeline(record.Dest))...)
+		}
+	} else if err = h.conversations.SetPreview(c, record.Sender); err == nil {
+		c.ID = record.Sender
+		err = h.conversations.SetPreview(c, record.Dest)
+	}
+	if err != nil {
+		ctx.Logger().WithFields(logger.Fields{
+			"module":  "chat.recall",
+			"message": record.MessageID,
+		}).Warnf("set preview failed: %v", err)
+	}
+}
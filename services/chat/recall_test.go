@@ -0,0 +1,136 @@
+package chat
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"dim"
+	"dim/router/routertest"
+	"dim/wire/pkt"
+)
+
+func TestRecall(t *testing.T) {
+	e := newEnv(t)
+	e.loginDevice("u1#ios")
+	e.loginDevice("u1#web")
+	e.login("u2")
+
+	var talk TalkResp
+	_ = e.serve("u1#ios", CommandUserTalk, &TalkReq{Dest: "u2", Body: "oops"}).ReadBody(&talk)
+	e.disp.Pushes = nil
+
+	if resp := e.call("u2", CommandRecall, &RecallReq{MessageID: talk.MessageID}); resp.Status != pkt.Unauthorized {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if resp := e.serve("u1#ios", CommandRecall, &RecallReq{MessageID: 404}); resp.Status != pkt.NotFound {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if resp := e.serve("u1#ios", CommandRecall, &RecallReq{MessageID: talk.MessageID}); resp.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	// pushed to the peer and the other device of the sender
+	channels := map[string]bool{}
+	for _, p := range e.disp.Pushes {
+		for _, ch := range p.Channels {
+			channels[ch] = true
+		}
+		var push MessagePush
+		_ = p.Packet.ReadBody(&push)
+		if !push.Recalled || push.Target != talk.MessageID || p.Packet.Command != CommandRecall {
+			t.Fatalf("unexpected push %+v", push)
+		}
+	}
+	if len(channels) != 2 || !channels["u2"] || !channels["u1#web"] {
+		t.Fatalf("unexpected channels %v", channels)
+	}
+	if resp := e.serve("u1#ios", CommandEdit, &EditReq{MessageID: talk.MessageID, Body: "edit"}); resp.Status != pkt.Forbidden {
+		t.Fatalf("unexpected response %s", resp)
+	}
+
+	// the offline peer pulls the message recalled and the event
+	var pull PullResp
+	_ = e.call("u2", CommandOfflinePull, &PullReq{}).ReadBody(&pull)
+	if len(pull.Messages) != 2 || !pull.Messages[0].Recalled || pull.Messages[0].Body != "" ||
+		pull.Messages[1].Target != talk.MessageID || pull.Messages[1].Command != CommandRecall {
+		t.Fatalf("unexpected pull %+v", pull)
+	}
+	// the preview of the last message is cleared on both sides
+	for _, account := range []string{"u1", "u2"} {
+		var list ConversationListResp
+		_ = e.call(account, CommandConversationList, &ConversationListReq{}).ReadBody(&list)
+		if len(list.Conversations) != 1 || list.Conversations[0].Preview != "" {
+			t.Fatalf("unexpected conversations %+v of %s", list.Conversations, account)
+		}
+	}
+}
+
+func TestEdit(t *testing.T) {
+	e := newEnv(t)
+	e.login("u1")
+
+	var created GroupCreateResp
+	_ = e.call("u1", CommandGroupCreate, &GroupCreateReq{Name: "g", Members: []string{"u2"}}).ReadBody(&created)
+	var talk TalkResp
+	_ = e.call("u1", CommandGroupTalk, &GroupTalkReq{GroupID: created.GroupID, Body: "helo"}).ReadBody(&talk)
+
+	// out of the recall window, but in the edit window
+	time.Sleep(time.Millisecond * 150)
+	if resp := e.call("u1", CommandRecall, &RecallReq{MessageID: talk.MessageID}); resp.Status != pkt.Forbidden {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if resp := e.call("u1", CommandEdit, &EditReq{MessageID: talk.MessageID, Body: "hello"}); resp.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", resp)
+	}
+
+	var pull PullResp
+	_ = e.call("u2", CommandOfflinePull, &PullReq{}).ReadBody(&pull)
+	if len(pull.Messages) != 2 || pull.Messages[0].Body != "hello" || pull.Messages[0].EditTime == 0 {
+		t.Fatalf("unexpected pull %+v", pull)
+	}
+	if event := pull.Messages[1]; event.Group != created.GroupID || event.Target != talk.MessageID || event.Body != "hello" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	var list ConversationListResp
+	_ = e.call("u2", CommandConversationList, &ConversationListReq{}).ReadBody(&list)
+	if len(list.Conversations) != 1 || list.Conversations[0].Preview != "hello" {
+		t.Fatalf("unexpected conversations %+v", list.Conversations)
+	}
+}
+
+func TestRecallEditRace(t *testing.T) {
+	e := newEnv(t)
+	e.login("u1")
+
+	session := &dim.Session{ChannelID: "u1", GateID: "gateway01", Account: "u1"}
+	for i := 0; i < 20; i++ {
+		var talk TalkResp
+		_ = e.call("u1", CommandUserTalk, &TalkReq{Dest: "u2", Body: "hi"}).ReadBody(&talk)
+
+		var wg sync.WaitGroup
+		statuses := make([]pkt.Status, 2)
+		for j, p := range []*pkt.LogicPkt{
+			pkt.New(CommandEdit, pkt.WithChannel("u1")).WriteBody(&EditReq{MessageID: talk.MessageID, Body: "edit"}),
+			pkt.New(CommandRecall, pkt.WithChannel("u1")).WriteBody(&RecallReq{MessageID: talk.MessageID}),
+		} {
+			wg.Add(1)
+			go func(j int, p *pkt.LogicPkt) {
+				defer wg.Done()
+				gw := routertest.NewGateway("gateway01")
+				_ = e.router.Serve(gw, p, session, e.disp, e.sessions)
+				statuses[j] = gw.Last().Status
+			}(j, p)
+		}
+		wg.Wait()
+		if statuses[1] != pkt.Success {
+			t.Fatalf("unexpected status %d of recall", statuses[1])
+		}
+
+		// recalled whether it is edited before or not
+		var pull PullResp
+		_ = e.call("u2", CommandOfflinePull, &PullReq{Since: talk.MessageID - 1, Limit: 1}).ReadBody(&pull)
+		if len(pull.Messages) != 1 || !pull.Messages[0].Recalled || pull.Messages[0].Body != "" {
+			t.Fatalf("unexpected pull %+v", pull)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package chat
+
+import (
+	"sync"
+	"time"
+
+	"dim"
+	"dim/router"
+	"dim/wire/pkt"
+)
+
+// commands of receipts and signals
+const (
+	CommandRead   = "chat.read"
+	CommandTyping = "chat.typing"
+)
+
+// ReadReq marks the conversation with Dest, an account or a group, read up
+// to MessageID
+type ReadReq struct {
+	Dest      string `json:"dest"`
+	Group     bool   `json:"group,omitempty"`
+	MessageID int64  `json:"message_id"`
+}
+
+// ReadPush is pushed to the peer and the other devices of the reader
+type ReadPush struct {
+	Reader    string `json:"reader"`
+	Dest      string `json:"dest"`
+	Group     bool   `json:"group,omitempty"`
+	MessageID int64  `json:"message_id"`
+	ReadTime  int64  `json:"read_time"`
+}
+
+// TypingReq TypingReq
+type TypingReq struct {
+	Dest string `json:"dest"`
+}
+
+// TypingPush TypingPush
+type TypingPush struct {
+	Sender string `json:"sender"`
+}
+
+// ReadStore stores the last message read of the conversations of an account
+type ReadStore interface {
+	// SetRead moves the position forward, a smaller messageID is ignored
+	SetRead(account, conversation string, messageID int64) error
+	GetRead(account, conversation string) (int64, error)
+}
+
+// MemoryReadStore is a ReadStore in memory
+type MemoryReadStore struct {
+	sync.RWMutex
+	reads map[string]map[string]int64
+}
+
+// NewMemoryReadStore NewMemoryReadStore
+func NewMemoryReadStore() ReadStore {
+	return &MemoryReadStore{reads: make(map[string]map[string]int64)}
+}
+
+// SetRead SetRead
+func (m *MemoryReadStore) SetRead(account, conversation string, messageID int64) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.reads[account] == nil {
+		m.reads[account] = make(map[string]int64)
+	}
+	if messageID > m.reads[account][conversation] {
+		m.reads[account][conversation] = messageID
+	}
+	return nil
+}
+
+// GetRead GetRead
+func (m *MemoryReadStore) GetRead(account, conversation string) (int64, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.reads[account][conversation], nil
+}
+
+// ReceiptOptions ReceiptOptions
+type ReceiptOptions struct {
+	// TypingInterval is the min interval of the typing events of a channel,
+	// the events in the interval are dropped, default 1s
+	TypingInterval time.Duration
+}
+
+// ReceiptHandler handles the read receipts and the typing signals, a typing
+// signal is pushed to the peer but never stored or responded.
+type ReceiptHandler struct {
+	sync.Mutex
+	reads         ReadStore
+	history       HistoryStore
+	conversations dim.ConversationStore
+	options       ReceiptOptions
+	typing        map[string]time.Time
+}
+
+// NewReceiptHandler NewReceiptHandler
+func NewReceiptHandler(reads ReadStore, opts ReceiptOptions) *ReceiptHandler {
+	if opts.TypingInterval == 0 {
+		opts.TypingInterval = time.Second
+	}
+	return &ReceiptHandler{
+		reads:   reads,
+		options: opts,
+		typing:  make(map[string]time.Time),
+	}
+}
+
+// SetHistory notifies the senders of the messages read in a group, they are
+// found in the records of the history
+func (h *ReceiptHandler) SetHistory(history HistoryStore) {
+	h.history = history
+}
+
+// SetConversations moves the read position of a conversation once read
+func (h *ReceiptHandler) SetConversations(conversations dim.ConversationStore) {
+	h.conversations = conversations
+}
+
+// Register the handlers of the commands to r
+func (h *ReceiptHandler) Register(r *router.Router) {
+	r.Handle(CommandRead, h.DoRead)
+	r.Handle(CommandTyping, h.DoTyping)
+}
+
+// DoRead stores the read position of the receipts and of the conversation,
+// the messages after it are still unread, and pushes it to the other devices of the reader, and to the
+// devices of the peer of a one-to-one conversation or the senders of the
+// messages newly read in a group.
+func (h *ReceiptHandler) DoRead(ctx router.Context) {
+	var req ReadReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, err)
+		return
+	}
+	if req.Dest == "" {
+		_ = ctx.RespWithError(pkt.InvalidPacketBody, ErrNoDest)
+		return
+	}
+	session := ctx.Session()
+	conversation := Conversation(req.Dest, req.Group)
+	last, err := h.reads.GetRead(session.Account, conversation)
+	if err == nil {
+		err = h.reads.SetRead(session.Account, conversation, req.MessageID)
+	}
+	if err != nil {
+		_ = ctx.RespWithError(pkt.SystemException, err)
+		return
+	}
+	if h.conversations != nil {
+		err = h.conversations.SetRead(session.Account, req.Dest, req.Group, req.MessageID)
+		if err != nil && err != dim.ErrConversationNotFound {
+			_ = ctx.RespWithError(pkt.SystemException, err)
+			return
+		}
+	}
+	accounts := []string{session.Account}
+	if !req.Group {
+		accounts = append(accounts, req.Dest)
+	} else if h.history != nil && req.MessageID > last {
+		accounts = append(accounts, h.senders(req.Dest, last, req.MessageID, session.Account)...)
+	}
+	if locs, err := ctx.GetLocations(accounts...); err == nil {
+		_ = ctx.Dispatch(&ReadPush{
+			Reader:    session.Account,
+			Dest:      req.Dest,
+			Group:     req.Group,
+			MessageID: req.MessageID,
+			ReadTime:  time.Now().UnixMilli(),
+		}, exclude(locs, session.ChannelID)...)
+	}
+	_ = ctx.Resp(pkt.Success, nil)
+}
+
+// senders returns the senders of the messages of a group in (since, until]
+// except the reader
+func (h *ReceiptHandler) senders(groupID string, since, until int64, reader string) []string {
+	records, err := h.history.List(groupID, true, since, until)
+	if err != nil {
+		return nil
+	}
+	var senders []string
+	seen := map[string]bool{reader: true}
+	for _, r := range records {
+		if !seen[r.Sender] {
+			seen[r.Sender] = true
+			senders = append(senders, r.Sender)
+		}
+	}
+	return senders
+}
+
+// DoTyping DoTyping
+func (h *ReceiptHandler) DoTyping(ctx router.Context) {
+	var req TypingReq
+	if err := ctx.ReadBody(&req); err != nil || req.Dest == "" {
+		return
+	}
+	session := ctx.Session()
+	if !h.allow(session.ChannelID) {
+		return
+	}
+	if locs, err := ctx.GetLocations(req.Dest); err == nil {
+		_ = ctx.DispatchEphemeral(&TypingPush{Sender: session.Account}, locs...)
+	}
+}
+
+// allow returns false if the last typing of the channel is in the interval
+func (h *ReceiptHandler) allow(channelID string) bool {
+	h.Lock()
+	defer h.Unlock()
+	now := time.Now()
+	if last, ok := h.typing[channelID]; ok && now.Sub(last) < h.options.TypingInterval {
+		return false
+	}
+	h.typing[channelID] = now
+	// drop the channels expired, so the map doesn't grow with the channels
+	if len(h.typing) > 1024 {
+		for id, last := range h.typing {
+			if now.Sub(last) >= h.options.TypingInterval {
+				delete(h.typing, id)
+			}
+		}
+	}
+	return true
+}
+
+// Conversation returns the id of the conversation with an account or a group
+func Conversation(dest string, group bool) string {
+	if group {
+		return timeline(dest)
+	}
+	return dest
+}
+
+func exclude(locs []*dim.Location, channelID string) []*dim.Location {
+	result := locs[:0]
+	for _, loc := range locs {
+		if loc.ChannelID != channelID {
+			result = append(result, loc)
+		}
+	}
+	return result
+}
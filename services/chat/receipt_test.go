@@ -0,0 +1,124 @@
+package chat
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"dim"
+	"dim/router"
+	"dim/wire/pkt"
+)
+
+// loginDevice adds the session of a channel of a device, e.g. u1#ios
+func (e *env) loginDevice(channelID string) {
+	account, device := dim.ParseChannelID(channelID)
+	_ = e.sessions.Add(&dim.Session{ChannelID: channelID, GateID: "gateway01", Account: account, Device: device})
+}
+
+// serve command on a channel of a device, and returns the response if any
+func (e *env) serve(channelID, command string, body interface{}) *pkt.LogicPkt {
+	e.t.Helper()
+	p := pkt.New(command, pkt.WithChannel(channelID)).WriteBody(body)
+	account, device := dim.ParseChannelID(channelID)
+	session := &dim.Session{ChannelID: channelID, GateID: "gateway01", Account: account, Device: device}
+	if err := e.router.Serve(e.gw, p, session, e.disp, e.sessions); err != nil {
+		e.t.Fatal(err)
+	}
+	resp := e.gw.Last()
+	e.gw.Take()
+	return resp
+}
+
+func (e *env) pushedTo() []string {
+	var channels []string
+	for _, p := range e.disp.Take() {
+		channels = append(channels, p.Channels...)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+func TestRead(t *testing.T) {
+	e := newEnv(t)
+	e.loginDevice("u1#ios")
+	e.loginDevice("u1#web")
+	e.login("u2")
+
+	if resp := e.serve("u1#ios", CommandRead, &ReadReq{Dest: "u2", MessageID: 10}); resp == nil || resp.Status != pkt.Success {
+		t.Fatalf("unexpected response %s", resp)
+	}
+	if got := e.pushedTo(); len(got) != 2 || got[0] != "u1#web" || got[1] != "u2" {
+		t.Fatalf("unexpected pushes %v", got)
+	}
+	var push ReadPush
+	e.serve("u1#web", CommandRead, &ReadReq{Dest: "u2", MessageID: 5})
+	_ = e.disp.Pushes[0].Packet.ReadBody(&push)
+	if push.Reader != "u1" || push.MessageID != 5 || e.disp.Pushes[0].Packet.Command != CommandRead {
+		t.Fatalf("unexpected push %+v", push)
+	}
+	e.disp.Pushes = nil
+
+	// only the other devices of the reader of a group without messages
+	e.serve("u1#web", CommandRead, &ReadReq{Dest: "g1", Group: true, MessageID: 5})
+	if got := e.pushedTo(); len(got) != 1 || got[0] != "u1#ios" {
+		t.Fatalf("unexpected pushes %v", got)
+	}
+}
+
+func TestReadGroup(t *testing.T) {
+	e := newEnv(t)
+	e.login("u1")
+	e.loginDevice("u2#ios")
+	e.loginDevice("u2#web")
+	e.login("u3")
+
+	var created GroupCreateResp
+	_ = e.call("u1", CommandGroupCreate, &GroupCreateReq{Name: "g", Members: []string{"u2", "u3"}}).ReadBody(&created)
+	var talks []TalkResp
+	for _, sender := range []string{"u2", "u1", "u2"} {
+		var talk TalkResp
+		_ = e.call(sender, CommandGroupTalk, &GroupTalkReq{GroupID: created.GroupID, Body: "hi"}).ReadBody(&talk)
+		talks = append(talks, talk)
+	}
+	e.disp.Take()
+
+	// the senders of the messages read are notified on every device
+	e.serve("u3", CommandRead, &ReadReq{Dest: created.GroupID, Group: true, MessageID: talks[1].MessageID})
+	if got := e.pushedTo(); len(got) != 3 || got[0] != "u1" || got[1] != "u2#ios" || got[2] != "u2#web" {
+		t.Fatalf("unexpected pushes %v", got)
+	}
+	// only the messages newly read
+	e.serve("u3", CommandRead, &ReadReq{Dest: created.GroupID, Group: true, MessageID: talks[2].MessageID})
+	if got := e.pushedTo(); len(got) != 2 || got[0] != "u2#ios" || got[1] != "u2#web" {
+		t.Fatalf("unexpected pushes %v", got)
+	}
+}
+
+func TestTyping(t *testing.T) {
+	e := newEnv(t)
+	e.login("u2")
+
+	for i := 0; i < 3; i++ {
+		if resp := e.serve("u1", CommandTyping, &TypingReq{Dest: "u2"}); resp != nil {
+			t.Fatalf("unexpected response %s", resp)
+		}
+	}
+	// not stored if u2 is not connected
+	if _, ok := e.disp.Pushes[0].Packet.GetMeta(router.MetaEphemeral); !ok {
+		t.Fatal("typing is not ephemeral")
+	}
+	if got := e.pushedTo(); len(got) != 1 || got[0] != "u2" {
+		t.Fatalf("unexpected pushes %v", got)
+	}
+	// another channel is limited alone
+	e.serve("u3", CommandTyping, &TypingReq{Dest: "u2"})
+	if got := e.pushedTo(); len(got) != 1 {
+		t.Fatalf("unexpected pushes %v", got)
+	}
+	time.Sleep(time.Millisecond * 60)
+	e.serve("u1", CommandTyping, &TypingReq{Dest: "u2"})
+	if got := e.pushedTo(); len(got) != 1 {
+		t.Fatalf("unexpected pushes %v", got)
+	}
+}
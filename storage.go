@@ -0,0 +1,141 @@
+package dim
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrSessionNil is returned by a SessionStore if the session is not found
+var ErrSessionNil = errors.New("err:session nil")
+
+// Session is the login session of a channel
+type Session struct {
+	ChannelID string `json:"channel_id"`
+	GateID    string `json:"gate_id"`
+	Account   string `json:"account"`
+	Device    string `json:"device"`
+	App       string `json:"app"`
+	RemoteIP  string `json:"remote_ip"`
+	LoginAt   int64  `json:"login_at"`
+}
+
+// ChannelSeparator separates the account and the device in a channel id
+const ChannelSeparator = "#"
+
+// ChannelID returns the id of the channel of account on device, so an
+// account is connected once on each device. It is the account if device is
+// empty.
+func ChannelID(account, device string) string {
+	if device == "" {
+		return account
+	}
+	return account + ChannelSeparator + device
+}
+
+// ParseChannelID returns the account and the device of a channel id
+func ParseChannelID(channelID string) (account, device string) {
+	if i := strings.LastIndex(channelID, ChannelSeparator); i >= 0 {
+		return channelID[:i], channelID[i+1:]
+	}
+	return channelID, ""
+}
+
+// Location is the gateway and channel an account is connected on
+type Location struct {
+	Account   string `json:"account"`
+	ChannelID string `json:"channel_id"`
+	GateID    string `json:"gate_id"`
+}
+
+// SessionStore stores the sessions of all gateways, so that a logic
+// service can find the gateway to push to.
+type SessionStore interface {
+	Add(session *Session) error
+	// Delete the session of channelID only if it is added by gateID, so that
+	// the session of a channel logged in again on another gateway is kept
+	Delete(account, channelID, gateID string) error
+	Get(channelID string) (*Session, error)
+	// GetLocations returns the locations of every device of the accounts
+	GetLocations(accounts ...string) ([]*Location, error)
+}
+
+// Message is a message stored in the inboxes of its receivers
+type Message struct {
+	ID       int64  `json:"id"`
+	Command  string `json:"command"`
+	Sender   string `json:"sender"`
+	Body     []byte `json:"body"`
+	SendTime int64  `json:"send_time"`
+}
+
+// MessageStore stores the messages of accounts not connected, a message is
+// stored once and indexed by the inbox of every receiver, the id of a
+// message is generated by the caller, e.g. by a snowflake, so the messages
+// of an inbox are ordered by id.
+type MessageStore interface {
+	Insert(msg *Message, receivers ...string) error
+	// Fetch returns at most limit messages of the inbox of account with id
+	// greater than since in ascending order
+	Fetch(account string, since int64, limit int) ([]*Message, error)
+	// Ack moves the cursor of the device of account to id, device is empty
+	// if the account is connected once. The messages of the inbox not after
+	// the cursors of all the devices are removed, so a message acked on a
+	// device is still fetched by the others. The cursor of a device not
+	// acking for a while is expired, so an idle device doesn't keep the
+	// messages forever.
+	Ack(account, device string, id int64) error
+	// Cursor returns the id of the last message acked on the device of
+	// account, it is 0 if the device has not acked any.
+	Cursor(account, device string) (int64, error)
+	// Update the body of a message not acked by all receivers, it does
+	// nothing if the message is not stored
+	Update(id int64, body []byte) error
+}
+
+// ErrConversationNotFound is returned by a ConversationStore if the
+// conversation is not found
+var ErrConversationNotFound = errors.New("err:conversation not found")
+
+// Conversation is a conversation of an account with a peer or a group
+type Conversation struct {
+	// ID is the peer account or the group id
+	ID            string `json:"id"`
+	Group         bool   `json:"group,omitempty"`
+	LastMessageID int64  `json:"last_message_id"`
+	LastSender    string `json:"last_sender,omitempty"`
+	// Preview is the leading text of the last message
+	Preview    string `json:"preview,omitempty"`
+	UpdateTime int64  `json:"update_time"`
+	// ReadMessageID is the read position, Unread is the number of the
+	// messages after it
+	ReadMessageID int64 `json:"read_message_id,omitempty"`
+	Unread        int   `json:"unread"`
+	Pinned        bool  `json:"pinned,omitempty"`
+	Muted         bool  `json:"muted,omitempty"`
+}
+
+// ConversationStore is the conversation index of the accounts, a
+// conversation is keyed by its ID and Group in the index of an account.
+type ConversationStore interface {
+	// Touch sets the last message of the conversation c of the accounts,
+	// creating it if not exists, the flags, the read position and the unread
+	// count of c are ignored. The last message is counted unread if unread
+	// and it is after the read position. A last message older than the
+	// stored one is ignored, but still counted unread.
+	Touch(c *Conversation, unread bool, accounts ...string) error
+	// SetPreview sets the preview of the conversation c of the accounts if
+	// its last message is c.LastMessageID, e.g. once the message is recalled
+	// or edited, the other fields of c are ignored.
+	SetPreview(c *Conversation, accounts ...string) error
+	// List returns the conversations of account, the pinned first, and then
+	// the latest first, all of them if limit is 0
+	List(account string, offset, limit int) ([]*Conversation, error)
+	// SetRead moves the read position of the conversation of account to
+	// messageID, the messages not after it are no longer unread. A position
+	// before the stored one is ignored.
+	SetRead(account, id string, group bool, messageID int64) error
+	// ClearUnread moves the read position to the last message
+	ClearUnread(account, id string, group bool) error
+	SetPinned(account, id string, group bool, pinned bool) error
+	SetMuted(account, id string, group bool, muted bool) error
+}
@@ -0,0 +1,435 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"dim"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketMessages = []byte("messages")
+	bucketInboxes  = []byte("inboxes")
+	bucketCursors  = []byte("cursors")
+)
+
+type boltMessage struct {
+	dim.Message
+	Refs int `json:"refs"`
+}
+
+// BoltMessageStore is a MessageStore in a bbolt file, a message is stored in
+// the messages bucket with a reference count, and the inbox of an account is
+// a nested bucket of the inboxes bucket with the ids of its messages as keys.
+// The cursors of the devices of an account are kept in a nested bucket of the
+// cursors bucket, with the time of the last ack.
+type BoltMessageStore struct {
+	db  *bolt.DB
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewBoltMessageStore opens or creates the file of path
+func NewBoltMessageStore(path string) (*BoltMessageStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketMessages, bucketInboxes, bucketCursors} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltMessageStore{db: db, ttl: CursorExpired, now: time.Now}, nil
+}
+
+// SetCursorTTL sets the ttl of the cursor of a device not acking
+func (b *BoltMessageStore) SetCursorTTL(ttl time.Duration) {
+	b.ttl = ttl
+}
+
+// Close the file
+func (b *BoltMessageStore) Close() error {
+	return b.db.Close()
+}
+
+// Insert Insert
+func (b *BoltMessageStore) Insert(msg *dim.Message, receivers ...string) error {
+	key := idKey(msg.ID)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(bucketMessages)
+		stored := boltMessage{Message: *msg}
+		if v := messages.Get(key); v != nil {
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return err
+			}
+		}
+		inboxes := tx.Bucket(bucketInboxes)
+		added := 0
+		for _, account := range receivers {
+			inbox, err := inboxes.CreateBucketIfNotExists([]byte(account))
+			if err != nil {
+				return err
+			}
+			if inbox.Get(key) != nil {
+				continue
+			}
+			if err = inbox.Put(key, []byte{}); err != nil {
+				return err
+			}
+			added++
+		}
+		if added == 0 {
+			return nil
+		}
+		stored.Refs += added
+		v, err := json.Marshal(&stored)
+		if err != nil {
+			return err
+		}
+		return messages.Put(key, v)
+	})
+}
+
+// Fetch Fetch
+func (b *BoltMessageStore) Fetch(account string, since int64, limit int) ([]*dim.Message, error) {
+	var msgs []*dim.Message
+	err := b.db.View(func(tx *bolt.Tx) error {
+		inbox := tx.Bucket(bucketInboxes).Bucket([]byte(account))
+		if inbox == nil {
+			return nil
+		}
+		messages := tx.Bucket(bucketMessages)
+		c := inbox.Cursor()
+		for k, _ := c.Seek(idKey(since + 1)); k != nil && len(msgs) < limit; k, _ = c.Next() {
+			v := messages.Get(k)
+			if v == nil {
+				continue
+			}
+			var stored boltMessage
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return err
+			}
+			msg := stored.Message
+			msgs = append(msgs, &msg)
+		}
+		return nil
+	})
+	return msgs, err
+}
+
+// Ack Ack
+func (b *BoltMessageStore) Ack(account, device string, id int64) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		cursors, err := tx.Bucket(bucketCursors).CreateBucketIfNotExists([]byte(account))
+		if err != nil {
+			return err
+		}
+		now := b.now()
+		key := cursorKey(device)
+		if v := cursors.Get(key); v != nil {
+			if c := decodeCursor(v, now); id < c.id {
+				id = c.id
+			}
+		}
+		if err = cursors.Put(key, encodeCursor(cursor{id: id, at: now})); err != nil {
+			return err
+		}
+		all := make(map[string]int64)
+		var expired [][]byte
+		_ = cursors.ForEach(func(k, v []byte) error {
+			c := decodeCursor(v, now)
+			if now.Sub(c.at) > b.ttl {
+				expired = append(expired, append([]byte(nil), k...))
+				return nil
+			}
+			all[string(k)] = c.id
+			return nil
+		})
+		for _, k := range expired {
+			if err = cursors.Delete(k); err != nil {
+				return err
+			}
+		}
+		id = minCursor(all)
+
+		inboxes := tx.Bucket(bucketInboxes)
+		inbox := inboxes.Bucket([]byte(account))
+		if inbox == nil {
+			return nil
+		}
+		messages := tx.Bucket(bucketMessages)
+		var acked [][]byte
+		c := inbox.Cursor()
+		for k, _ := c.First(); k != nil && int64(binary.BigEndian.Uint64(k)) <= id; k, _ = c.Next() {
+			acked = append(acked, append([]byte(nil), k...))
+		}
+		for _, k := range acked {
+			if err := inbox.Delete(k); err != nil {
+				return err
+			}
+			if err := release(messages, k); err != nil {
+				return err
+			}
+		}
+		if k, _ := inbox.Cursor().First(); k == nil {
+			return inboxes.DeleteBucket([]byte(account))
+		}
+		return nil
+	})
+}
+
+// Cursor Cursor
+func (b *BoltMessageStore) Cursor(account, device string) (int64, error) {
+	var id int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		cursors := tx.Bucket(bucketCursors).Bucket([]byte(account))
+		if cursors == nil {
+			return nil
+		}
+		if v := cursors.Get(cursorKey(device)); v != nil {
+			id = decodeCursor(v, time.Time{}).id
+		}
+		return nil
+	})
+	return id, err
+}
+
+// Update Update
+func (b *BoltMessageStore) Update(id int64, body []byte) error {
+	key := idKey(id)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(bucketMessages)
+		v := messages.Get(key)
+		if v == nil {
+			return nil
+		}
+		var stored boltMessage
+		if err := json.Unmarshal(v, &stored); err != nil {
+			return err
+		}
+		stored.Body = body
+		v, err := json.Marshal(&stored)
+		if err != nil {
+			return err
+		}
+		return messages.Put(key, v)
+	})
+}
+
+// release decrements the reference count of the message of key
+func release(messages *bolt.Bucket, key []byte) error {
+	v := messages.Get(key)
+	if v == nil {
+		return nil
+	}
+	var stored boltMessage
+	if err := json.Unmarshal(v, &stored); err != nil {
+		return err
+	}
+	if stored.Refs--; stored.Refs <= 0 {
+		return messages.Delete(key)
+	}
+	v, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	return messages.Put(key, v)
+}
+
+// cursorKey is the key of the cursor of device, a key of bbolt can't be
+// empty as the device of an account connected once
+func cursorKey(device string) []byte {
+	return []byte("device:" + device)
+}
+
+// encodeCursor encodes the id and the time of c in 16 bytes
+func encodeCursor(c cursor) []byte {
+	v := make([]byte, 16)
+	binary.BigEndian.PutUint64(v, uint64(c.id))
+	binary.BigEndian.PutUint64(v[8:], uint64(c.at.UnixNano()))
+	return v
+}
+
+// decodeCursor decodes a cursor encoded by encodeCursor, a cursor stored
+// without the time is acked at now
+func decodeCursor(v []byte, now time.Time) cursor {
+	c := cursor{id: int64(binary.BigEndian.Uint64(v)), at: now}
+	if len(v) >= 16 {
+		c.at = time.Unix(0, int64(binary.BigEndian.Uint64(v[8:])))
+	}
+	return c
+}
+
+func idKey(id int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+var bucketConversations = []byte("conversations")
+
+// BoltConversationStore is a ConversationStore in a bbolt file, the index of
+// an account is a nested bucket of the conversations bucket with the json of
+// its conversations and the ids of their unread messages.
+type BoltConversationStore struct {
+	db *bolt.DB
+}
+
+// NewBoltConversationStore opens or creates the file of path
+func NewBoltConversationStore(path string) (*BoltConversationStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketConversations)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltConversationStore{db: db}, nil
+}
+
+// Close the file
+func (b *BoltConversationStore) Close() error {
+	return b.db.Close()
+}
+
+// Touch Touch
+func (b *BoltConversationStore) Touch(c *dim.Conversation, unread bool, accounts ...string) error {
+	key := []byte(conversationKey(c.ID, c.Group))
+	return b.db.Update(func(tx *bolt.Tx) error {
+		conversations := tx.Bucket(bucketConversations)
+		for _, account := range accounts {
+			index, err := conversations.CreateBucketIfNotExists([]byte(account))
+			if err != nil {
+				return err
+			}
+			var stored conversation
+			if v := index.Get(key); v != nil {
+				if err = json.Unmarshal(v, &stored); err != nil {
+					return err
+				}
+			}
+			touch(&stored, c, unread)
+			if err = putConversation(index, key, &stored); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetPreview SetPreview
+func (b *BoltConversationStore) SetPreview(c *dim.Conversation, accounts ...string) error {
+	key := []byte(conversationKey(c.ID, c.Group))
+	return b.db.Update(func(tx *bolt.Tx) error {
+		conversations := tx.Bucket(bucketConversations)
+		for _, account := range accounts {
+			index := conversations.Bucket([]byte(account))
+			if index == nil {
+				continue
+			}
+			v := index.Get(key)
+			if v == nil {
+				continue
+			}
+			var stored conversation
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return err
+			}
+			if stored.LastMessageID != c.LastMessageID {
+				continue
+			}
+			stored.Preview = c.Preview
+			if err := putConversation(index, key, &stored); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// List List
+func (b *BoltConversationStore) List(account string, offset, limit int) ([]*dim.Conversation, error) {
+	var list []*dim.Conversation
+	err := b.db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket(bucketConversations).Bucket([]byte(account))
+		if index == nil {
+			return nil
+		}
+		return index.ForEach(func(_, v []byte) error {
+			var c conversation
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			list = append(list, &c.Conversation)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page(list, offset, limit), nil
+}
+
+// SetRead SetRead
+func (b *BoltConversationStore) SetRead(account, id string, group bool, messageID int64) error {
+	return b.set(account, id, group, func(c *conversation) { c.read(messageID) })
+}
+
+// ClearUnread ClearUnread
+func (b *BoltConversationStore) ClearUnread(account, id string, group bool) error {
+	return b.set(account, id, group, func(c *conversation) { c.read(c.LastMessageID) })
+}
+
+// SetPinned SetPinned
+func (b *BoltConversationStore) SetPinned(account, id string, group bool, pinned bool) error {
+	return b.set(account, id, group, func(c *conversation) { c.Pinned = pinned })
+}
+
+// SetMuted SetMuted
+func (b *BoltConversationStore) SetMuted(account, id string, group bool, muted bool) error {
+	return b.set(account, id, group, func(c *conversation) { c.Muted = muted })
+}
+
+func (b *BoltConversationStore) set(account, id string, group bool, fn func(c *conversation)) error {
+	key := []byte(conversationKey(id, group))
+	return b.db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(bucketConversations).Bucket([]byte(account))
+		if index == nil {
+			return dim.ErrConversationNotFound
+		}
+		v := index.Get(key)
+		if v == nil {
+			return dim.ErrConversationNotFound
+		}
+		var c conversation
+		if err := json.Unmarshal(v, &c); err != nil {
+			return err
+		}
+		fn(&c)
+		return putConversation(index, key, &c)
+	})
+}
+
+func putConversation(index *bolt.Bucket, key []byte, c *conversation) error {
+	v, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return index.Put(key, v)
+}
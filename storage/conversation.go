@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+
+	"dim"
+)
+
+// maxUnreadIDs is the max number of the unread messages kept by their ids
+// in a conversation, the earlier ones are only counted
+const maxUnreadIDs = 1000
+
+// conversation is a stored conversation with the ids of its unread messages
+type conversation struct {
+	dim.Conversation
+	UnreadIDs []int64 `json:"unread_ids,omitempty"`
+}
+
+// MemoryConversationStore is a ConversationStore in memory
+type MemoryConversationStore struct {
+	sync.RWMutex
+	// account -> key -> conversation
+	conversations map[string]map[string]*conversation
+}
+
+// NewMemoryConversationStore NewMemoryConversationStore
+func NewMemoryConversationStore() dim.ConversationStore {
+	return &MemoryConversationStore{
+		conversations: make(map[string]map[string]*conversation),
+	}
+}
+
+// Touch Touch
+func (m *MemoryConversationStore) Touch(c *dim.Conversation, unread bool, accounts ...string) error {
+	m.Lock()
+	defer m.Unlock()
+	key := conversationKey(c.ID, c.Group)
+	for _, account := range accounts {
+		index := m.conversations[account]
+		if index == nil {
+			index = make(map[string]*conversation)
+			m.conversations[account] = index
+		}
+		stored, ok := index[key]
+		if !ok {
+			stored = &conversation{}
+			index[key] = stored
+		}
+		touch(stored, c, unread)
+	}
+	return nil
+}
+
+// SetPreview SetPreview
+func (m *MemoryConversationStore) SetPreview(c *dim.Conversation, accounts ...string) error {
+	m.Lock()
+	defer m.Unlock()
+	key := conversationKey(c.ID, c.Group)
+	for _, account := range accounts {
+		if stored, ok := m.conversations[account][key]; ok && stored.LastMessageID == c.LastMessageID {
+			stored.Preview = c.Preview
+		}
+	}
+	return nil
+}
+
+// List List
+func (m *MemoryConversationStore) List(account string, offset, limit int) ([]*dim.Conversation, error) {
+	m.RLock()
+	list := make([]*dim.Conversation, 0, len(m.conversations[account]))
+	for _, c := range m.conversations[account] {
+		conversation := c.Conversation
+		list = append(list, &conversation)
+	}
+	m.RUnlock()
+	return page(list, offset, limit), nil
+}
+
+// SetRead SetRead
+func (m *MemoryConversationStore) SetRead(account, id string, group bool, messageID int64) error {
+	return m.set(account, id, group, func(c *conversation) { c.read(messageID) })
+}
+
+// ClearUnread ClearUnread
+func (m *MemoryConversationStore) ClearUnread(account, id string, group bool) error {
+	return m.set(account, id, group, func(c *conversation) { c.read(c.LastMessageID) })
+}
+
+// SetPinned SetPinned
+func (m *MemoryConversationStore) SetPinned(account, id string, group bool, pinned bool) error {
+	return m.set(account, id, group, func(c *conversation) { c.Pinned = pinned })
+}
+
+// SetMuted SetMuted
+func (m *MemoryConversationStore) SetMuted(account, id string, group bool, muted bool) error {
+	return m.set(account, id, group, func(c *conversation) { c.Muted = muted })
+}
+
+func (m *MemoryConversationStore) set(account, id string, group bool, fn func(c *conversation)) error {
+	m.Lock()
+	defer m.Unlock()
+	c, ok := m.conversations[account][conversationKey(id, group)]
+	if !ok {
+		return dim.ErrConversationNotFound
+	}
+	fn(c)
+	return nil
+}
+
+// touch sets the last message of c to stored
+func touch(stored *conversation, c *dim.Conversation, unread bool) {
+	if stored.ID == "" {
+		stored.ID, stored.Group = c.ID, c.Group
+	}
+	if c.LastMessageID > stored.LastMessageID {
+		stored.LastMessageID = c.LastMessageID
+		stored.LastSender = c.LastSender
+		stored.Preview = c.Preview
+		stored.UpdateTime = c.UpdateTime
+	}
+	if unread && c.LastMessageID > stored.ReadMessageID {
+		stored.unread(c.LastMessageID)
+	}
+}
+
+// unread counts the message of id unread
+func (c *conversation) unread(id int64) {
+	ids := c.UnreadIDs
+	i := sort.Search(len(ids), func(i int) bool { return ids[i] >= id })
+	if i < len(ids) && ids[i] == id {
+		return
+	}
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	if len(ids) > maxUnreadIDs {
+		ids = ids[1:]
+	}
+	c.UnreadIDs = ids
+	c.Unread++
+}
+
+// read moves the read position to id, the unread messages not kept by ids
+// are earlier than the ids kept, so they are read once a kept one is read
+func (c *conversation) read(id int64) {
+	if id <= c.ReadMessageID {
+		return
+	}
+	c.ReadMessageID = id
+	if id >= c.LastMessageID {
+		c.Unread, c.UnreadIDs = 0, nil
+		return
+	}
+	ids := c.UnreadIDs
+	n := sort.Search(len(ids), func(i int) bool { return ids[i] > id })
+	if n > 0 {
+		c.UnreadIDs = append([]int64(nil), ids[n:]...)
+		c.Unread = len(c.UnreadIDs)
+	}
+}
+
+// page sorts the conversations, the pinned first, and then the latest first
+func page(list []*dim.Conversation, offset, limit int) []*dim.Conversation {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Pinned != list[j].Pinned {
+			return list[i].Pinned
+		}
+		return list[i].LastMessageID > list[j].LastMessageID
+	})
+	if offset < 0 || offset >= len(list) {
+		return nil
+	}
+	list = list[offset:]
+	if limit > 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	return list
+}
+
+// conversationKey separates the conversations of the groups from the peers
+func conversationKey(id string, group bool) string {
+	if group {
+		return "g:" + id
+	}
+	return "u:" + id
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"dim"
+)
+
+func testConversationStore(t *testing.T, store dim.ConversationStore) {
+	touch := func(id string, group bool, msgID int64, unread bool, accounts ...string) {
+		c := &dim.Conversation{ID: id, Group: group, LastMessageID: msgID, Preview: "hi", UpdateTime: msgID}
+		if err := store.Touch(c, unread, accounts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch("u2", false, 1, true, "u1")
+	touch("u3", false, 2, true, "u1")
+	touch("g1", true, 3, true, "u1", "u2")
+	touch("u2", false, 4, true, "u1")
+	// an older message doesn't change the last message
+	touch("u2", false, 0, false, "u1")
+
+	list, err := store.List("u1", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].ID != "u2" || list[0].LastMessageID != 4 || list[0].Unread != 2 || !list[1].Group {
+		t.Fatalf("unexpected conversations %+v", list)
+	}
+
+	if err = store.SetPinned("u1", "u3", false, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.SetMuted("u1", "g1", true, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.SetMuted("u1", "g1", false, true); err != dim.ErrConversationNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err = store.ClearUnread("u1", "u2", false); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.ClearUnread("u1", "u4", false); err != dim.ErrConversationNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	list, _ = store.List("u1", 1, 1)
+	if len(list) != 1 || list[0].ID != "u2" || list[0].Unread != 0 {
+		t.Fatalf("unexpected conversations %+v", list)
+	}
+	list, _ = store.List("u1", 0, 1)
+	if len(list) != 1 || list[0].ID != "u3" || !list[0].Pinned {
+		t.Fatalf("unexpected conversations %+v", list)
+	}
+	list, _ = store.List("u1", 2, 10)
+	if len(list) != 1 || !list[0].Muted || list[0].Unread != 1 {
+		t.Fatalf("unexpected conversations %+v", list)
+	}
+	if list, _ = store.List("u1", 3, 10); len(list) != 0 {
+		t.Fatalf("unexpected conversations %+v", list)
+	}
+	// the flags of u1 are not shared
+	if list, _ = store.List("u2", 0, 10); len(list) != 1 || list[0].Muted {
+		t.Fatalf("unexpected conversations %+v", list)
+	}
+
+	// the messages after the read position are still unread
+	for _, id := range []int64{5, 6, 7} {
+		touch("u6", false, id, true, "u1")
+	}
+	if err = store.SetRead("u1", "u6", false, 6); err != nil {
+		t.Fatal(err)
+	}
+	// read before, so not counted again
+	touch("u6", false, 6, true, "u1")
+	_ = store.SetRead("u1", "u6", false, 5)
+	if err = store.SetRead("u1", "u7", false, 1); err != dim.ErrConversationNotFound {
+		t.Fatalf("unexpected error %v", err)
+	}
+	list, _ = store.List("u1", 0, 10)
+	for _, c := range list {
+		if c.ID == "u6" && (c.Unread != 1 || c.ReadMessageID != 6) {
+			t.Fatalf("unexpected conversation %+v", c)
+		}
+	}
+
+	// only the preview of the last message is changed
+	if err = store.SetPreview(&dim.Conversation{ID: "g1", Group: true, LastMessageID: 3, Preview: "edited"}, "u1", "u2", "u5"); err != nil {
+		t.Fatal(err)
+	}
+	_ = store.SetPreview(&dim.Conversation{ID: "u2", LastMessageID: 1, Preview: "edited"}, "u1")
+	list, _ = store.List("u1", 0, 10)
+	for _, c := range list {
+		if (c.ID == "g1") != (c.Preview == "edited") {
+			t.Fatalf("unexpected conversation %+v", c)
+		}
+	}
+}
+
+func TestMemoryConversationStore(t *testing.T) {
+	testConversationStore(t, NewMemoryConversationStore())
+}
+
+func TestBoltConversationStore(t *testing.T) {
+	store, err := NewBoltConversationStore(filepath.Join(t.TempDir(), "conversations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	testConversationStore(t, store)
+}
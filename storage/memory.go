@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"sync"
+
+	"dim"
+)
+
+// MemoryStore is a SessionStore in memory, it is shared by services running
+// in one process.
+type MemoryStore struct {
+	sync.RWMutex
+	sessions map[string]*dim.Session
+	// account -> channelID -> location
+	locations map[string]map[string]*dim.Location
+}
+
+// NewMemoryStore NewMemoryStore
+func NewMemoryStore() dim.SessionStore {
+	return &MemoryStore{
+		sessions:  make(map[string]*dim.Session),
+		locations: make(map[string]map[string]*dim.Location),
+	}
+}
+
+// Add Add
+func (m *MemoryStore) Add(session *dim.Session) error {
+	m.Lock()
+	defer m.Unlock()
+	s := *session
+	m.sessions[s.ChannelID] = &s
+	if m.locations[s.Account] == nil {
+		m.locations[s.Account] = make(map[string]*dim.Location)
+	}
+	m.locations[s.Account][s.ChannelID] = &dim.Location{
+		Account:   s.Account,
+		ChannelID: s.ChannelID,
+		GateID:    s.GateID,
+	}
+	return nil
+}
+
+// Delete Delete
+func (m *MemoryStore) Delete(account, channelID, gateID string) error {
+	m.Lock()
+	defer m.Unlock()
+	if s, ok := m.sessions[channelID]; !ok || s.GateID != gateID {
+		return nil
+	}
+	delete(m.sessions, channelID)
+	delete(m.locations[account], channelID)
+	if len(m.locations[account]) == 0 {
+		delete(m.locations, account)
+	}
+	return nil
+}
+
+// Get Get
+func (m *MemoryStore) Get(channelID string) (*dim.Session, error) {
+	m.RLock()
+	defer m.RUnlock()
+	s, ok := m.sessions[channelID]
+	if !ok {
+		return nil, dim.ErrSessionNil
+	}
+	session := *s
+	return &session, nil
+}
+
+// GetLocations GetLocations
+func (m *MemoryStore) GetLocations(accounts ...string) ([]*dim.Location, error) {
+	m.RLock()
+	defer m.RUnlock()
+	var locs []*dim.Location
+	for _, account := range accounts {
+		for _, loc := range m.locations[account] {
+			l := *loc
+			locs = append(locs, &l)
+		}
+	}
+	if len(locs) == 0 {
+		return nil, dim.ErrSessionNil
+	}
+	return locs, nil
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"dim"
+)
+
+// CursorExpired is the default ttl of the cursor of a device not acking, the
+// messages are not kept for an idle device once its cursor is expired.
+const CursorExpired = time.Hour * 24 * 30
+
+// cursor is the id of the last message acked by a device, and the time of
+// the last ack
+type cursor struct {
+	id int64
+	at time.Time
+}
+
+type stored struct {
+	msg  dim.Message
+	refs int
+}
+
+// MemoryMessageStore is a MessageStore in memory
+type MemoryMessageStore struct {
+	sync.RWMutex
+	messages map[int64]*stored
+	// account -> ids in ascending order
+	inboxes map[string][]int64
+	// account -> device -> cursor of the last message acked
+	cursors map[string]map[string]cursor
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+// NewMemoryMessageStore NewMemoryMessageStore
+func NewMemoryMessageStore() dim.MessageStore {
+	return &MemoryMessageStore{
+		messages: make(map[int64]*stored),
+		inboxes:  make(map[string][]int64),
+		cursors:  make(map[string]map[string]cursor),
+		ttl:      CursorExpired,
+		now:      time.Now,
+	}
+}
+
+// SetCursorTTL sets the ttl of the cursor of a device not acking
+func (m *MemoryMessageStore) SetCursorTTL(ttl time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.ttl = ttl
+}
+
+// Insert Insert
+func (m *MemoryMessageStore) Insert(msg *dim.Message, receivers ...string) error {
+	m.Lock()
+	defer m.Unlock()
+	s, ok := m.messages[msg.ID]
+	if !ok {
+		s = &stored{msg: *msg}
+		m.messages[msg.ID] = s
+	}
+	for _, account := range receivers {
+		ids := m.inboxes[account]
+		i := sort.Search(len(ids), func(i int) bool { return ids[i] >= msg.ID })
+		if i < len(ids) && ids[i] == msg.ID {
+			continue
+		}
+		ids = append(ids, 0)
+		copy(ids[i+1:], ids[i:])
+		ids[i] = msg.ID
+		m.inboxes[account] = ids
+		s.refs++
+	}
+	if s.refs == 0 {
+		delete(m.messages, msg.ID)
+	}
+	return nil
+}
+
+// Fetch Fetch
+func (m *MemoryMessageStore) Fetch(account string, since int64, limit int) ([]*dim.Message, error) {
+	m.RLock()
+	defer m.RUnlock()
+	ids := m.inboxes[account]
+	i := sort.Search(len(ids), func(i int) bool { return ids[i] > since })
+	var msgs []*dim.Message
+	for ; i < len(ids) && len(msgs) < limit; i++ {
+		msg := m.messages[ids[i]].msg
+		msgs = append(msgs, &msg)
+	}
+	return msgs, nil
+}
+
+// Ack Ack
+func (m *MemoryMessageStore) Ack(account, device string, id int64) error {
+	m.Lock()
+	defer m.Unlock()
+	cursors := m.cursors[account]
+	if cursors == nil {
+		cursors = make(map[string]cursor)
+		m.cursors[account] = cursors
+	}
+	now := m.now()
+	if c, ok := cursors[device]; ok && id < c.id {
+		id = c.id
+	}
+	cursors[device] = cursor{id: id, at: now}
+	all := make(map[string]int64, len(cursors))
+	for d, c := range cursors {
+		if now.Sub(c.at) > m.ttl {
+			delete(cursors, d)
+			continue
+		}
+		all[d] = c.id
+	}
+	id = minCursor(all)
+
+	ids := m.inboxes[account]
+	n := sort.Search(len(ids), func(i int) bool { return ids[i] > id })
+	for _, acked := range ids[:n] {
+		if s := m.messages[acked]; s != nil {
+			if s.refs--; s.refs <= 0 {
+				delete(m.messages, acked)
+			}
+		}
+	}
+	if n == len(ids) {
+		delete(m.inboxes, account)
+	} else {
+		m.inboxes[account] = append([]int64(nil), ids[n:]...)
+	}
+	return nil
+}
+
+// Cursor Cursor
+func (m *MemoryMessageStore) Cursor(account, device string) (int64, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.cursors[account][device].id, nil
+}
+
+// Update Update
+func (m *MemoryMessageStore) Update(id int64, body []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	if s, ok := m.messages[id]; ok {
+		s.msg.Body = body
+	}
+	return nil
+}
+
+// minCursor returns the id acked by all the devices
+func minCursor(cursors map[string]int64) int64 {
+	first := true
+	var id int64
+	for _, cursor := range cursors {
+		if first || cursor < id {
+			id, first = cursor, false
+		}
+	}
+	return id
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dim"
+)
+
+func ids(msgs []*dim.Message) []int64 {
+	list := make([]int64, 0, len(msgs))
+	for _, m := range msgs {
+		list = append(list, m.ID)
+	}
+	return list
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testMessageStore(t *testing.T, store dim.MessageStore) {
+	for _, id := range []int64{3, 1, 2, 5, 4} {
+		msg := &dim.Message{ID: id, Command: "chat.user.talk", Sender: "u0", Body: []byte("hi")}
+		if err := store.Insert(msg, "u1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a group message is stored once for both
+	if err := store.Insert(&dim.Message{ID: 6, Sender: "u0"}, "u1", "u2"); err != nil {
+		t.Fatal(err)
+	}
+	// inserted twice
+	_ = store.Insert(&dim.Message{ID: 6, Sender: "u0"}, "u2")
+
+	msgs, err := store.Fetch("u1", 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(ids(msgs), []int64{1, 2, 3, 4}) || string(msgs[0].Body) != "hi" || msgs[0].Sender != "u0" {
+		t.Fatalf("unexpected messages %v", ids(msgs))
+	}
+	if msgs, _ = store.Fetch("u1", 4, 4); !equal(ids(msgs), []int64{5, 6}) {
+		t.Fatalf("unexpected messages %v", ids(msgs))
+	}
+	if msgs, _ = store.Fetch("u2", 0, 10); !equal(ids(msgs), []int64{6}) {
+		t.Fatalf("unexpected messages %v", ids(msgs))
+	}
+
+	if err = store.Update(6, []byte("edited")); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Update(100, []byte("edited")); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _ = store.Fetch("u2", 0, 10); string(msgs[0].Body) != "edited" {
+		t.Fatalf("unexpected body %s", msgs[0].Body)
+	}
+
+	if err = store.Ack("u1", "", 6); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _ = store.Fetch("u1", 0, 10); len(msgs) != 0 {
+		t.Fatalf("unexpected messages %v", ids(msgs))
+	}
+	// still referenced by u2
+	if msgs, _ = store.Fetch("u2", 0, 10); !equal(ids(msgs), []int64{6}) {
+		t.Fatalf("unexpected messages %v", ids(msgs))
+	}
+	if err = store.Ack("u3", "", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// kept until acked on every device
+	for _, id := range []int64{7, 8} {
+		_ = store.Insert(&dim.Message{ID: id, Sender: "u0"}, "u4")
+	}
+	_ = store.Ack("u4", "web", 0)
+	if err = store.Ack("u4", "ios", 8); err != nil {
+		t.Fatal(err)
+	}
+	if cursor, _ := store.Cursor("u4", "ios"); cursor != 8 {
+		t.Fatalf("unexpected cursor %d", cursor)
+	}
+	if msgs, _ = store.Fetch("u4", 0, 10); !equal(ids(msgs), []int64{7, 8}) {
+		t.Fatalf("unexpected messages %v", ids(msgs))
+	}
+	_ = store.Ack("u4", "web", 7)
+	// a cursor doesn't move back
+	_ = store.Ack("u4", "ios", 1)
+	if msgs, _ = store.Fetch("u4", 0, 10); !equal(ids(msgs), []int64{8}) {
+		t.Fatalf("unexpected messages %v", ids(msgs))
+	}
+	_ = store.Ack("u4", "web", 8)
+	if msgs, _ = store.Fetch("u4", 0, 10); len(msgs) != 0 {
+		t.Fatalf("unexpected messages %v", ids(msgs))
+	}
+}
+
+// testCursorExpired tests the messages are not kept for an idle device, the
+// store acks at *now
+func testCursorExpired(t *testing.T, store dim.MessageStore, now *time.Time) {
+	for _, id := range []int64{1, 2} {
+		_ = store.Insert(&dim.Message{ID: id, Sender: "u0"}, "u5")
+	}
+	_ = store.Ack("u5", "web", 0)
+	_ = store.Ack("u5", "ios", 1)
+	if msgs, _ := store.Fetch("u5", 0, 10); !equal(ids(msgs), []int64{1, 2}) {
+		t.Fatalf("unexpected messages %v", ids(msgs))
+	}
+	// web is idle since then
+	*now = now.Add(time.Hour * 2)
+	_ = store.Ack("u5", "ios", 2)
+	if msgs, _ := store.Fetch("u5", 0, 10); len(msgs) != 0 {
+		t.Fatalf("unexpected messages %v", ids(msgs))
+	}
+	if cursor, _ := store.Cursor("u5", "web"); cursor != 0 {
+		t.Fatalf("unexpected cursor %d", cursor)
+	}
+}
+
+func TestMemoryMessageStore(t *testing.T) {
+	store := NewMemoryMessageStore()
+	testMessageStore(t, store)
+	_ = store.Ack("u2", "", 6)
+	if n := len(store.(*MemoryMessageStore).messages); n != 0 {
+		t.Fatalf("expect messages to be trimmed, %d left", n)
+	}
+
+	now := time.Now()
+	mem := NewMemoryMessageStore().(*MemoryMessageStore)
+	mem.now = func() time.Time { return now }
+	mem.SetCursorTTL(time.Hour)
+	testCursorExpired(t, mem, &now)
+}
+
+func TestBoltMessageStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	store, err := NewBoltMessageStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMessageStore(t, store)
+	_ = store.Close()
+
+	// reopen
+	store, err = NewBoltMessageStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if msgs, _ := store.Fetch("u2", 0, 10); !equal(ids(msgs), []int64{6}) {
+		t.Fatalf("unexpected messages %v", ids(msgs))
+	}
+
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	store.SetCursorTTL(time.Hour)
+	testCursorExpired(t, store, &now)
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"dim"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// LocationExpired is the default ttl of the sessions stored in redis
+const LocationExpired = time.Hour * 48
+
+// RedisStore is a SessionStore in redis.
+//
+//	login:sn:{channelID}  string of the session json
+//	login:loc:{account}   hash of channelID -> gateID
+//	login:exp:{account}   hash of channelID -> unix time the location expires
+//
+// The location hash is shared by the devices of an account, so a location is
+// expired by its own deadline, as a device lost without Delete is never
+// removed by the ttl of the hash refreshed by the other devices.
+type RedisStore struct {
+	cli *redis.Client
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewRedisStore NewRedisStore
+func NewRedisStore(cli *redis.Client, ttl time.Duration) dim.SessionStore {
+	if ttl == 0 {
+		ttl = LocationExpired
+	}
+	return &RedisStore{
+		cli: cli,
+		ttl: ttl,
+		now: time.Now,
+	}
+}
+
+// Add Add
+func (r *RedisStore) Add(session *dim.Session) error {
+	buf, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	locKey, expKey := keyLocation(session.Account), keyExpiry(session.Account)
+	deadline := r.now().Add(r.ttl).Unix()
+	_, err = r.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, keySession(session.ChannelID), buf, r.ttl)
+		pipe.HSet(ctx, locKey, session.ChannelID, session.GateID)
+		pipe.HSet(ctx, expKey, session.ChannelID, deadline)
+		pipe.Expire(ctx, locKey, r.ttl)
+		pipe.Expire(ctx, expKey, r.ttl)
+		return nil
+	})
+	return err
+}
+
+// deleteScript deletes the session KEYS[1] and the location ARGV[2] of the
+// hash KEYS[2] and its deadline of KEYS[3] if they are added by the gateway
+// ARGV[1]
+var deleteScript = redis.NewScript(`
+local buf = redis.call('GET', KEYS[1])
+if buf then
+	if cjson.decode(buf).gate_id ~= ARGV[1] then
+		return 0
+	end
+	redis.call('DEL', KEYS[1])
+end
+if redis.call('HGET', KEYS[2], ARGV[2]) == ARGV[1] then
+	redis.call('HDEL', KEYS[2], ARGV[2])
+	redis.call('HDEL', KEYS[3], ARGV[2])
+end
+return 1
+`)
+
+// expireScript deletes the locations ARGV[2:] of the hash KEYS[1] and their
+// deadlines of KEYS[2], if they are not added again after expiring at ARGV[1]
+var expireScript = redis.NewScript(`
+for i = 2, #ARGV do
+	local deadline = tonumber(redis.call('HGET', KEYS[2], ARGV[i]))
+	if deadline and deadline <= tonumber(ARGV[1]) then
+		redis.call('HDEL', KEYS[1], ARGV[i])
+		redis.call('HDEL', KEYS[2], ARGV[i])
+	end
+end
+return 1
+`)
+
+// Delete Delete
+func (r *RedisStore) Delete(account, channelID, gateID string) error {
+	keys := []string{keySession(channelID), keyLocation(account), keyExpiry(account)}
+	return deleteScript.Run(context.Background(), r.cli, keys, gateID, channelID).Err()
+}
+
+// Get Get
+func (r *RedisStore) Get(channelID string) (*dim.Session, error) {
+	buf, err := r.cli.Get(context.Background(), keySession(channelID)).Bytes()
+	if err == redis.Nil {
+		return nil, dim.ErrSessionNil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var session dim.Session
+	if err = json.Unmarshal(buf, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetLocations GetLocations
+func (r *RedisStore) GetLocations(accounts ...string) ([]*dim.Location, error) {
+	ctx := context.Background()
+	cmds := make([]*redis.StringStringMapCmd, len(accounts))
+	exps := make([]*redis.StringStringMapCmd, len(accounts))
+	_, err := r.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, account := range accounts {
+			cmds[i] = pipe.HGetAll(ctx, keyLocation(account))
+			exps[i] = pipe.HGetAll(ctx, keyExpiry(account))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	now := r.now().Unix()
+	var locs []*dim.Location
+	for i, cmd := range cmds {
+		var expired []interface{}
+		for channelID, gateID := range cmd.Val() {
+			// a location without a deadline is expired by the hash only
+			exp, ok := exps[i].Val()[channelID]
+			if deadline, _ := strconv.ParseInt(exp, 10, 64); ok && deadline <= now {
+				expired = append(expired, channelID)
+				continue
+			}
+			locs = append(locs, &dim.Location{
+				Account:   accounts[i],
+				ChannelID: channelID,
+				GateID:    gateID,
+			})
+		}
+		if len(expired) > 0 {
+			keys := []string{keyLocation(accounts[i]), keyExpiry(accounts[i])}
+			_ = expireScript.Run(ctx, r.cli, keys, append([]interface{}{now}, expired...)...).Err()
+		}
+	}
+	if len(locs) == 0 {
+		return nil, dim.ErrSessionNil
+	}
+	return locs, nil
+}
+
+func keySession(channelID string) string {
+	return fmt.Sprintf("login:sn:%s", channelID)
+}
+
+func keyLocation(account string) string {
+	return fmt.Sprintf("login:loc:%s", account)
+}
+
+func keyExpiry(account string) string {
+	return fmt.Sprintf("login:exp:%s", account)
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"dim"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func testStore(t *testing.T, store dim.SessionStore) {
+	sessions := []*dim.Session{
+		{ChannelID: "ch1", GateID: "gate1", Account: "u1", Device: "ios"},
+		{ChannelID: "ch2", GateID: "gate2", Account: "u1", Device: "web"},
+		{ChannelID: "ch3", GateID: "gate1", Account: "u2", Device: "android"},
+	}
+	for _, s := range sessions {
+		if err := store.Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := store.Get("ch2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Account != "u1" || s.GateID != "gate2" || s.Device != "web" {
+		t.Fatalf("unexpected session %+v", s)
+	}
+
+	locs, err := store.GetLocations("u1", "u2", "u3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(locs, func(i, j int) bool { return locs[i].ChannelID < locs[j].ChannelID })
+	if len(locs) != 3 || locs[1].GateID != "gate2" || locs[2].Account != "u2" {
+		t.Fatalf("unexpected locations %v", locs)
+	}
+
+	// ch1 logged in again on gate2 before gate1 deletes it
+	if err = store.Add(&dim.Session{ChannelID: "ch1", GateID: "gate2", Account: "u1", Device: "ios"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Delete("u1", "ch1", "gate1"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = store.Get("ch1"); err != nil || s.GateID != "gate2" {
+		t.Fatalf("unexpected session %+v %v", s, err)
+	}
+	if locs, _ = store.GetLocations("u1"); len(locs) != 2 {
+		t.Fatalf("unexpected locations %v", locs)
+	}
+
+	if err = store.Delete("u1", "ch1", "gate2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Get("ch1"); err != dim.ErrSessionNil {
+		t.Fatalf("expect ErrSessionNil, got %v", err)
+	}
+	locs, err = store.GetLocations("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locs) != 1 || locs[0].ChannelID != "ch2" {
+		t.Fatalf("unexpected locations %v", locs)
+	}
+	if _, err = store.GetLocations("u3"); err != dim.ErrSessionNil {
+		t.Fatalf("expect ErrSessionNil, got %v", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestRedisStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer cli.Close()
+
+	testStore(t, NewRedisStore(cli, 0))
+	if ttl := mr.TTL("login:sn:ch2"); ttl != LocationExpired {
+		t.Fatalf("unexpected ttl %v", ttl)
+	}
+}
+
+func TestRedisStoreExpired(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer cli.Close()
+
+	now := time.Now()
+	store := NewRedisStore(cli, time.Minute).(*RedisStore)
+	store.now = func() time.Time { return now }
+	_ = store.Add(&dim.Session{ChannelID: "ch1", GateID: "gate1", Account: "u1", Device: "ios"})
+
+	// ch1 is lost without Delete, the hash is refreshed by ch2
+	now = now.Add(time.Second * 40)
+	_ = store.Add(&dim.Session{ChannelID: "ch2", GateID: "gate1", Account: "u1", Device: "web"})
+	now = now.Add(time.Second * 30)
+	locs, err := store.GetLocations("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locs) != 1 || locs[0].ChannelID != "ch2" {
+		t.Fatalf("unexpected locations %v", locs)
+	}
+	if mr.HGet("login:loc:u1", "ch1") != "" || mr.HGet("login:exp:u1", "ch1") != "" {
+		t.Fatal("expired location is not deleted")
+	}
+}
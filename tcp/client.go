@@ -2,9 +2,11 @@ package tcp
 
 import (
 	"dim"
+	"dim/logger"
+	"dim/metrics"
 	"errors"
 	"fmt"
-	"net/url"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,18 +17,32 @@ type ClientOptions struct {
 	Heartbeat time.Duration
 	ReadWait  time.Duration
 	WriteWait time.Duration
+	// PongWait is the max duration without a pong from the server, the
+	// connection is closed once exceeded. Default to 3 times of Heartbeat.
+	PongWait time.Duration
+	// Listener is set to receive the payloads read in background, Read is
+	// not available with it.
+	Listener dim.ClientListener
+	// ReadBuffer is the number of frames read in background and waiting for
+	// Read, the read loop blocks once it is full. Default to 64.
+	ReadBuffer int
 }
 
-// Client is a websocket implement of terminal
+// Client is a tcp implement of terminal
 type Client struct {
 	sync.Mutex
 	dim.Dialer
-	once    sync.Once
-	id      string
-	name    string
-	conn    dim.Conn
-	state   int32
-	options ClientOptions
+	once     sync.Once
+	id       string
+	name     string
+	conn     dim.Conn
+	state    int32
+	options  ClientOptions
+	lastPong int64
+	timedOut int32
+	frames   chan dim.Frame
+	closed   *dim.Event
+	err      error
 }
 
 // NewClient NewClient
@@ -34,13 +50,20 @@ func NewClient(id, name string, opts ClientOptions) dim.Client {
 	if opts.WriteWait == 0 {
 		opts.WriteWait = dim.DefaultWriteWait
 	}
-	if opts.WriteWait == 0 {
+	if opts.ReadWait == 0 {
 		opts.ReadWait = dim.DefaultReadWait
 	}
+	if opts.PongWait == 0 {
+		opts.PongWait = opts.Heartbeat * 3
+	}
+	if opts.ReadBuffer == 0 {
+		opts.ReadBuffer = 64
+	}
 	cli := &Client{
 		id:      id,
 		name:    name,
 		options: opts,
+		closed:  dim.NewEvent(),
 	}
 	return cli
 }
@@ -54,12 +77,12 @@ func (c *Client) Name() string {
 }
 
 func (c *Client) Connect(addr string) error {
-	_, err := url.Parse(addr)
+	_, _, err := net.SplitHostPort(addr)
 	if err != nil {
 		return err
 	}
 	if !atomic.CompareAndSwapInt32(&c.state, 0, 1) {
-		return fmt.Errorf("Client has connected")
+		return fmt.Errorf("client has connected")
 	}
 
 	rawconn, err := c.DialAndHandshake(dim.DialerContext{
@@ -70,19 +93,31 @@ func (c *Client) Connect(addr string) error {
 	})
 
 	if err != nil {
+		metrics.DialFailures.WithLabelValues("tcp").Inc()
 		atomic.CompareAndSwapInt32(&c.state, 1, 0)
-		return fmt.Errorf("client has connected")
+		return err
 	}
 	if rawconn == nil {
+		atomic.CompareAndSwapInt32(&c.state, 1, 0)
 		return fmt.Errorf("conn is nil")
 	}
 	c.conn = NewConn(rawconn)
+	if c.options.Listener == nil {
+		c.frames = make(chan dim.Frame, c.options.ReadBuffer)
+	}
 
 	if c.options.Heartbeat > 0 {
+		atomic.StoreInt64(&c.lastPong, time.Now().UnixNano())
 		go func() {
-			////
+			err := c.heartbeatloop()
+			if err != nil {
+				logger.Error("heartbeatloop stopped ", err)
+			}
 		}()
 	}
+	// frames are always read in background, so that pings and pongs are
+	// handled even if nobody is calling Read
+	go c.readloop()
 	return nil
 }
 
@@ -99,37 +134,157 @@ func (c *Client) Send(payload []byte) error {
 
 	err := c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
 	if err != nil {
-		return nil
+		return err
 	}
+	metrics.Frame(metrics.Out, dim.OpBinary.String(), len(payload))
 	return c.conn.WriteFrame(dim.OpBinary, payload)
 }
 
 func (c *Client) Close() {
 	c.once.Do(func() {
+		c.closed.Fire()
 		if c.conn == nil {
 			return
 		}
+		// the close frame is written with the lock, as the frames of Send
+		// and the heartbeat
+		c.Lock()
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
 		_ = WriteFrame(c.conn, dim.OpClose, nil)
+		c.Unlock()
 
 		c.conn.Close()
 		atomic.CompareAndSwapInt32(&c.state, 1, 0)
 	})
 }
 
+// Read the next frame, a ping from the server is replied with a pong, ping
+// and pong frames are not returned.
 func (c *Client) Read() (dim.Frame, error) {
+	if c.options.Listener != nil {
+		return nil, errors.New("frames are read by the listener")
+	}
+	if c.frames == nil {
+		return nil, errors.New("connection is nil")
+	}
+	frame, ok := <-c.frames
+	if !ok {
+		return nil, c.Err()
+	}
+	return frame, nil
+}
+
+// Done returns a channel closed once the client is closed, or the background
+// read loop is stopped
+func (c *Client) Done() <-chan struct{} {
+	return c.closed.Done()
+}
+
+// Err returns the error the background read loop is stopped with
+func (c *Client) Err() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.err
+}
+
+func (c *Client) readloop() {
+	for {
+		frame, err := c.read()
+		if err != nil {
+			logger.WithFields(logger.Fields{
+				"module": "tcp.client",
+				"id":     c.id,
+			}).Info(err)
+			c.Lock()
+			c.err = err
+			c.Unlock()
+			c.Close()
+			if c.frames != nil {
+				close(c.frames)
+			}
+			return
+		}
+		if c.options.Listener != nil {
+			c.options.Listener.Receive(c, frame.GetPayload())
+			continue
+		}
+		select {
+		case c.frames <- frame:
+		case <-c.closed.Done():
+		}
+	}
+}
+
+func (c *Client) read() (dim.Frame, error) {
 	if c.conn == nil {
 		return nil, errors.New("connection is nil")
 	}
-	if c.options.Heartbeat > 0 {
-		_ = c.conn.SetReadDeadline(time.Now().Add(c.options.ReadWait))
+	for {
+		if c.options.Heartbeat > 0 {
+			_ = c.conn.SetReadDeadline(time.Now().Add(c.options.ReadWait))
+		}
+		frame, err := c.conn.ReadFrame()
+		if err != nil {
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() {
+				c.heartbeatTimeout()
+			}
+			return nil, err
+		}
+		metrics.Frame(metrics.In, frame.GetOpCode().String(), len(frame.GetPayload()))
+		switch frame.GetOpCode() {
+		case dim.OpClose:
+			return nil, errors.New("remote side close the channel")
+		case dim.OpPing:
+			logger.Tracef("%s recv a ping; resp with a pong", c.id)
+			if err = c.write(dim.OpPong); err != nil {
+				return nil, err
+			}
+		case dim.OpPong:
+			atomic.StoreInt64(&c.lastPong, time.Now().UnixNano())
+		default:
+			return frame, nil
+		}
 	}
-	frame, err := c.conn.ReadFrame()
-	if err != nil {
-		return nil, err
+}
+
+func (c *Client) heartbeatloop() error {
+	tick := time.NewTicker(c.options.Heartbeat)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			last := time.Unix(0, atomic.LoadInt64(&c.lastPong))
+			if time.Since(last) > c.options.PongWait {
+				c.heartbeatTimeout()
+				c.conn.Close()
+				return fmt.Errorf("%s no pong from server since %v", c.id, last)
+			}
+			logger.Tracef("%s send ping to server", c.id)
+			if err := c.write(dim.OpPing); err != nil {
+				return err
+			}
+		case <-c.closed.Done():
+			return nil
+		}
 	}
-	if frame.GetOpCode() == dim.OpClose {
-		return nil, errors.New("remote side close the channel")
+}
+
+// heartbeatTimeout counts the heartbeat timeout of the connection once, it
+// is found by the read deadline or the missing pong, whichever is first
+func (c *Client) heartbeatTimeout() {
+	if atomic.CompareAndSwapInt32(&c.timedOut, 0, 1) {
+		metrics.HeartbeatTimeouts.WithLabelValues("client").Inc()
 	}
+}
 
-	return frame, nil
+func (c *Client) write(code dim.OpCode) error {
+	c.Lock()
+	defer c.Unlock()
+	err := c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
+	if err != nil {
+		return err
+	}
+	metrics.Frame(metrics.Out, code.String(), 0)
+	return c.conn.WriteFrame(code, nil)
 }
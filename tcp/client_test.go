@@ -0,0 +1,200 @@
+package tcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"dim"
+	"dim/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type dialer struct{}
+
+func (d *dialer) DialAndHandshake(ctx dim.DialerContext) (net.Conn, error) {
+	return net.DialTimeout("tcp", ctx.Address, ctx.Timeout)
+}
+
+// serve accepts one connection and handles it with fn
+func serve(t *testing.T, fn func(conn *TcpConn)) string {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lst.Close() })
+	go func() {
+		rawconn, err := lst.Accept()
+		if err != nil {
+			return
+		}
+		defer rawconn.Close()
+		fn(NewConn(rawconn))
+	}()
+	return lst.Addr().String()
+}
+
+func connect(t *testing.T, addr string, opts ClientOptions) dim.Client {
+	cli := NewClient("c1", "client", opts)
+	cli.SetDialer(&dialer{})
+	if err := cli.Connect(addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cli.Close)
+	return cli
+}
+
+func TestClientOptions(t *testing.T) {
+	cli := NewClient("c1", "client", ClientOptions{Heartbeat: time.Second}).(*Client)
+	if cli.options.ReadWait != dim.DefaultReadWait || cli.options.WriteWait != dim.DefaultWriteWait {
+		t.Fatalf("unexpected options %+v", cli.options)
+	}
+	if cli.options.PongWait != time.Second*3 {
+		t.Fatalf("unexpected pong wait %v", cli.options.PongWait)
+	}
+}
+
+func TestClientHeartbeat(t *testing.T) {
+	pings := make(chan struct{}, 10)
+	addr := serve(t, func(conn *TcpConn) {
+		for {
+			frame, err := conn.ReadFrame()
+			if err != nil {
+				return
+			}
+			if frame.GetOpCode() == dim.OpPing {
+				select {
+				case pings <- struct{}{}:
+				default:
+				}
+				_ = conn.WriteFrame(dim.OpPong, nil)
+			}
+			if frame.GetOpCode() == dim.OpBinary {
+				_ = conn.WriteFrame(dim.OpBinary, frame.GetPayload())
+			}
+		}
+	})
+	cli := connect(t, addr, ClientOptions{
+		Heartbeat: time.Millisecond * 20,
+		PongWait:  time.Millisecond * 60,
+	}).(*Client)
+
+	// the client is idle longer than PongWait, pongs are tracked without Read
+	for i := 0; i < 5; i++ {
+		select {
+		case <-pings:
+		case <-cli.Done():
+			t.Fatal("idle client is closed")
+		case <-time.After(time.Second):
+			t.Fatal("ping is not sent")
+		}
+	}
+	select {
+	case <-cli.Done():
+		t.Fatal("idle client is closed")
+	default:
+	}
+
+	_ = cli.Send([]byte("hello"))
+	frame, err := cli.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(frame.GetPayload()) != "hello" {
+		t.Fatalf("expect hello, got %s", frame.GetPayload())
+	}
+}
+
+func TestClientDeadServer(t *testing.T) {
+	addr := serve(t, func(conn *TcpConn) {
+		// read frames but never send a pong
+		for {
+			if _, err := conn.ReadFrame(); err != nil {
+				return
+			}
+		}
+	})
+	timeouts := testutil.ToFloat64(metrics.HeartbeatTimeouts.WithLabelValues("client"))
+	// the read deadline and the missing pong are found at the same time
+	cli := connect(t, addr, ClientOptions{
+		Heartbeat: time.Millisecond * 20,
+		ReadWait:  time.Millisecond * 60,
+		PongWait:  time.Millisecond * 60,
+	})
+
+	start := time.Now()
+	_, err := cli.Read()
+	if err == nil {
+		t.Fatal("expect an error")
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("dead server is detected after %v", time.Since(start))
+	}
+	if n := testutil.ToFloat64(metrics.HeartbeatTimeouts.WithLabelValues("client")) - timeouts; n != 1 {
+		t.Fatalf("expect 1 heartbeat timeout, got %v", n)
+	}
+}
+
+func TestClientPong(t *testing.T) {
+	pong := make(chan struct{})
+	addr := serve(t, func(conn *TcpConn) {
+		_ = conn.WriteFrame(dim.OpPing, nil)
+		frame, err := conn.ReadFrame()
+		if err != nil || frame.GetOpCode() != dim.OpPong {
+			return
+		}
+		close(pong)
+		_ = conn.WriteFrame(dim.OpBinary, []byte("done"))
+		// wait for the client to close
+		_, _ = conn.ReadFrame()
+	})
+	cli := connect(t, addr, ClientOptions{})
+
+	frame, err := cli.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(frame.GetPayload()) != "done" {
+		t.Fatalf("expect done, got %s", frame.GetPayload())
+	}
+	select {
+	case <-pong:
+	default:
+		t.Fatal("pong is not sent")
+	}
+}
+
+type listener chan []byte
+
+func (l listener) Receive(cli dim.Client, payload []byte) {
+	l <- payload
+}
+
+func TestClientListener(t *testing.T) {
+	addr := serve(t, func(conn *TcpConn) {
+		_ = conn.WriteFrame(dim.OpPing, nil)
+		if frame, err := conn.ReadFrame(); err != nil || frame.GetOpCode() != dim.OpPong {
+			return
+		}
+		_ = conn.WriteFrame(dim.OpBinary, []byte("hello"))
+		_ = conn.WriteFrame(dim.OpClose, nil)
+	})
+	lst := make(listener, 10)
+	cli := connect(t, addr, ClientOptions{Listener: lst}).(*Client)
+
+	if _, err := cli.Read(); err == nil {
+		t.Fatal("Read is not available with a listener")
+	}
+	select {
+	case <-cli.Done():
+	case <-time.After(time.Second):
+		t.Fatal("read loop is not stopped")
+	}
+	if cli.Err() == nil {
+		t.Fatal("expect the error of read loop")
+	}
+	if len(lst) != 1 || string(<-lst) != "hello" {
+		t.Fatal("expect payload hello")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"dim"
 	"dim/logger"
+	"dim/metrics"
 	"dim/naming"
 	"errors"
 	"fmt"
@@ -32,6 +33,8 @@ type Server struct {
 	once    sync.Once
 	options ServerOptions
 	quit    *dim.Event
+	lock    sync.Mutex
+	lst     net.Listener
 }
 
 // NewServer
@@ -69,12 +72,20 @@ func (s *Server) Start() error {
 	if err != nil {
 		return err
 	}
+	s.lock.Lock()
+	s.lst = lst
+	s.lock.Unlock()
+	if s.quit.HasFired() {
+		_ = lst.Close()
+	}
 	log.Info("Started")
 
 	for {
 		rawconn, err := lst.Accept()
 		if err != nil {
-			rawconn.Close()
+			if s.quit.HasFired() {
+				return fmt.Errorf("listen exited")
+			}
 			log.Warn(err)
 			continue
 		}
@@ -83,6 +94,11 @@ func (s *Server) Start() error {
 
 			id, err := s.Accept(conn, s.options.loginwait)
 			if err != nil {
+				reason := metrics.ReasonHandshake
+				if errors.Is(err, dim.ErrUnauthorized) {
+					reason = metrics.ReasonAuth
+				}
+				metrics.Rejects.WithLabelValues("tcp", reason).Inc()
 				_ = conn.WriteFrame(dim.OpClose, []byte(err.Error()))
 				conn.Close()
 				return
@@ -90,6 +106,7 @@ func (s *Server) Start() error {
 
 			if _, ok := s.Get(id); ok {
 				log.Warnf("channel %s existed", id)
+				metrics.Rejects.WithLabelValues("tcp", metrics.ReasonDuplicate).Inc()
 				_ = conn.WriteFrame(dim.OpClose, []byte("channelID is repated"))
 				conn.Close()
 				return
@@ -100,6 +117,8 @@ func (s *Server) Start() error {
 			channel.SetWriteWait(s.options.writewait)
 
 			s.Add(channel)
+			metrics.Accepts.WithLabelValues("tcp").Inc()
+			metrics.Connections.WithLabelValues("tcp").Inc()
 			log.Info("accept: ", channel)
 
 			err = channel.Readloop(s.MessageListener)
@@ -107,6 +126,7 @@ func (s *Server) Start() error {
 				log.Info(err)
 			}
 			s.Remove(channel.ID())
+			metrics.Connections.WithLabelValues("tcp").Dec()
 			_ = s.Disconnect(channel.ID())
 			channel.Close()
 
@@ -132,6 +152,13 @@ func (s *Server) Shutdown(ctx context.Context) error {
 			log.Info("shutdown")
 		}()
 
+		s.quit.Fire()
+		s.lock.Lock()
+		if s.lst != nil {
+			_ = s.lst.Close()
+		}
+		s.lock.Unlock()
+
 		// close channel
 		channels := s.ChannelMap.All()
 		for _, ch := range channels {
@@ -153,7 +180,7 @@ func (s *Server) Shutdown(ctx context.Context) error {
 // []byte data
 func (s *Server) Push(id string, data []byte) error {
 	ch, ok := s.ChannelMap.Get(id)
-	if ok {
+	if !ok {
 		return errors.New("channel no found")
 	}
 	return ch.Push(data)
@@ -0,0 +1,113 @@
+// Package tracing propagates the trace context of a request in the meta of
+// the logic packets, so the spans of a message from the client to the
+// gateway, the logic service and back to the receivers are of one trace.
+// The spans are created by the tracer of the global provider of
+// OpenTelemetry, which is set by otel.SetTracerProvider, e.g. with
+// NewProvider.
+package tracing
+
+import (
+	"context"
+	"strconv"
+
+	"dim/wire/pkt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer of dim
+const TracerName = "dim"
+
+// MetaTraceParent is the meta key of the trace context of a packet, in the
+// format of W3C Trace Context
+const MetaTraceParent = "traceparent"
+
+// attributes of the spans
+const (
+	AttrCommand  = attribute.Key("dim.command")
+	AttrChannel  = attribute.Key("dim.channel")
+	AttrSequence = attribute.Key("dim.sequence")
+	AttrStatus   = attribute.Key("dim.status")
+)
+
+var propagator = propagation.TraceContext{}
+
+// Carrier is the propagation.TextMapCarrier of the meta of a packet
+type Carrier struct {
+	p *pkt.LogicPkt
+}
+
+// Get Get
+func (c Carrier) Get(key string) string {
+	v, _ := c.p.GetMeta(key)
+	return v
+}
+
+// Set Set
+func (c Carrier) Set(key, value string) {
+	c.p.AddMeta(key, value)
+}
+
+// Keys Keys
+func (c Carrier) Keys() []string {
+	keys := make([]string, 0, len(c.p.Meta))
+	for k := range c.p.Meta {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Tracer returns the tracer of the global provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// Inject the span context of ctx into the meta of p
+func Inject(ctx context.Context, p *pkt.LogicPkt) {
+	propagator.Inject(ctx, Carrier{p})
+}
+
+// Extract returns a copy of ctx with the span context in the meta of p
+func Extract(ctx context.Context, p *pkt.LogicPkt) context.Context {
+	return propagator.Extract(ctx, Carrier{p})
+}
+
+// Start a span named by the command of p, it is a child of the span in the
+// meta of p, or of the span of ctx if p has no one.
+func Start(ctx context.Context, p *pkt.LogicPkt, kind trace.SpanKind) (context.Context, trace.Span) {
+	return Tracer().Start(Extract(ctx, p), p.Command,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(
+			AttrCommand.String(p.Command),
+			AttrChannel.String(p.ChannelID),
+			AttrSequence.Int64(int64(p.Sequence)),
+		))
+}
+
+// SetStatus records the status of a response in span, a status other than
+// Success is an error.
+func SetStatus(span trace.Span, status pkt.Status) {
+	span.SetAttributes(AttrStatus.Int(int(status)))
+	if status != pkt.Success {
+		span.SetStatus(codes.Error, "status "+strconv.Itoa(int(status)))
+	}
+}
+
+// NewProvider returns a provider exporting the spans of service by
+// exporter in batches, e.g. an exporter of stdouttrace.
+func NewProvider(service string, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(service),
+		)),
+	)
+}
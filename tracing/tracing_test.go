@@ -0,0 +1,107 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"dim"
+	"dim/router"
+	"dim/router/routertest"
+	"dim/tracing"
+	"dim/wire/pkt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+type sessions struct{}
+
+func (sessions) Add(*dim.Session) error              { return nil }
+func (sessions) Delete(string, string, string) error { return nil }
+func (sessions) Get(string) (*dim.Session, error)    { return nil, dim.ErrSessionNil }
+func (sessions) GetLocations(accounts ...string) ([]*dim.Location, error) {
+	return []*dim.Location{{Account: accounts[0], ChannelID: accounts[0], GateID: "gateway02"}}, nil
+}
+
+func traceID(t *testing.T, p *pkt.LogicPkt) oteltrace.TraceID {
+	t.Helper()
+	sc := oteltrace.SpanContextFromContext(tracing.Extract(context.Background(), p))
+	if !sc.IsValid() {
+		t.Fatalf("no trace in %s", p)
+	}
+	return sc.TraceID()
+}
+
+func TestRouterSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := trace.NewTracerProvider(trace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(oteltrace.NewNoopTracerProvider())
+
+	r := router.NewRouter()
+	var (
+		logged  context.Context
+		command interface{}
+	)
+	r.Handle("chat.user.talk", func(ctx router.Context) {
+		logged, command = ctx.Logger().Context, ctx.Logger().Data["command"]
+		locs, _ := ctx.GetLocations("u2")
+		_ = ctx.Dispatch([]byte("hi"), locs...)
+		_ = ctx.Resp(pkt.Success, nil)
+	})
+
+	// the span of the client
+	ctx, span := tracing.Tracer().Start(context.Background(), "client")
+	p := pkt.New("chat.user.talk", pkt.WithChannel("u1"))
+	tracing.Inject(ctx, p)
+	span.End()
+
+	gw, disp := routertest.NewGateway("gateway01"), &routertest.Dispatcher{}
+	if err := r.Serve(gw, p, &dim.Session{ChannelID: "u1", Account: "u1"}, disp, sessions{}); err != nil {
+		t.Fatal(err)
+	}
+	id := span.SpanContext().TraceID()
+	if len(disp.Pushes) != 1 || traceID(t, disp.Pushes[0].Packet) != id || traceID(t, gw.Last()) != id {
+		t.Fatalf("unexpected pushes %v %s", disp.Pushes, gw.Last())
+	}
+	// the logs of the handlers are traced
+	if oteltrace.SpanContextFromContext(logged).TraceID() != id || command != "chat.user.talk" {
+		t.Fatalf("unexpected logger of command %v", command)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[1].Name != "chat.user.talk" || spans[1].Parent.SpanID() != span.SpanContext().SpanID() {
+		t.Fatalf("unexpected spans %+v", spans)
+	}
+	if spans[1].SpanKind != oteltrace.SpanKindServer {
+		t.Fatalf("unexpected kind %v", spans[1].SpanKind)
+	}
+
+	// an error response is recorded
+	_ = r.Serve(gw, pkt.New("chat.unknown"), &dim.Session{}, disp, sessions{})
+	if spans = exporter.GetSpans(); spans[2].Status.Description == "" {
+		t.Fatalf("unexpected status %+v", spans[2].Status)
+	}
+}
+
+func TestStdoutProvider(t *testing.T) {
+	var buf bytes.Buffer
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := tracing.NewProvider("gateway", exporter)
+	_, span := provider.Tracer(tracing.TracerName).Start(context.Background(), "chat.user.talk")
+	span.End()
+	if err = provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.Contains(out, "chat.user.talk") || !strings.Contains(out, "gateway") {
+		t.Fatalf("unexpected output %s", out)
+	}
+}
@@ -3,6 +3,7 @@ package websocket
 import (
 	"dim"
 	"dim/logger"
+	"dim/metrics"
 	"errors"
 	"fmt"
 	"net"
@@ -20,6 +21,12 @@ type ClientOptions struct {
 	Heartbeat time.Duration
 	ReadWait  time.Duration
 	WriteWait time.Duration
+	// Listener is set to receive the payloads read in background, Read is
+	// not available with it.
+	Listener dim.ClientListener
+	// ReadBuffer is the number of frames read in background and waiting for
+	// Read, the read loop blocks once it is full. Default to 64.
+	ReadBuffer int
 }
 
 // Client is a websocket implement of the terminal
@@ -33,6 +40,9 @@ type Client struct {
 	state   int32
 	options ClientOptions
 	dc      *dim.DialerContext
+	frames  chan dim.Frame
+	closed  *dim.Event
+	err     error
 }
 
 // NewClient
@@ -43,11 +53,15 @@ func NewClient(id, name string, opts ClientOptions) dim.Client {
 	if opts.ReadWait == 0 {
 		opts.ReadWait = dim.DefaultReadWait
 	}
+	if opts.ReadBuffer == 0 {
+		opts.ReadBuffer = 64
+	}
 
 	cli := &Client{
 		id:      id,
 		name:    name,
 		options: opts,
+		closed:  dim.NewEvent(),
 	}
 	return cli
 }
@@ -78,6 +92,7 @@ func (c *Client) Connect(addr string) error {
 	})
 
 	if err != nil {
+		metrics.DialFailures.WithLabelValues("websocket").Inc()
 		atomic.CompareAndSwapInt32(&c.state, 1, 0)
 		return err
 	}
@@ -85,6 +100,9 @@ func (c *Client) Connect(addr string) error {
 		return fmt.Errorf("conn is nil")
 	}
 	c.conn = conn
+	if c.options.Listener == nil {
+		c.frames = make(chan dim.Frame, c.options.ReadBuffer)
+	}
 
 	// step 2 set heartbeat
 	if c.options.Heartbeat > 0 {
@@ -96,6 +114,9 @@ func (c *Client) Connect(addr string) error {
 		}()
 	}
 
+	// step 3 read in background, so that pings are replied even if nobody
+	// is calling Read
+	go c.readloop()
 	return nil
 }
 
@@ -114,64 +135,147 @@ func (c *Client) Send(payload []byte) error {
 
 	err := c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
 	if err != nil {
-		return nil
+		return err
 	}
+	metrics.Frame(metrics.Out, dim.OpBinary.String(), len(payload))
 	return wsutil.WriteClientMessage(c.conn, ws.OpBinary, payload)
 }
 
 // Close
 func (c *Client) Close() {
 	c.once.Do(func() {
+		c.closed.Fire()
 		if c.conn == nil {
 			return
 		}
 
+		// the close frame is written with the lock, as the frames of Send
+		// and the heartbeat
+		c.Lock()
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
 		_ = wsutil.WriteClientMessage(c.conn, ws.OpClose, nil)
+		c.Unlock()
 
 		c.conn.Close()
 		atomic.CompareAndSwapInt32(&c.state, 1, 0)
 	})
 }
 
-// Read
+// Read the next frame, a ping from the server is replied with a pong, ping
+// and pong frames are not returned.
 func (c *Client) Read() (dim.Frame, error) {
-	if c.conn == nil {
+	if c.options.Listener != nil {
+		return nil, errors.New("frames are read by the listener")
+	}
+	if c.frames == nil {
 		return nil, errors.New("connection is nil")
 	}
-	if c.options.Heartbeat > 0 {
-		_ = c.conn.SetReadDeadline(time.Now().Add(c.options.ReadWait))
+	frame, ok := <-c.frames
+	if !ok {
+		return nil, c.Err()
 	}
-	frame, err := ws.ReadFrame(c.conn)
-	if err != nil {
-		return nil, err
+	return frame, nil
+}
+
+// Done returns a channel closed once the client is closed, or the background
+// read loop is stopped
+func (c *Client) Done() <-chan struct{} {
+	return c.closed.Done()
+}
+
+// Err returns the error the background read loop is stopped with
+func (c *Client) Err() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.err
+}
+
+func (c *Client) readloop() {
+	for {
+		frame, err := c.read()
+		if err != nil {
+			logger.WithFields(logger.Fields{
+				"module": "ws.client",
+				"id":     c.id,
+			}).Info(err)
+			c.Lock()
+			c.err = err
+			c.Unlock()
+			c.Close()
+			if c.frames != nil {
+				close(c.frames)
+			}
+			return
+		}
+		if c.options.Listener != nil {
+			c.options.Listener.Receive(c, frame.GetPayload())
+			continue
+		}
+		select {
+		case c.frames <- frame:
+		case <-c.closed.Done():
+		}
 	}
+}
 
-	if frame.Header.OpCode == ws.OpClose {
-		return nil, errors.New("remove side close the channel")
+func (c *Client) read() (dim.Frame, error) {
+	if c.conn == nil {
+		return nil, errors.New("connection is nil")
 	}
+	for {
+		if c.options.Heartbeat > 0 {
+			_ = c.conn.SetReadDeadline(time.Now().Add(c.options.ReadWait))
+		}
+		frame, err := ws.ReadFrame(c.conn)
+		if err != nil {
+			var nerr net.Error
+			if errors.As(err, &nerr) && nerr.Timeout() {
+				metrics.HeartbeatTimeouts.WithLabelValues("client").Inc()
+			}
+			return nil, err
+		}
+		metrics.Frame(metrics.In, dim.OpCode(frame.Header.OpCode).String(), len(frame.Payload))
 
-	return &Frame{
-		raw: frame,
-	}, nil
+		switch frame.Header.OpCode {
+		case ws.OpClose:
+			return nil, errors.New("remote side close the channel")
+		case ws.OpPing:
+			logger.Tracef("%s recv a ping; resp with a pong", c.id)
+			if err = c.write(c.conn, ws.OpPong); err != nil {
+				return nil, err
+			}
+		case ws.OpPong:
+		default:
+			return &Frame{
+				raw: frame,
+			}, nil
+		}
+	}
 }
 
 func (c *Client) heartbealoop(conn net.Conn) error {
 	tick := time.NewTicker(c.options.Heartbeat)
-	for range tick.C {
-		if err := c.ping(conn); err != nil {
-			return err
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			logger.Tracef("%s send ping to server", c.id)
+			if err := c.write(conn, ws.OpPing); err != nil {
+				return err
+			}
+		case <-c.closed.Done():
+			return nil
 		}
 	}
-	return nil
 }
 
-func (c *Client) ping(conn net.Conn) error {
+func (c *Client) write(conn net.Conn, code ws.OpCode) error {
 	c.Lock()
 	defer c.Unlock()
 	err := conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
 	if err != nil {
 		return err
 	}
-	logger.Trace("%s send ping to server", c.id)
-	return wsutil.WriteClientMessage(conn, ws.OpPing, nil)
+	metrics.Frame(metrics.Out, dim.OpCode(code).String(), 0)
+	return wsutil.WriteClientMessage(conn, code, nil)
 }
@@ -0,0 +1,76 @@
+package websocket
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"dim"
+
+	"github.com/gobwas/ws"
+)
+
+type dialer struct{}
+
+func (d *dialer) DialAndHandshake(ctx dim.DialerContext) (net.Conn, error) {
+	conn, _, _, err := ws.Dial(context.Background(), ctx.Address)
+	return conn, err
+}
+
+func TestClientPong(t *testing.T) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lst.Close()
+	pong := make(chan struct{})
+	go func() {
+		rawconn, err := lst.Accept()
+		if err != nil {
+			return
+		}
+		defer rawconn.Close()
+		if _, err = ws.Upgrade(rawconn); err != nil {
+			return
+		}
+		conn := NewConn(rawconn)
+		// the client speaks first, so that nothing is buffered by ws.Dial
+		if _, err = conn.ReadFrame(); err != nil {
+			return
+		}
+		// the ping is replied before the client reads
+		_ = conn.WriteFrame(dim.OpPing, nil)
+		frame, err := conn.ReadFrame()
+		if err != nil || frame.GetOpCode() != dim.OpPong {
+			return
+		}
+		close(pong)
+		_ = conn.WriteFrame(dim.OpBinary, []byte("done"))
+		// wait for the client to close
+		_, _ = conn.ReadFrame()
+	}()
+
+	cli := NewClient("c1", "client", ClientOptions{})
+	cli.SetDialer(&dialer{})
+	if err = cli.Connect("ws://" + lst.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	if err = cli.Send([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-pong:
+	case <-time.After(time.Second):
+		t.Fatal("pong is not sent")
+	}
+	frame, err := cli.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(frame.GetPayload()) != "done" {
+		t.Fatalf("expect done, got %s", frame.GetPayload())
+	}
+}
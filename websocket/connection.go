@@ -3,6 +3,7 @@ package websocket
 import (
 	"dim"
 	"net"
+	"net/http"
 
 	"github.com/gobwas/ws"
 )
@@ -33,6 +34,9 @@ func (f *Frame) GetPayload() []byte {
 
 type WsConn struct {
 	net.Conn
+	req      *http.Request
+	protocol string
+	client   bool
 }
 
 func NewConn(conn net.Conn) *WsConn {
@@ -41,6 +45,34 @@ func NewConn(conn net.Conn) *WsConn {
 	}
 }
 
+// NewClientConn returns a Conn of the client side, frames written are masked
+func NewClientConn(conn net.Conn) *WsConn {
+	return &WsConn{
+		Conn:   conn,
+		client: true,
+	}
+}
+
+// Request returns the http upgrade request, it is nil on the client side
+func (c *WsConn) Request() *http.Request {
+	return c.req
+}
+
+// Subprotocol returns the negotiated subprotocol
+func (c *WsConn) Subprotocol() string {
+	return c.protocol
+}
+
+// Request returns the http upgrade request of a connection accepted by the
+// websocket server, so that an Acceptor can read the headers, url and cookies.
+func Request(conn dim.Conn) (*http.Request, bool) {
+	c, ok := conn.(interface{ Request() *http.Request })
+	if !ok || c.Request() == nil {
+		return nil, false
+	}
+	return c.Request(), true
+}
+
 func (c *WsConn) ReadFrame() (dim.Frame, error) {
 	f, err := ws.ReadFrame(c.Conn)
 	if err != nil {
@@ -51,6 +83,9 @@ func (c *WsConn) ReadFrame() (dim.Frame, error) {
 
 func (c *WsConn) WriteFrame(code dim.OpCode, payload []byte) error {
 	f := ws.NewFrame(ws.OpCode(code), true, payload)
+	if c.client {
+		f = ws.MaskFrameInPlace(f)
+	}
 	return ws.WriteFrame(c.Conn, f)
 }
 
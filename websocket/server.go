@@ -6,12 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"dim/naming"
 
 	"dim/logger"
+	"dim/metrics"
 
 	"github.com/gobwas/ws"
 	"github.com/segmentio/ksuid"
@@ -19,9 +21,59 @@ import (
 
 // ServerOptions ServerOptions
 type ServerOptions struct {
-	loginwait time.Duration
-	readwait  time.Duration
-	writewait time.Duration
+	loginwait    time.Duration
+	readwait     time.Duration
+	writewait    time.Duration
+	origins      []string
+	subprotocols []string
+}
+
+// ServerOption ServerOption
+type ServerOption func(opts *ServerOptions)
+
+// WithOrigins only upgrade requests from the given origins, "*" matches any origin.
+// Requests without an Origin header are not sent by browsers and are always accepted.
+func WithOrigins(origins ...string) ServerOption {
+	return func(opts *ServerOptions) {
+		opts.origins = origins
+	}
+}
+
+// WithSubprotocols set the subprotocols supported by the server, the first one
+// offered by the client and supported by the server is selected.
+func WithSubprotocols(protocols ...string) ServerOption {
+	return func(opts *ServerOptions) {
+		opts.subprotocols = protocols
+	}
+}
+
+// RequestAcceptor can be implemented by an Acceptor to check the http upgrade
+// request, a client is rejected with a http status code before upgrading if
+// AcceptRequest returns an error. Use HTTPError to choose the status code,
+// http.StatusUnauthorized is used otherwise.
+type RequestAcceptor interface {
+	AcceptRequest(r *http.Request) error
+}
+
+// HTTPError is an error with a http status code, Err is the cause of it if any
+type HTTPError struct {
+	Code   int
+	Reason string
+	Err    error
+}
+
+// NewHTTPError NewHTTPError
+func NewHTTPError(code int, reason string) *HTTPError {
+	return &HTTPError{Code: code, Reason: reason}
+}
+
+func (e *HTTPError) Error() string {
+	return e.Reason
+}
+
+// Unwrap returns the cause of the error
+func (e *HTTPError) Unwrap() error {
+	return e.Err
 }
 
 // websocket implent of the server interface
@@ -37,17 +89,20 @@ type Server struct {
 }
 
 // NewServer NewServer
-func NewServer(listen string, service naming.ServiceRegistration) dim.Server {
+func NewServer(listen string, service naming.ServiceRegistration, opts ...ServerOption) dim.Server {
+	options := ServerOptions{
+		loginwait: dim.DefaultLoginWait,
+		readwait:  dim.DefaultReadWait,
+		writewait: time.Second * 10,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
 	return &Server{
 		listen:              listen,
 		ServiceRegistration: service,
-		options: ServerOptions{
-			loginwait: dim.DefaultLoginWait,
-			readwait:  dim.DefaultReadWait,
-			writewait: time.Second * 10,
-		},
+		options:             options,
 	}
-	// return nil
 }
 
 type defaultAcceptor struct{}
@@ -72,25 +127,59 @@ func (s *Server) Start() error {
 	}
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// step1 update
-		rawconn, _, _, err := ws.UpgradeHTTP(r, w)
-		if err != nil {
-			resp(w, http.StatusBadRequest, err.Error())
+		// step1 check request
+		if !s.checkOrigin(r) {
+			metrics.Rejects.WithLabelValues("websocket", metrics.ReasonOrigin).Inc()
+			resp(w, http.StatusForbidden, "origin is not allowed")
 			return
 		}
+		if ra, ok := s.Acceptor.(RequestAcceptor); ok {
+			if err := ra.AcceptRequest(r); err != nil {
+				code := http.StatusUnauthorized
+				var herr *HTTPError
+				if errors.As(err, &herr) {
+					code = herr.Code
+				}
+				reason := metrics.ReasonRequest
+				if errors.Is(err, dim.ErrUnauthorized) {
+					reason = metrics.ReasonAuth
+				}
+				metrics.Rejects.WithLabelValues("websocket", reason).Inc()
+				resp(w, code, err.Error())
+				return
+			}
+		}
 
-		// step2 conn
+		// step2 upgrade
+		upgrader := ws.HTTPUpgrader{}
+		if len(s.options.subprotocols) > 0 {
+			upgrader.Protocol = s.supportProtocol
+		}
+		rawconn, _, hs, err := upgrader.Upgrade(r, w)
+		if err != nil {
+			metrics.Rejects.WithLabelValues("websocket", metrics.ReasonUpgrade).Inc()
+			log.Warnf("upgrade failed: %v", err)
+			return
+		}
 		conn := NewConn(rawconn)
+		conn.req = r
+		conn.protocol = hs.Protocol
 
 		// step3
 		id, err := s.Accept(conn, s.options.loginwait)
 		if err != nil {
+			reason := metrics.ReasonHandshake
+			if errors.Is(err, dim.ErrUnauthorized) {
+				reason = metrics.ReasonAuth
+			}
+			metrics.Rejects.WithLabelValues("websocket", reason).Inc()
 			_ = conn.WriteFrame(dim.OpClose, []byte(err.Error()))
 			conn.Close()
 			return
 		}
 		if _, ok := s.Get(id); ok {
 			log.Warnf("channel %s existed", id)
+			metrics.Rejects.WithLabelValues("websocket", metrics.ReasonDuplicate).Inc()
 			_ = conn.WriteFrame(dim.OpClose, []byte("channelId is repeated"))
 			conn.Close()
 			return
@@ -101,6 +190,8 @@ func (s *Server) Start() error {
 		channel.SetWriteWait(s.options.writewait)
 		channel.SetReadWait(s.options.readwait)
 		s.Add(channel)
+		metrics.Accepts.WithLabelValues("websocket").Inc()
+		metrics.Connections.WithLabelValues("websocket").Inc()
 
 		go func(ch dim.Channel) {
 			// step5
@@ -111,6 +202,7 @@ func (s *Server) Start() error {
 
 			// step6
 			s.Remove(ch.ID())
+			metrics.Connections.WithLabelValues("websocket").Dec()
 			err = s.Disconnect(ch.ID())
 			if err != nil {
 				log.Warn(err)
@@ -187,12 +279,37 @@ func (s *Server) SetReadWait(readwait time.Duration) {
 	s.options.readwait = readwait
 }
 
+func (s *Server) checkOrigin(r *http.Request) bool {
+	if len(s.options.origins) == 0 {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range s.options.origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) supportProtocol(protocol string) bool {
+	for _, p := range s.options.subprotocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
 func resp(w http.ResponseWriter, code int, body string) {
 	w.WriteHeader(code)
 	if body != "" {
 		_, _ = w.Write([]byte(body))
 	}
-	logger.Warn("response with code:%d %s", code, body)
+	logger.Warnf("response with code:%d %s", code, body)
 }
 
 // Accept defaultAcceptor
@@ -0,0 +1,135 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"dim"
+	"dim/naming"
+
+	"github.com/gobwas/ws"
+)
+
+type listener struct{}
+
+func (l *listener) Disconnect(id string) error { return nil }
+
+type acceptor struct {
+	protocols chan string
+	reject    error
+}
+
+func (a *acceptor) AcceptRequest(r *http.Request) error {
+	return a.reject
+}
+
+func (a *acceptor) Accept(conn dim.Conn, timeout time.Duration) (string, error) {
+	a.protocols <- conn.(*WsConn).Subprotocol()
+	return "c1", nil
+}
+
+// start a server with the acceptor and returns its address
+func start(t *testing.T, acc *acceptor, opts ...ServerOption) string {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lst.Addr().String()
+	lst.Close()
+
+	srv := NewServer(addr, &naming.DefaultService{Id: "ws01", Name: "gateway"}, opts...)
+	srv.SetAcceptor(acc)
+	srv.SetStateListener(&listener{})
+	go func() { _ = srv.Start() }()
+	t.Cleanup(func() { _ = srv.Shutdown(context.Background()) })
+
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return addr
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server %s not started: %v", addr, err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// dial returns the status code and body of a rejected upgrade
+func dial(addr string, d ws.Dialer) (ws.Handshake, int, string, error) {
+	var (
+		code int
+		body string
+	)
+	d.OnStatusError = func(status int, reason []byte, resp io.Reader) {
+		code = status
+		r, err := http.ReadResponse(bufio.NewReader(resp), nil)
+		if err != nil {
+			return
+		}
+		defer r.Body.Close()
+		buf, _ := io.ReadAll(r.Body)
+		body = string(buf)
+	}
+	conn, _, hs, err := d.Dial(context.Background(), "ws://"+addr)
+	if err == nil {
+		conn.Close()
+	}
+	return hs, code, body, err
+}
+
+func TestServerOrigins(t *testing.T) {
+	acc := &acceptor{protocols: make(chan string, 1)}
+	addr := start(t, acc, WithOrigins("https://dim.io"))
+
+	_, code, _, err := dial(addr, ws.Dialer{Header: ws.HandshakeHeaderHTTP(http.Header{
+		"Origin": []string{"https://evil.io"},
+	})})
+	if err == nil || code != http.StatusForbidden {
+		t.Fatalf("unexpected result %d %v", code, err)
+	}
+
+	_, _, _, err = dial(addr, ws.Dialer{Header: ws.HandshakeHeaderHTTP(http.Header{
+		"Origin": []string{"https://DIM.io"},
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-acc.protocols
+}
+
+func TestServerSubprotocols(t *testing.T) {
+	acc := &acceptor{protocols: make(chan string, 1)}
+	addr := start(t, acc, WithSubprotocols("dim.v2", "dim.v1"))
+
+	hs, _, _, err := dial(addr, ws.Dialer{Protocols: []string{"mqtt", "dim.v1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hs.Protocol != "dim.v1" {
+		t.Fatalf("unexpected protocol %q", hs.Protocol)
+	}
+	if p := <-acc.protocols; p != "dim.v1" {
+		t.Fatalf("unexpected protocol %q of conn", p)
+	}
+}
+
+func TestServerHTTPError(t *testing.T) {
+	acc := &acceptor{
+		protocols: make(chan string, 1),
+		reject:    NewHTTPError(http.StatusTooManyRequests, "slow down"),
+	}
+	addr := start(t, acc)
+
+	_, code, body, err := dial(addr, ws.Dialer{})
+	if err == nil || code != http.StatusTooManyRequests || body != "slow down" {
+		t.Fatalf("unexpected result %d %q %v", code, body, err)
+	}
+}
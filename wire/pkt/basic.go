@@ -0,0 +1,72 @@
+package pkt
+
+import (
+	"bytes"
+	"io"
+
+	"dim/wire"
+	"dim/wire/endian"
+)
+
+// codes of BasicPkt
+const (
+	CodePing = uint16(1)
+	CodePong = uint16(2)
+	// CodeReliablePush is a push waiting for an ack
+	CodeReliablePush = uint16(3)
+	// CodeAck acks a reliable push
+	CodeAck = uint16(4)
+)
+
+// BasicPkt is a packet handled by the framework, not by logic services
+type BasicPkt struct {
+	Code uint16
+	Body []byte
+}
+
+// Encode the packet without magic
+func (p *BasicPkt) Encode(w io.Writer) error {
+	if err := endian.WriteUint16(w, p.Code); err != nil {
+		return err
+	}
+	return endian.WriteBytes(w, p.Body)
+}
+
+// Decode the packet without magic
+func (p *BasicPkt) Decode(r io.Reader) error {
+	var err error
+	if p.Code, err = endian.ReadUint16(r); err != nil {
+		return err
+	}
+	p.Body, err = endian.ReadBytes(r)
+	return err
+}
+
+// MarshalBasic encode a basic packet with its magic
+func MarshalBasic(p *BasicPkt) []byte {
+	buf := new(bytes.Buffer)
+	_, _ = buf.Write(wire.MagicBasicPkt[:])
+	_ = p.Encode(buf)
+	return buf.Bytes()
+}
+
+// Read decode a packet of buf by its magic, it returns a *LogicPkt or a *BasicPkt
+func Read(buf []byte) (interface{}, error) {
+	if len(buf) < len(wire.Magic{}) {
+		return nil, ErrMagic
+	}
+	var magic wire.Magic
+	copy(magic[:], buf)
+	switch magic {
+	case wire.MagicLogicPkt:
+		return Unmarshal(buf)
+	case wire.MagicBasicPkt:
+		p := new(BasicPkt)
+		if err := p.Decode(bytes.NewReader(buf[len(magic):])); err != nil {
+			return nil, err
+		}
+		return p, nil
+	default:
+		return nil, ErrMagic
+	}
+}
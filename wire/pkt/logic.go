@@ -0,0 +1,273 @@
+package pkt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"dim/wire"
+	"dim/wire/endian"
+)
+
+// Flag is the kind of a packet
+type Flag uint8
+
+// Flag Flag
+const (
+	FlagRequest Flag = iota
+	FlagResponse
+	FlagPush
+)
+
+// Status is the result of a request
+type Status uint16
+
+// Status Status
+const (
+	Success Status = 0
+	// client errors
+	NoDestination     Status = 100
+	InvalidPacketBody Status = 101
+	InvalidCommand    Status = 103
+	NotFound          Status = 104
+	Unauthorized      Status = 105
+	Forbidden         Status = 106
+	Rejected          Status = 107
+	RequestTimeout    Status = 108
+	// server errors
+	SystemException Status = 300
+	NotImplemented  Status = 301
+)
+
+// Header Header
+type Header struct {
+	Command   string
+	ChannelID string
+	Sequence  uint32
+	Flag      Flag
+	Status    Status
+	Dest      string
+	Meta      map[string]string
+}
+
+// LogicPkt is the packet of logic services
+type LogicPkt struct {
+	Header
+	Body []byte
+}
+
+// HeaderOption HeaderOption
+type HeaderOption func(*Header)
+
+// WithStatus WithStatus
+func WithStatus(status Status) HeaderOption {
+	return func(h *Header) {
+		h.Status = status
+	}
+}
+
+// WithSeq WithSeq
+func WithSeq(seq uint32) HeaderOption {
+	return func(h *Header) {
+		h.Sequence = seq
+	}
+}
+
+// WithChannel WithChannel
+func WithChannel(channelID string) HeaderOption {
+	return func(h *Header) {
+		h.ChannelID = channelID
+	}
+}
+
+// WithDest WithDest
+func WithDest(dest string) HeaderOption {
+	return func(h *Header) {
+		h.Dest = dest
+	}
+}
+
+// WithFlag WithFlag
+func WithFlag(flag Flag) HeaderOption {
+	return func(h *Header) {
+		h.Flag = flag
+	}
+}
+
+// New a request packet of command
+func New(command string, opts ...HeaderOption) *LogicPkt {
+	p := &LogicPkt{}
+	p.Command = command
+	for _, opt := range opts {
+		opt(&p.Header)
+	}
+	return p
+}
+
+// NewFrom returns the response packet of a request header
+func NewFrom(header *Header) *LogicPkt {
+	p := &LogicPkt{}
+	p.Command = header.Command
+	p.ChannelID = header.ChannelID
+	p.Sequence = header.Sequence
+	p.Flag = FlagResponse
+	p.Dest = header.Dest
+	p.Status = header.Status
+	if len(header.Meta) > 0 {
+		p.Meta = make(map[string]string, len(header.Meta))
+		for k, v := range header.Meta {
+			p.Meta[k] = v
+		}
+	}
+	return p
+}
+
+// WriteBody set the body, v is encoded as json unless it is a []byte
+func (p *LogicPkt) WriteBody(v interface{}) *LogicPkt {
+	if v == nil {
+		return p
+	}
+	if buf, ok := v.([]byte); ok {
+		p.Body = buf
+		return p
+	}
+	p.Body, _ = json.Marshal(v)
+	return p
+}
+
+// ReadBody decode the json body into v
+func (p *LogicPkt) ReadBody(v interface{}) error {
+	return json.Unmarshal(p.Body, v)
+}
+
+// AddMeta AddMeta
+func (p *LogicPkt) AddMeta(key, value string) {
+	if p.Meta == nil {
+		p.Meta = make(map[string]string)
+	}
+	p.Meta[key] = value
+}
+
+// GetMeta GetMeta
+func (p *LogicPkt) GetMeta(key string) (string, bool) {
+	v, ok := p.Meta[key]
+	return v, ok
+}
+
+// DelMeta DelMeta
+func (p *LogicPkt) DelMeta(key string) {
+	delete(p.Meta, key)
+}
+
+// String String
+func (p *LogicPkt) String() string {
+	return fmt.Sprintf("cmd:%s ch:%s seq:%d flag:%d status:%d dest:%s meta:%v body:%dB",
+		p.Command, p.ChannelID, p.Sequence, p.Flag, p.Status, p.Dest, p.Meta, len(p.Body))
+}
+
+// Encode the packet without magic
+func (p *LogicPkt) Encode(w io.Writer) error {
+	header := new(bytes.Buffer)
+	_ = endian.WriteShortBytes(header, []byte(p.Command))
+	_ = endian.WriteShortBytes(header, []byte(p.ChannelID))
+	_ = endian.WriteUint32(header, p.Sequence)
+	_ = endian.WriteUint8(header, uint8(p.Flag))
+	_ = endian.WriteUint16(header, uint16(p.Status))
+	_ = endian.WriteShortBytes(header, []byte(p.Dest))
+	_ = endian.WriteUint16(header, uint16(len(p.Meta)))
+	for k, v := range p.Meta {
+		_ = endian.WriteShortBytes(header, []byte(k))
+		_ = endian.WriteShortBytes(header, []byte(v))
+	}
+	if err := endian.WriteBytes(w, header.Bytes()); err != nil {
+		return err
+	}
+	return endian.WriteBytes(w, p.Body)
+}
+
+// Decode the packet without magic
+func (p *LogicPkt) Decode(r io.Reader) error {
+	header, err := endian.ReadBytes(r)
+	if err != nil {
+		return err
+	}
+	if err = p.decodeHeader(bytes.NewReader(header)); err != nil {
+		return err
+	}
+	p.Body, err = endian.ReadBytes(r)
+	return err
+}
+
+func (p *LogicPkt) decodeHeader(r io.Reader) (err error) {
+	if p.Command, err = endian.ReadShortString(r); err != nil {
+		return err
+	}
+	if p.ChannelID, err = endian.ReadShortString(r); err != nil {
+		return err
+	}
+	if p.Sequence, err = endian.ReadUint32(r); err != nil {
+		return err
+	}
+	flag, err := endian.ReadUint8(r)
+	if err != nil {
+		return err
+	}
+	p.Flag = Flag(flag)
+	status, err := endian.ReadUint16(r)
+	if err != nil {
+		return err
+	}
+	p.Status = Status(status)
+	if p.Dest, err = endian.ReadShortString(r); err != nil {
+		return err
+	}
+	n, err := endian.ReadUint16(r)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		p.Meta = make(map[string]string, n)
+	}
+	for i := 0; i < int(n); i++ {
+		k, err := endian.ReadShortString(r)
+		if err != nil {
+			return err
+		}
+		v, err := endian.ReadShortString(r)
+		if err != nil {
+			return err
+		}
+		p.Meta[k] = v
+	}
+	return nil
+}
+
+// Marshal encode a packet with its magic
+func Marshal(p *LogicPkt) []byte {
+	buf := new(bytes.Buffer)
+	_, _ = buf.Write(wire.MagicLogicPkt[:])
+	_ = p.Encode(buf)
+	return buf.Bytes()
+}
+
+// ErrMagic is returned reading a packet with an unknown magic
+var ErrMagic = errors.New("magic code is incorrect")
+
+// Unmarshal decode a packet encoded by Marshal
+func Unmarshal(buf []byte) (*LogicPkt, error) {
+	r := bytes.NewReader(buf)
+	magic := wire.Magic{}
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != wire.MagicLogicPkt {
+		return nil, ErrMagic
+	}
+	p := new(LogicPkt)
+	if err := p.Decode(r); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
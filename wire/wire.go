@@ -0,0 +1,11 @@
+package wire
+
+// Magic Magic
+type Magic [4]byte
+
+var (
+	// MagicLogicPkt is the magic of logic packets
+	MagicLogicPkt = Magic{0xc3, 0x11, 0xa3, 0x65}
+	// MagicBasicPkt is the magic of basic packets
+	MagicBasicPkt = Magic{0xc3, 0x15, 0xa7, 0x65}
+)